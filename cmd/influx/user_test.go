@@ -115,7 +115,7 @@ func TestCmdUser(t *testing.T) {
 		cmdFn := func(expected userResult) func(*globalFlags, genericCLIOpts) *cobra.Command {
 			svc := mock.NewUserService()
 			svc.CreateUserFn = func(ctx context.Context, User *influxdb.User) error {
-				if expected.user != *User {
+				if !reflect.DeepEqual(expected.user, *User) {
 					return fmt.Errorf("unexpected User;\n\twant= %+v\n\tgot=  %+v", expected, *User)
 				}
 				return nil
@@ -117,6 +117,12 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("<%s>", e.Code)
 }
 
+// Unwrap returns the wrapped error so errors.Is and errors.As can see through
+// an Error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
 // ErrorCode returns the code of the root error, if available; otherwise returns EINTERNAL.
 func ErrorCode(err error) string {
 	if err == nil {
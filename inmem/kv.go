@@ -293,8 +293,9 @@ func (b *Bucket) ForwardCursor(seek []byte, opts ...kv.CursorOption) (kv.Forward
 		if config.Direction == kv.CursorDescending {
 			iterate = b.descend
 			if len(seek) == 0 {
-				seek = b.btree.Max().(*item).key
-
+				if max := b.btree.Max(); max != nil {
+					seek = max.(*item).key
+				}
 			}
 		}
 
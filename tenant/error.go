@@ -2,6 +2,7 @@ package tenant
 
 import (
 	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
 )
 
 var (
@@ -10,6 +11,13 @@ var (
 		Code: influxdb.EInvalid,
 		Msg:  "name is empty",
 	}
+
+	// ErrReadOnlyTransaction is used when a write method is handed a read only
+	// transaction.
+	ErrReadOnlyTransaction = &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  "write attempted in a read only transaction",
+	}
 )
 
 // ErrCorruptID the ID stored in the Store is corrupt.
@@ -28,3 +36,12 @@ func ErrInternalServiceError(err error) *influxdb.Error {
 		Err:  err,
 	}
 }
+
+// ErrWriteFailed is used when a write to the kv store fails. A write rejected
+// because the transaction is read only is reported as ErrReadOnlyTransaction.
+func ErrWriteFailed(err error) *influxdb.Error {
+	if err == kv.ErrTxNotWritable {
+		return ErrReadOnlyTransaction
+	}
+	return ErrInternalServiceError(err)
+}
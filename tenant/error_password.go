@@ -0,0 +1,43 @@
+package tenant
+
+import (
+	"fmt"
+
+	"github.com/influxdata/influxdb"
+)
+
+var (
+	// EShortPassword is used when a password is less than the minimum
+	// acceptable password length.
+	EShortPassword = &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  "passwords must be at least 8 characters long",
+	}
+
+	// ErrPasswordNotFound is used when the user has no password set.
+	ErrPasswordNotFound = &influxdb.Error{
+		Code: influxdb.ENotFound,
+		Msg:  "password not found",
+	}
+)
+
+// UnavailablePasswordServiceError is used if we aren't able to add the
+// password to the store, it means the store is not available at the moment
+// (e.g. network).
+func UnavailablePasswordServiceError(err error) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EUnavailable,
+		Msg:  fmt.Sprintf("Unable to connect to password service. Please try again; Err: %v", err),
+		Op:   "kv/setPassword",
+	}
+}
+
+// InternalPasswordHashError is used if the hasher is unable to generate
+// a hash of the password.  This is some sort of internal server error.
+func InternalPasswordHashError(err error) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  fmt.Sprintf("Unable to generate password; Err: %v", err),
+		Op:   "kv/setPassword",
+	}
+}
@@ -12,6 +12,108 @@ var (
 		Msg:  "user not found",
 		Code: influxdb.ENotFound,
 	}
+
+	// ErrUnprocessableUserName is matched by every specific user name error
+	// through errors.Is.
+	ErrUnprocessableUserName = &influxdb.Error{
+		Code: influxdb.EUnprocessableEntity,
+		Msg:  "user name is unprocessable",
+	}
+
+	// ErrUserNameRequired is used when a user name is empty.
+	ErrUserNameRequired = &influxdb.Error{
+		Code: influxdb.EUnprocessableEntity,
+		Msg:  "user name is required",
+		Err:  ErrUnprocessableUserName,
+	}
+
+	// ErrUserNameTooLong is used when a user name is longer than
+	// MaxUserNameLength bytes.
+	ErrUserNameTooLong = &influxdb.Error{
+		Code: influxdb.EUnprocessableEntity,
+		Msg:  fmt.Sprintf("user name is longer than %d bytes", MaxUserNameLength),
+		Err:  ErrUnprocessableUserName,
+	}
+
+	// ErrUserNameReserved is used when a user name is one of the store's
+	// reserved names.
+	ErrUserNameReserved = &influxdb.Error{
+		Code: influxdb.EUnprocessableEntity,
+		Msg:  "user name is reserved",
+		Err:  ErrUnprocessableUserName,
+	}
+
+	// ErrUserNameInvalidChars is used when a user name holds control
+	// characters, the UserIndexKeyDelimiter or isn't valid UTF-8.
+	ErrUserNameInvalidChars = &influxdb.Error{
+		Code: influxdb.EUnprocessableEntity,
+		Msg:  "user name contains invalid characters",
+		Err:  ErrUnprocessableUserName,
+	}
+
+	// ErrUserNameOutOfScope is used when a user name written through a
+	// scoped store doesn't start with its prefix.
+	ErrUserNameOutOfScope = &influxdb.Error{
+		Code: influxdb.EUnprocessableEntity,
+		Msg:  "user name is outside the store's scope",
+		Err:  ErrUnprocessableUserName,
+	}
+
+	// ErrUserNameTaken is used when the external uniqueness authority reports
+	// a user name is already taken.
+	ErrUserNameTaken = &influxdb.Error{
+		Code: influxdb.EConflict,
+		Msg:  "user name is already taken",
+	}
+
+	// ErrInvalidDeleteToken is used when a user delete is confirmed with a
+	// token that wasn't issued for that user.
+	ErrInvalidDeleteToken = &influxdb.Error{
+		Code: influxdb.EForbidden,
+		Msg:  "user delete token is invalid",
+	}
+
+	// ErrDeleteTokenExpired is used when a user delete is confirmed with a
+	// token older than the store's delete token ttl.
+	ErrDeleteTokenExpired = &influxdb.Error{
+		Code: influxdb.EForbidden,
+		Msg:  "user delete token has expired",
+	}
+
+	// ErrScanLimitExceeded is used when a listing would traverse more
+	// records than the store's scan limit allows.
+	ErrScanLimitExceeded = &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  "user listing scanned too many records; narrow the filter or lower the offset",
+	}
+
+	// ErrCannotDeleteLastUser is used when deleting a user would leave a
+	// store guarding its last user without any.
+	ErrCannotDeleteLastUser = &influxdb.Error{
+		Code: influxdb.EConflict,
+		Msg:  "cannot delete the last remaining user",
+	}
+
+	// ErrUserIndexInconsistent is used when the name index points at a user
+	// stored under another name and the store is set to fail such reads.
+	ErrUserIndexInconsistent = &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  "user name index is inconsistent with the stored user",
+	}
+
+	// ErrTooManyMatches is used when a bulk delete matches more users than
+	// the caller said to expect.
+	ErrTooManyMatches = &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  "too many users match; nothing was deleted",
+	}
+
+	// ErrUnsupportedSort is used when users are listed with a sort field
+	// that isn't supported.
+	ErrUnsupportedSort = &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  "unsupported sort field; users can be sorted by id or name",
+	}
 )
 
 // UserAlreadyExistsError is used when attempting to create a user with a name
@@ -23,6 +125,25 @@ func UserAlreadyExistsError(n string) *influxdb.Error {
 	}
 }
 
+// DuplicateUserIDError is used when a batch of new users holds the same id
+// more than once.
+func DuplicateUserIDError(id influxdb.ID) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EConflict,
+		Msg:  fmt.Sprintf("user id %s is used more than once in the batch", id),
+	}
+}
+
+// MissingUserError is used when a batch operation configured to fail on
+// missing users is given an id that doesn't exist.
+func MissingUserError(id influxdb.ID) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.ENotFound,
+		Msg:  fmt.Sprintf("user %s not found", id),
+		Err:  ErrUserNotFound,
+	}
+}
+
 // UnexpectedUserBucketError is used when the error comes from an internal system.
 func UnexpectedUserBucketError(err error) *influxdb.Error {
 	return &influxdb.Error{
@@ -71,3 +192,124 @@ func ErrUnprocessableUser(err error) *influxdb.Error {
 		Op:   "kv/MarshalUser",
 	}
 }
+
+// ErrUserSelfTest is used when the user store self test reads back something
+// other than what it wrote.
+func ErrUserSelfTest(msg string) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  "user self test failed: " + msg,
+		Op:   "kv/SelfTestUsers",
+	}
+}
+
+// ErrCorruptUserAudit is used when a user audit entry cannot be unmarshalled
+// from the bytes stored in the kv.
+func ErrCorruptUserAudit(err error) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  "user audit entry could not be unmarshalled",
+		Err:  err,
+		Op:   "kv/UnmarshalUserAudit",
+	}
+}
+
+// ErrCorruptUserLogin is used when a last login time cannot be decoded from
+// the bytes stored in the kv.
+func ErrCorruptUserLogin(err error) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  "user last login could not be decoded",
+		Err:  err,
+		Op:   "kv/DecodeUserLogin",
+	}
+}
+
+// InvalidUserNameError is used when a user name is rejected by the name
+// validator. It matches both err and ErrUnprocessableUserName through
+// errors.Is, and keeps err's code when it is an *influxdb.Error.
+func InvalidUserNameError(err error) *influxdb.Error {
+	code := influxdb.EInvalid
+	if _, ok := err.(*influxdb.Error); ok {
+		code = influxdb.ErrorCode(err)
+	}
+
+	return &influxdb.Error{
+		Code: code,
+		Msg:  "user name is invalid",
+		Err:  &userNameError{err: err},
+	}
+}
+
+// userNameError wraps a name validator's error so it is also seen as
+// ErrUnprocessableUserName.
+type userNameError struct {
+	err error
+}
+
+func (e *userNameError) Error() string {
+	return e.err.Error()
+}
+
+func (e *userNameError) Unwrap() error {
+	return e.err
+}
+
+func (e *userNameError) Is(target error) bool {
+	return target == ErrUnprocessableUserName
+}
+
+// InvalidUserLabelError is used when a user label can't be indexed.
+func InvalidUserLabelError(key string) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  fmt.Sprintf("user label %q is invalid; keys must be non empty without '=' and labels may not contain NUL", key),
+	}
+}
+
+// UserWriteVerificationError is used when a verified write can't be read back
+// as it was written.
+func UserWriteVerificationError(msg string, err error) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  msg,
+		Err:  err,
+		Op:   "kv/VerifyUserWrite",
+	}
+}
+
+// UnknownUserIndexFieldError is used when an IndexConfig names a field that
+// can't be indexed as configured.
+func UnknownUserIndexFieldError(field string) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  fmt.Sprintf("user field %q can't be indexed as configured", field),
+	}
+}
+
+// UnindexedUserFieldError is used when users are looked up by a field that
+// has no index.
+func UnindexedUserFieldError(field string) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  fmt.Sprintf("user field %q is not indexed", field),
+	}
+}
+
+// InvalidUserIndexValueError is used when an indexed field holds a value that
+// can't be indexed.
+func InvalidUserIndexValueError(field string) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  fmt.Sprintf("user field %q may not contain NUL", field),
+	}
+}
+
+// UserFieldConflictError is used when a unique field value is already held by
+// another user.
+func UserFieldConflictError(field, value string) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EConflict,
+		Msg:  fmt.Sprintf("user with %s %s already exists", field, value),
+	}
+}
@@ -2,39 +2,558 @@ package tenant
 
 import (
 	"context"
+	"crypto/rand"
+	"sync"
+	"time"
 
+	"github.com/influxdata/influxdb"
 	"github.com/influxdata/influxdb/kv"
+	"go.uber.org/zap"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 )
 
+// IDEncoder encodes a user id into the key it is stored under.
+type IDEncoder func(influxdb.ID) ([]byte, error)
+
+// IDDecoder decodes a stored key back into a user id.
+type IDDecoder func([]byte) (influxdb.ID, error)
+
+// IDValidator checks the id of a user before it is created.
+type IDValidator func(influxdb.ID) error
+
+// NameValidator checks a user name before it is written.
+type NameValidator func(name string) error
+
+// ExternalUniquenessChecker reports whether a user name is already taken in
+// an external directory.
+type ExternalUniquenessChecker func(ctx context.Context, name string) (bool, error)
+
+// UserSchemaValidator checks a marshalled user before it is written.
+type UserSchemaValidator func(raw []byte) error
+
+// Store persists tenant resources in a kv.Store. Its methods are safe for
+// concurrent use, the kv transactions provide isolation between them and the
+// configuration is fixed once NewStore returns.
 type Store struct {
 	kvStore kv.Store
+
+	userBucket    []byte
+	userIndex     []byte
+	hasher        kv.Crypt
+	clock         influxdb.TimeGenerator
+	idEncoder     IDEncoder
+	idDecoder     IDDecoder
+	idValidator   IDValidator
+	log           *zap.Logger
+	strictStatus  bool
+	defaultLimit  int
+	nameValidator NameValidator
+	reservedNames map[string]struct{}
+	nameAuthority ExternalUniquenessChecker
+	codec         UserCodec
+	schema        UserSchemaValidator
+	cipher        BlobCipher
+	legacyLayout  bool
+	verifyWrites  bool
+	foldNames     bool
+	dedupList     bool
+	maxScan       int
+	readAhead     int
+	stampCreated  bool
+	slowThreshold time.Duration
+	publisher     EventPublisher
+	failOnPublish bool
+	keepLastUser  bool
+	indexCheck    IndexConsistency
+	collator      *collate.Collator
+	indexConfig   IndexConfig
+	fieldIndexes  []fieldIndex
+	deleteSecret  []byte
+	deleteTTL     time.Duration
+	retry         *RetryPolicy
+
+	// scope limits the store to users whose names start with it
+	scope string
+
+	// the buckets kept alongside the user table
+	passwordBucket []byte
+	metaBucket     []byte
+	loginBucket    []byte
+	fieldBucket    []byte
+	auditBucket    []byte
+	expiryIndex    []byte
+	createdIndex   []byte
+
+	// shared is the state a store has in common with its scoped stores
+	shared *storeShared
+
+	poolWorkers int
+	poolQueue   int
+	poolPolicy  HookBackpressure
+}
+
+// storeShared is the mutable state of a Store, shared with the stores
+// ScopedByPrefix returns so they see the same hooks.
+type storeShared struct {
+	// collateMu serializes use of the collator, which keeps per call state
+	collateMu sync.Mutex
+
+	hooksMu     sync.RWMutex
+	hooks       []UserHook
+	projections []ProjectionUpdater
+	asyncHooks  []AsyncUserHook
+	pool        *hookPool
+	commitHooks []commitHook
+}
+
+// StoreOption configures a Store as it is built.
+type StoreOption func(*Store)
+
+// WithUserBuckets names the buckets holding the user blobs and the user name
+// index, so several user tables can share a kv store. They default to
+// usersv1 and userindexv1. The passwords, metadata, last logins, field
+// indexes, audit log and time indexes of the table are kept in buckets named
+// after bucket, so tables sharing a kv store never see each other's.
+func WithUserBuckets(bucket, index []byte) StoreOption {
+	return func(s *Store) {
+		s.userBucket = bucket
+		s.userIndex = index
+		s.passwordBucket = userTableBucket(bucket, userpasswordBucket)
+		s.metaBucket = userTableBucket(bucket, userMetaBucket)
+		s.loginBucket = userTableBucket(bucket, userLoginBucket)
+		s.fieldBucket = userTableBucket(bucket, userFieldIndex)
+		s.auditBucket = userTableBucket(bucket, userAuditBucket)
+		s.expiryIndex = userTableBucket(bucket, userExpiryIndex)
+		s.createdIndex = userTableBucket(bucket, userCreatedIndex)
+	}
+}
+
+// userTableBucket names the bucket called name belonging to the user table
+// stored in bucket.
+func userTableBucket(bucket, name []byte) []byte {
+	return []byte(string(bucket) + "/" + string(name))
+}
+
+// WithLegacyLayout reads and writes users in the layout of older versions,
+// where the user blobs and the name index share the single bucket. It lets
+// operators serve old data until MigrateLegacyLayout has been run.
+func WithLegacyLayout(bucket []byte) StoreOption {
+	return func(s *Store) {
+		s.userBucket = bucket
+		s.userIndex = bucket
+		s.legacyLayout = true
+	}
+}
+
+// WithPasswordHasher sets the hasher used for user passwords. It defaults to
+// bcrypt.
+func WithPasswordHasher(h kv.Crypt) StoreOption {
+	return func(s *Store) {
+		s.hasher = h
+	}
+}
+
+// WithClock sets the source of the current time. It defaults to the real
+// time.
+func WithClock(clock influxdb.TimeGenerator) StoreOption {
+	return func(s *Store) {
+		s.clock = clock
+	}
+}
+
+// WithIDEncoding sets how user ids are converted to and from their stored
+// keys. It defaults to the 16 character hex encoding of influxdb.ID.
+func WithIDEncoding(enc IDEncoder, dec IDDecoder) StoreOption {
+	return func(s *Store) {
+		s.idEncoder = enc
+		s.idDecoder = dec
+	}
+}
+
+// WithIDValidator sets a check run on the ids of users as they are created,
+// for backends that reject some ids. Rejected ids fail the create as invalid.
+func WithIDValidator(v IDValidator) StoreOption {
+	return func(s *Store) {
+		s.idValidator = v
+	}
+}
+
+// WithLogger sets the logger used to report problems found in the store.
+func WithLogger(log *zap.Logger) StoreOption {
+	return func(s *Store) {
+		s.log = log
+	}
+}
+
+// WithStrictStatus rejects stored users with an unknown status as corrupt
+// when they are read. It is off by default for compatibility with records
+// written by older clients.
+func WithStrictStatus() StoreOption {
+	return func(s *Store) {
+		s.strictStatus = true
+	}
 }
 
-func NewStore(kvStore kv.Store) (*Store, error) {
+// WithDefaultLimit sets the page size used when users are listed without
+// find options. It defaults to influxdb.DefaultPageSize.
+func WithDefaultLimit(n int) StoreOption {
+	return func(s *Store) {
+		s.defaultLimit = n
+	}
+}
+
+// WithNameValidator sets a check run on user names when users are created or
+// renamed.
+func WithNameValidator(v NameValidator) StoreOption {
+	return func(s *Store) {
+		s.nameValidator = v
+	}
+}
+
+// WithVerifyWrites re-reads every created or updated user by id and by name
+// in the same transaction and fails the write if either read doesn't return
+// what was written. It catches backends that lose writes at the cost of two
+// extra reads per write.
+func WithVerifyWrites() StoreOption {
+	return func(s *Store) {
+		s.verifyWrites = true
+	}
+}
+
+// WithCaseInsensitiveNames folds user names to lower case in the name index,
+// so names that differ only in case collide and lookups ignore case. The
+// stored user keeps the name as given. It should only be enabled on a store
+// whose index was written with it.
+func WithCaseInsensitiveNames() StoreOption {
+	return func(s *Store) {
+		s.foldNames = true
+	}
+}
+
+// WithNameCollation keys the name index by the collation key of each name
+// under the given language, so listing by name follows that language's order
+// instead of byte order. As with WithCaseInsensitiveNames it should only be
+// enabled on a store whose index was written with it.
+func WithNameCollation(t language.Tag, opts ...collate.Option) StoreOption {
+	return func(s *Store) {
+		s.collator = collate.New(t, opts...)
+	}
+}
+
+// WithMaxScan fails listings and finders with ErrScanLimitExceeded once they
+// have traversed more than n records without satisfying the request. It
+// defaults to unlimited.
+func WithMaxScan(n int) StoreOption {
+	return func(s *Store) {
+		s.maxScan = n
+	}
+}
+
+// WithSlowThreshold logs a warning for every user read or write that takes
+// longer than d, naming the method and the id or name it was given. It
+// defaults to zero, which disables the slow log.
+func WithSlowThreshold(d time.Duration) StoreOption {
+	return func(s *Store) {
+		s.slowThreshold = d
+	}
+}
+
+// WithEventPublisher publishes every user mutation through p, for instance to
+// a message broker, once the transaction opened through Store.Update that made
+// it has committed. Mutations made in transactions opened on the kv store
+// directly are published as they are made, as their commit can't be observed.
+// It defaults to NopEventPublisher.
+func WithEventPublisher(p EventPublisher) StoreOption {
+	return func(s *Store) {
+		s.publisher = p
+	}
+}
+
+// WithFailOnPublishError makes Store.Update return an error when an event of
+// its mutations can't be published. The mutations are committed by then, the
+// error tells the caller the broker missed them. By default publishing errors
+// are only logged.
+func WithFailOnPublishError() StoreOption {
+	return func(s *Store) {
+		s.failOnPublish = true
+	}
+}
+
+// WithListDeduplication makes ListUsers skip users its cursor has already
+// returned, for backends whose cursors can repeat a key while the bucket is
+// written concurrently. It keeps every key seen by a listing in memory.
+func WithListDeduplication() StoreOption {
+	return func(s *Store) {
+		s.dedupList = true
+	}
+}
+
+// WithAsyncHookPool sizes the worker pool async user hooks are delivered
+// through and picks what a writer does when its queue is full. It defaults to
+// a single worker with a queue of 64 that blocks.
+func WithAsyncHookPool(workers, queue int, policy HookBackpressure) StoreOption {
+	return func(s *Store) {
+		s.poolWorkers = workers
+		s.poolQueue = queue
+		s.poolPolicy = policy
+	}
+}
+
+// WithIndexConfig sets which user fields are indexed and which of them must be
+// unique. It defaults to a unique name and non unique labels and flags.
+func WithIndexConfig(c IndexConfig) StoreOption {
+	return func(s *Store) {
+		s.indexConfig = c
+	}
+}
+
+// WithReservedUserNames rejects the given names when users are created or
+// renamed.
+func WithReservedUserNames(names ...string) StoreOption {
+	return func(s *Store) {
+		if s.reservedNames == nil {
+			s.reservedNames = map[string]struct{}{}
+		}
+		for _, n := range names {
+			s.reservedNames[n] = struct{}{}
+		}
+	}
+}
+
+// WithExternalUniquenessChecker makes creating and renaming users also check
+// the name against an external directory once it is known to be unique in the
+// store.
+func WithExternalUniquenessChecker(c ExternalUniquenessChecker) StoreOption {
+	return func(s *Store) {
+		s.nameAuthority = c
+	}
+}
+
+// WithCodec sets the encoding of the stored users. It defaults to JSON.
+func WithCodec(c UserCodec) StoreOption {
+	return func(s *Store) {
+		s.codec = c
+	}
+}
+
+// WithSchemaValidator sets a check run on the encoded user blob before it is
+// stored, so deployments can enforce a schema such as a JSON Schema document.
+func WithSchemaValidator(v UserSchemaValidator) StoreOption {
+	return func(s *Store) {
+		s.schema = v
+	}
+}
+
+// WithDeleteTokens sets the secret delete confirmation tokens are signed with
+// and how long they stay valid. It defaults to a random secret, so tokens
+// don't survive a restart, and five minutes.
+func WithDeleteTokens(secret []byte, ttl time.Duration) StoreOption {
+	return func(s *Store) {
+		s.deleteSecret = secret
+		s.deleteTTL = ttl
+	}
+}
+
+// WithPreventLastUserDeletion makes DeleteUser and SoftDeleteUser refuse to
+// take away the only remaining live user, so operators can't lock themselves
+// out.
+func WithPreventLastUserDeletion() StoreOption {
+	return func(s *Store) {
+		s.keepLastUser = true
+	}
+}
+
+// WithIndexConsistency sets what reading a user by name does when the name
+// index points at a user stored under another name. It defaults to
+// IndexRepairOnRead.
+func WithIndexConsistency(c IndexConsistency) StoreOption {
+	return func(s *Store) {
+		s.indexCheck = c
+	}
+}
+
+// WithBlobCipher encrypts user blobs with c as they are written. Blobs written
+// in plaintext before it was set still read, ReencodeUsers encrypts them. Only
+// the blobs are encrypted: the name index, the field indexes and the audit log
+// stay plaintext as lookups by name and field need them, so names and indexed
+// fields are still readable at rest.
+func WithBlobCipher(c BlobCipher) StoreOption {
+	return func(s *Store) {
+		s.cipher = c
+	}
+}
+
+// WithCreationTimes stamps CreatedAt on users created without one, so they
+// are listed by RecentUsers. Users created with a CreatedAt keep it whether or
+// not it is set. It is off by default for compatibility with clients that
+// compare the users they read back with the ones they wrote.
+func WithCreationTimes() StoreOption {
+	return func(s *Store) {
+		s.stampCreated = true
+	}
+}
+
+// WithReadAhead makes listing users in id order read n records ahead, for
+// backends whose cursors are slow to advance. Cursors implementing BatchCursor
+// fetch n records per round trip, others are advanced in another goroutine so
+// decoding overlaps with the reads. Results are unchanged. It is off by
+// default.
+func WithReadAhead(n int) StoreOption {
+	return func(s *Store) {
+		s.readAhead = n
+	}
+}
+
+// WithRetryPolicy retries bucket Get, Put and Delete calls failing with an
+// error p classifies as transient, errors it doesn't are returned as they
+// are. It applies to the transactions opened with View and Update, not to
+// ones opened on the kv store directly and handed to the store's methods.
+func WithRetryPolicy(p RetryPolicy) StoreOption {
+	return func(s *Store) {
+		s.retry = &p
+	}
+}
+
+// NewStore builds a Store over kvStore. The buckets it uses are created if they
+// don't exist yet, so reads work before anything has been written.
+func NewStore(kvStore kv.Store, opts ...StoreOption) (*Store, error) {
 	st := &Store{
-		kvStore: kvStore,
+		kvStore:      kvStore,
+		userBucket:   userBucket,
+		userIndex:    userIndex,
+		hasher:       &kv.Bcrypt{},
+		clock:        influxdb.RealTimeGenerator{},
+		idEncoder:    encodeID,
+		idDecoder:    decodeID,
+		log:          zap.NewNop(),
+		defaultLimit: influxdb.DefaultPageSize,
+		codec:        jsonUserCodec{},
+		poolWorkers:  1,
+		poolQueue:    64,
+		poolPolicy:   HookBlock,
+		indexConfig:  defaultIndexConfig,
+		deleteTTL:    5 * time.Minute,
+		publisher:    NopEventPublisher{},
+		shared:       &storeShared{},
+	}
+	st.passwordBucket = userpasswordBucket
+	st.metaBucket = userMetaBucket
+	st.loginBucket = userLoginBucket
+	st.fieldBucket = userFieldIndex
+	st.auditBucket = userAuditBucket
+	st.expiryIndex = userExpiryIndex
+	st.createdIndex = userCreatedIndex
+
+	for _, opt := range opts {
+		opt(st)
+	}
+
+	if st.deleteSecret == nil {
+		st.deleteSecret = make([]byte, 32)
+		if _, err := rand.Read(st.deleteSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	idxs, err := st.indexConfig.fieldIndexes()
+	if err != nil {
+		return nil, err
 	}
+	st.fieldIndexes = idxs
+
 	return st, st.setup()
 }
 
+func encodeID(id influxdb.ID) ([]byte, error) {
+	return id.Encode()
+}
+
+func decodeID(b []byte) (influxdb.ID, error) {
+	var id influxdb.ID
+	err := id.Decode(b)
+	return id, err
+}
+
+func (s *Store) encodeID(id influxdb.ID) ([]byte, error) {
+	return s.idEncoder(id)
+}
+
+// encodeNewID encodes the id of a user being created, running the configured
+// id validator first.
+func (s *Store) encodeNewID(id influxdb.ID) ([]byte, error) {
+	if s.idValidator != nil {
+		if err := s.idValidator(id); err != nil {
+			return nil, err
+		}
+	}
+	return s.encodeID(id)
+}
+
+func (s *Store) decodeID(b []byte) (influxdb.ID, error) {
+	return s.idDecoder(b)
+}
+
 // View opens up a transaction that will not write to any data. Implementing interfaces
 // should take care to ensure that all view transactions do not mutate any data.
 func (s *Store) View(ctx context.Context, fn func(kv.Tx) error) error {
-	return s.kvStore.View(ctx, fn)
+	return s.kvStore.View(ctx, s.withRetry(fn))
 }
 
-// Update opens up a transaction that will mutate data.
+// Update opens up a transaction that will mutate data. The user mutations it
+// makes are published and handed to the async hooks once it has committed.
 func (s *Store) Update(ctx context.Context, fn func(kv.Tx) error) error {
-	return s.kvStore.Update(ctx, fn)
+	pending := &pendingUserEvents{}
+	if err := s.kvStore.Update(context.WithValue(ctx, pendingUserEventsKey{}, pending), s.withRetry(fn)); err != nil {
+		return err
+	}
+
+	return s.userEventsCommitted(ctx, pending.events)
 }
 
 func (s *Store) setup() error {
 	return s.Update(context.Background(), func(tx kv.Tx) error {
-		if _, err := tx.Bucket(userBucket); err != nil {
+		if _, err := tx.Bucket(s.userBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.Bucket(s.userIndex); err != nil {
+			return err
+		}
+
+		if _, err := tx.Bucket(s.passwordBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.Bucket(s.auditBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.Bucket(s.fieldBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.Bucket(s.metaBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.Bucket(s.loginBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.Bucket(s.expiryIndex); err != nil {
+			return err
+		}
+
+		if _, err := tx.Bucket(userGenerationBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.Bucket(s.createdIndex); err != nil {
 			return err
 		}
 
-		if _, err := tx.Bucket(userIndex); err != nil {
+		if _, err := tx.Bucket(userChangeIndex); err != nil {
 			return err
 		}
 
@@ -0,0 +1,86 @@
+package tenant
+
+import (
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// applyFindOptions returns the find options a listing pages by. Without any it
+// is a page of def records, it would be irresponsible to just give back
+// everything in the system. Limits outside (0, MaxPageSize] are clamped to
+// MaxPageSize and negative offsets start at the first record.
+func applyFindOptions(opt []influxdb.FindOptions, def int) influxdb.FindOptions {
+	if len(opt) == 0 {
+		return influxdb.FindOptions{Limit: def}
+	}
+
+	o := opt[0]
+	if o.Limit > influxdb.MaxPageSize || o.Limit <= 0 {
+		o.Limit = influxdb.MaxPageSize
+	}
+	if o.Offset < 0 {
+		o.Offset = 0
+	}
+	return o
+}
+
+// PageOffsets returns the offsets of the pages before and after the one the
+// finders list for opt, nil at the edges. more reports whether records follow
+// the page. The options are clamped as the finders clamp them, so links built
+// from the offsets match the page served.
+func (s *Store) PageOffsets(more bool, opt ...influxdb.FindOptions) (prev, next *int) {
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	if o.Offset > 0 {
+		p := o.Offset - o.Limit
+		if p < 0 {
+			p = 0
+		}
+		prev = &p
+	}
+
+	if more {
+		n := o.Offset + o.Limit
+		next = &n
+	}
+
+	return prev, next
+}
+
+// paginator pages through the records a finder matches, skipping the offset
+// and stopping once a limit of them were taken.
+type paginator struct {
+	offset  int
+	limit   int
+	skipped int
+	taken   int
+}
+
+func newPaginator(o influxdb.FindOptions) *paginator {
+	return &paginator{offset: o.Offset, limit: o.Limit}
+}
+
+// take reports whether the next matching record belongs to the page, the ones
+// before the offset are skipped.
+func (p *paginator) take() bool {
+	if p.skipped < p.offset {
+		p.skipped++
+		return false
+	}
+	p.taken++
+	return true
+}
+
+// full reports whether the page holds as many records as it can.
+func (p *paginator) full() bool {
+	return p.taken >= p.limit
+}
+
+// cursorDirection walks backwards for descending find options so the last page
+// can be read without scanning from the start.
+func cursorDirection(o influxdb.FindOptions) kv.CursorOption {
+	if o.Descending {
+		return kv.WithCursorDirection(kv.CursorDescending)
+	}
+	return kv.WithCursorDirection(kv.CursorAscending)
+}
@@ -0,0 +1,197 @@
+package tenant_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestFindOptionsConsistent(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithDefaultLimit(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 5; i++ {
+			n := fmt.Sprintf("name%d", i)
+			names = append(names, n)
+
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: n, Status: "active", Labels: map[string]string{"team": "storage"}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userNames := func(us []*influxdb.User, err error) ([]string, error) {
+		if err != nil {
+			return nil, err
+		}
+		ns := []string{}
+		for _, u := range us {
+			ns = append(ns, u.Name)
+		}
+		return ns, nil
+	}
+
+	finders := []struct {
+		name string
+		// def is the page size used without find options
+		def  int
+		find func(tx kv.Tx, opt ...influxdb.FindOptions) ([]string, error)
+	}{
+		{
+			name: "ListUsers",
+			def:  3,
+			find: func(tx kv.Tx, opt ...influxdb.FindOptions) ([]string, error) {
+				return userNames(store.ListUsers(ctx, tx, opt...))
+			},
+		},
+		{
+			name: "FindUsersByLabel",
+			def:  3,
+			find: func(tx kv.Tx, opt ...influxdb.FindOptions) ([]string, error) {
+				return userNames(store.FindUsersByLabel(ctx, tx, "team", "storage", opt...))
+			},
+		},
+		{
+			name: "FindUsersInIDRange",
+			def:  3,
+			find: func(tx kv.Tx, opt ...influxdb.FindOptions) ([]string, error) {
+				return userNames(store.FindUsersInIDRange(ctx, tx, 1, 100, opt...))
+			},
+		},
+		{
+			name: "FindUsersInactiveSince",
+			def:  3,
+			find: func(tx kv.Tx, opt ...influxdb.FindOptions) ([]string, error) {
+				return userNames(store.FindUsersInactiveSince(ctx, tx, time.Now(), opt...))
+			},
+		},
+		{
+			name: "ListUserNamesOnly",
+			def:  3,
+			find: func(tx kv.Tx, opt ...influxdb.FindOptions) ([]string, error) {
+				return store.ListUserNamesOnly(ctx, tx, opt...)
+			},
+		},
+		{
+			name: "StreamUsersJSON",
+			def:  3,
+			find: func(tx kv.Tx, opt ...influxdb.FindOptions) ([]string, error) {
+				var buf bytes.Buffer
+				if err := store.StreamUsersJSON(ctx, tx, &buf, opt...); err != nil {
+					return nil, err
+				}
+				var us []*influxdb.User
+				if err := json.Unmarshal(buf.Bytes(), &us); err != nil {
+					return nil, err
+				}
+				return userNames(us, nil)
+			},
+		},
+	}
+
+	page := func(offset, limit int) []string {
+		if offset > len(names) {
+			offset = len(names)
+		}
+		end := offset + limit
+		if end > len(names) {
+			end = len(names)
+		}
+		return names[offset:end]
+	}
+
+	for _, f := range finders {
+		options := []struct {
+			name     string
+			opt      []influxdb.FindOptions
+			expected []string
+		}{
+			{name: "default", expected: page(0, f.def)},
+			{name: "limit", opt: []influxdb.FindOptions{{Limit: 2}}, expected: page(0, 2)},
+			{name: "offset", opt: []influxdb.FindOptions{{Limit: 2, Offset: 1}}, expected: page(1, 2)},
+			{name: "last page", opt: []influxdb.FindOptions{{Limit: 2, Offset: 4}}, expected: page(4, 2)},
+			{name: "past the end", opt: []influxdb.FindOptions{{Limit: 2, Offset: 10}}, expected: page(10, 2)},
+			{name: "zero limit", opt: []influxdb.FindOptions{{}}, expected: page(0, influxdb.MaxPageSize)},
+			{name: "negative limit", opt: []influxdb.FindOptions{{Limit: -1}}, expected: page(0, influxdb.MaxPageSize)},
+			{name: "limit over max", opt: []influxdb.FindOptions{{Limit: influxdb.MaxPageSize + 1}}, expected: page(0, influxdb.MaxPageSize)},
+			{name: "negative offset", opt: []influxdb.FindOptions{{Limit: 2, Offset: -1}}, expected: page(0, 2)},
+		}
+
+		for _, o := range options {
+			f, o := f, o
+			t.Run(f.name+"/"+o.name, func(t *testing.T) {
+				err := store.View(ctx, func(tx kv.Tx) error {
+					got, err := f.find(tx, o.opt...)
+					if err != nil {
+						return err
+					}
+					if !reflect.DeepEqual(got, o.expected) {
+						t.Fatalf("expected finder to page consistently: \n%+v\n%+v", got, o.expected)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+func TestPageOffsets(t *testing.T) {
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithDefaultLimit(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := func(n int) *int { return &n }
+
+	for _, tt := range []struct {
+		name string
+		opt  []influxdb.FindOptions
+		more bool
+		prev *int
+		next *int
+	}{
+		{name: "only page", opt: []influxdb.FindOptions{{Limit: 2}}},
+		{name: "first page", opt: []influxdb.FindOptions{{Limit: 2}}, more: true, next: offset(2)},
+		{name: "middle page", opt: []influxdb.FindOptions{{Limit: 2, Offset: 2}}, more: true, prev: offset(0), next: offset(4)},
+		{name: "last page", opt: []influxdb.FindOptions{{Limit: 2, Offset: 4}}, prev: offset(2)},
+		{name: "offset inside the first page", opt: []influxdb.FindOptions{{Limit: 2, Offset: 1}}, more: true, prev: offset(0), next: offset(3)},
+		{name: "default limit", more: true, next: offset(3)},
+		{name: "negative offset", opt: []influxdb.FindOptions{{Limit: 2, Offset: -1}}, more: true, next: offset(2)},
+		{name: "zero limit", opt: []influxdb.FindOptions{{Offset: 1}}, more: true, prev: offset(0), next: offset(1 + influxdb.MaxPageSize)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, next := store.PageOffsets(tt.more, tt.opt...)
+			if !reflect.DeepEqual(prev, tt.prev) || !reflect.DeepEqual(next, tt.next) {
+				t.Fatalf("expected page offsets: \n%v %v\n%v %v", fmtOffset(prev), fmtOffset(next), fmtOffset(tt.prev), fmtOffset(tt.next))
+			}
+		})
+	}
+}
+
+func fmtOffset(o *int) string {
+	if o == nil {
+		return "nil"
+	}
+	return fmt.Sprint(*o)
+}
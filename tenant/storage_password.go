@@ -0,0 +1,122 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// MinPasswordLength is the shortest password we allow into the system.
+const MinPasswordLength = 8
+
+var (
+	userpasswordBucket = []byte("userspasswordv1")
+)
+
+func (s *Store) hashPassword(password string) ([]byte, error) {
+	if len(password) < MinPasswordLength {
+		return nil, EShortPassword
+	}
+
+	hash, err := s.hasher.GenerateFromPassword([]byte(password), kv.DefaultCost)
+	if err != nil {
+		return nil, InternalPasswordHashError(err)
+	}
+
+	return hash, nil
+}
+
+func (s *Store) putPassword(ctx context.Context, tx kv.Tx, id influxdb.ID, hash []byte) error {
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.passwordBucket)
+	if err != nil {
+		return UnavailablePasswordServiceError(err)
+	}
+
+	if err := b.Put(encodedID, hash); err != nil {
+		if err == kv.ErrTxNotWritable {
+			return ErrReadOnlyTransaction
+		}
+		return UnavailablePasswordServiceError(err)
+	}
+
+	return nil
+}
+
+// GetPassword returns the hashed password of the user.
+func (s *Store) GetPassword(ctx context.Context, tx kv.Tx, id influxdb.ID) ([]byte, error) {
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return nil, InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.passwordBucket)
+	if err != nil {
+		return nil, UnavailablePasswordServiceError(err)
+	}
+
+	hash, err := b.Get(encodedID)
+	if kv.IsNotFound(err) {
+		return nil, ErrPasswordNotFound
+	}
+
+	if err != nil {
+		return nil, ErrInternalServiceError(err)
+	}
+
+	return hash, nil
+}
+
+// SetPassword hashes and stores the password of an existing user.
+func (s *Store) SetPassword(ctx context.Context, tx kv.Tx, id influxdb.ID, password string) error {
+	if _, err := s.GetUser(ctx, tx, id); err != nil {
+		return err
+	}
+
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.putPassword(ctx, tx, id, hash)
+}
+
+// DeletePassword removes the stored password of the user.
+func (s *Store) DeletePassword(ctx context.Context, tx kv.Tx, id influxdb.ID) error {
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.passwordBucket)
+	if err != nil {
+		return UnavailablePasswordServiceError(err)
+	}
+
+	if err := b.Delete(encodedID); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	return nil
+}
+
+// CreateUserWithPassword creates the user and sets its password in the same
+// transaction. The password is hashed before anything is written so a hashing
+// failure never leaves a user behind without credentials.
+func (s *Store) CreateUserWithPassword(ctx context.Context, tx kv.Tx, u *influxdb.User, password string) error {
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.CreateUser(ctx, tx, u); err != nil {
+		return err
+	}
+
+	return s.putPassword(ctx, tx, u.ID, hash)
+}
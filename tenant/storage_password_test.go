@@ -0,0 +1,113 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+type failingCrypt struct {
+	kv.Bcrypt
+}
+
+func (*failingCrypt) GenerateFromPassword(password []byte, cost int) ([]byte, error) {
+	return nil, errors.New("hash failure")
+}
+
+func TestCreateUserWithPassword(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		store, err := tenant.NewStore(inmem.NewKVStore())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUserWithPassword(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}, "howdydoody")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			if _, err := store.GetUserByName(ctx, tx, "user1"); err != nil {
+				t.Fatalf("expected user to be created: %v", err)
+			}
+
+			hash, err := store.GetPassword(ctx, tx, 1)
+			if err != nil {
+				t.Fatalf("expected password to be set: %v", err)
+			}
+
+			if err := (&kv.Bcrypt{}).CompareHashAndPassword(hash, []byte("howdydoody")); err != nil {
+				t.Fatalf("expected stored hash to match password: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("hash failure aborts create", func(t *testing.T) {
+		store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithPasswordHasher(&failingCrypt{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUserWithPassword(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}, "howdydoody")
+		})
+		if influxdb.ErrorCode(err) != influxdb.EInternal {
+			t.Fatalf("expected internal hash error, got: %v", err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			if _, err := store.GetUser(ctx, tx, 1); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected user not to be created, got: %v", err)
+			}
+
+			if _, err := store.GetUserByName(ctx, tx, "user1"); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected no index entry, got: %v", err)
+			}
+
+			if _, err := store.GetPassword(ctx, tx, 1); err != tenant.ErrPasswordNotFound {
+				t.Fatalf("expected no password, got: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("short password aborts create", func(t *testing.T) {
+		store, err := tenant.NewStore(inmem.NewKVStore())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUserWithPassword(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}, "short")
+		})
+		if err != tenant.EShortPassword {
+			t.Fatalf("expected short password error, got: %v", err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			if _, err := store.GetUser(ctx, tx, 1); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected user not to be created, got: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package tenant
+
+import (
+	"time"
+
+	"github.com/influxdata/influxdb/kv"
+)
+
+// RetryPolicy retries the bucket reads and writes of a Store that fail with a
+// transient error.
+type RetryPolicy struct {
+	// MaxAttempts is how many times an operation is tried, at least once.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled before each one
+	// after it.
+	Backoff time.Duration
+	// IsTransient reports whether an operation failing with err may succeed
+	// if tried again. Nothing is retried when it is nil.
+	IsTransient func(err error) bool
+}
+
+// do runs op until it succeeds, fails with an error that isn't transient, the
+// attempts run out or tx's context is done.
+func (p *RetryPolicy) do(tx kv.Tx, op func() error) error {
+	var done <-chan struct{}
+	if ctx := tx.Context(); ctx != nil {
+		done = ctx.Done()
+	}
+
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= p.MaxAttempts || p.IsTransient == nil || !p.IsTransient(err) {
+			return err
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-done:
+			t.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// retryTx is a transaction whose buckets retry under a RetryPolicy.
+type retryTx struct {
+	kv.Tx
+	policy *RetryPolicy
+}
+
+// Bucket returns b with its Get, Put and Delete retried.
+func (tx *retryTx) Bucket(b []byte) (kv.Bucket, error) {
+	bkt, err := tx.Tx.Bucket(b)
+	if err != nil {
+		return nil, err
+	}
+	return &retryBucket{Bucket: bkt, tx: tx}, nil
+}
+
+// retryBucket retries the single key operations of a bucket. Cursors are
+// left alone as a half read cursor can't be restarted safely.
+type retryBucket struct {
+	kv.Bucket
+	tx *retryTx
+}
+
+func (b *retryBucket) Get(key []byte) ([]byte, error) {
+	var v []byte
+	err := b.tx.policy.do(b.tx.Tx, func() error {
+		var err error
+		v, err = b.Bucket.Get(key)
+		return err
+	})
+	return v, err
+}
+
+func (b *retryBucket) Put(key, value []byte) error {
+	return b.tx.policy.do(b.tx.Tx, func() error {
+		return b.Bucket.Put(key, value)
+	})
+}
+
+func (b *retryBucket) Delete(key []byte) error {
+	return b.tx.policy.do(b.tx.Tx, func() error {
+		return b.Bucket.Delete(key)
+	})
+}
+
+// withRetry wraps fn so the transaction it is handed retries under the
+// store's policy.
+func (s *Store) withRetry(fn func(kv.Tx) error) func(kv.Tx) error {
+	if s.retry == nil {
+		return fn
+	}
+	return func(tx kv.Tx) error {
+		return fn(&retryTx{Tx: tx, policy: s.retry})
+	}
+}
@@ -0,0 +1,163 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+var errFlaky = errors.New("connection reset")
+
+// flakyStore fails every other Get, Put and Delete while failing is set.
+type flakyStore struct {
+	kv.Store
+	failing bool
+	err     error
+	calls   int
+}
+
+func (s *flakyStore) View(ctx context.Context, fn func(kv.Tx) error) error {
+	return s.Store.View(ctx, func(tx kv.Tx) error { return fn(&flakyTx{Tx: tx, s: s}) })
+}
+
+func (s *flakyStore) Update(ctx context.Context, fn func(kv.Tx) error) error {
+	return s.Store.Update(ctx, func(tx kv.Tx) error { return fn(&flakyTx{Tx: tx, s: s}) })
+}
+
+// fail reports whether the next call fails, every other one does.
+func (s *flakyStore) fail() error {
+	if !s.failing {
+		return nil
+	}
+	s.calls++
+	if s.calls%2 == 1 {
+		return s.err
+	}
+	return nil
+}
+
+type flakyTx struct {
+	kv.Tx
+	s *flakyStore
+}
+
+func (tx *flakyTx) Bucket(b []byte) (kv.Bucket, error) {
+	bkt, err := tx.Tx.Bucket(b)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyBucket{Bucket: bkt, s: tx.s}, nil
+}
+
+type flakyBucket struct {
+	kv.Bucket
+	s *flakyStore
+}
+
+func (b *flakyBucket) Get(key []byte) ([]byte, error) {
+	if err := b.s.fail(); err != nil {
+		return nil, err
+	}
+	return b.Bucket.Get(key)
+}
+
+func (b *flakyBucket) Put(key, value []byte) error {
+	if err := b.s.fail(); err != nil {
+		return err
+	}
+	return b.Bucket.Put(key, value)
+}
+
+func (b *flakyBucket) Delete(key []byte) error {
+	if err := b.s.fail(); err != nil {
+		return err
+	}
+	return b.Bucket.Delete(key)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	policy := tenant.RetryPolicy{
+		MaxAttempts: 2,
+		IsTransient: func(err error) bool { return err == errFlaky },
+	}
+
+	roundTrip := func(store *tenant.Store) error {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+				return err
+			}
+			return store.DeleteUser(ctx, tx, 1)
+		})
+		if err != nil {
+			return err
+		}
+
+		return store.View(ctx, func(tx kv.Tx) error {
+			if _, err := store.GetUser(ctx, tx, 1); err != tenant.ErrUserNotFound {
+				return err
+			}
+			return nil
+		})
+	}
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		kvStore := &flakyStore{Store: inmem.NewKVStore(), err: errFlaky}
+		store, err := tenant.NewStore(kvStore, tenant.WithRetryPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		kvStore.failing = true
+		if err := roundTrip(store); err != nil {
+			t.Fatalf("expected every operation to succeed on the second attempt: %v", err)
+		}
+		if kvStore.calls == 0 {
+			t.Fatal("expected the bucket to have been called")
+		}
+	})
+
+	t.Run("without a policy errors surface", func(t *testing.T) {
+		kvStore := &flakyStore{Store: inmem.NewKVStore(), err: errFlaky}
+		store, err := tenant.NewStore(kvStore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		kvStore.failing = true
+		if err := roundTrip(store); err == nil {
+			t.Fatal("expected the flaky bucket's error to surface")
+		}
+	})
+
+	t.Run("other errors pass through", func(t *testing.T) {
+		permanent := errors.New("permission denied")
+		kvStore := &flakyStore{Store: inmem.NewKVStore(), err: permanent}
+		store, err := tenant.NewStore(kvStore, tenant.WithRetryPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		kvStore.failing = true
+		err = store.View(ctx, func(tx kv.Tx) error {
+			b, err := tx.Bucket([]byte("usersv1"))
+			if err != nil {
+				return err
+			}
+			_, err = b.Get([]byte("missing"))
+			return err
+		})
+		if err != permanent {
+			t.Fatalf("expected the error to be returned as is, got: %v", err)
+		}
+		if kvStore.calls != 1 {
+			t.Fatalf("expected a single attempt, got: %d", kvStore.calls)
+		}
+	})
+}
@@ -1,11 +1,22 @@
 package tenant
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/influxdata/influxdb"
 	"github.com/influxdata/influxdb/kv"
+	"go.uber.org/zap"
+	"golang.org/x/text/collate"
 )
 
 var (
@@ -13,17 +24,213 @@ var (
 	userIndex  = []byte("userindexv1")
 )
 
-func unmarshalUser(v []byte) (*influxdb.User, error) {
-	u := &influxdb.User{}
-	if err := json.Unmarshal(v, u); err != nil {
-		return nil, ErrCorruptUser(err)
+const (
+	selfTestUserID   = influxdb.ID(0xfffffffffffffffe)
+	selfTestUserName = "__tenant_selftest__"
+)
+
+// errSelfTestRollback aborts the self test transaction once it has passed.
+var errSelfTestRollback = errors.New("user self test complete")
+
+// UserCodec converts users to and from the bytes stored in the user bucket.
+type UserCodec interface {
+	Marshal(u *influxdb.User) ([]byte, error)
+	Unmarshal(v []byte) (*influxdb.User, error)
+}
+
+// UserIntoDecoder is implemented by codecs that can decode into an existing
+// user, which lets GetUserInto reuse the caller's struct.
+type UserIntoDecoder interface {
+	UnmarshalInto(v []byte, dst *influxdb.User) error
+}
+
+// userJSONMarshal is the encoder used by the JSON codec. It is a variable so
+// the marshal failure paths can be exercised in tests.
+var userJSONMarshal = json.Marshal
+
+// jsonUserCodec stores users as JSON.
+type jsonUserCodec struct{}
+
+// UserSchemaVersion is the schema version the builtin codecs stamp on every
+// user blob they write. Blobs written before versions were stamped carry none
+// and read as version 1.
+const UserSchemaVersion = 2
+
+// legacyUserSchemaVersion is the version of blobs that carry none.
+const legacyUserSchemaVersion = 1
+
+// UserVersionDecoder is implemented by codecs that stamp the schema version on
+// the blobs they write, which lets migrations key off the version a user was
+// written at. Blobs from a newer schema should fail to decode.
+type UserVersionDecoder interface {
+	UnmarshalVersion(v []byte) (*influxdb.User, int, error)
+}
+
+// jsonUser is what the JSON codec stores, the user's fields alongside the
+// schema version.
+type jsonUser struct {
+	*influxdb.User
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+}
+
+func (jsonUserCodec) Marshal(u *influxdb.User) ([]byte, error) {
+	v, err := userJSONMarshal(jsonUser{User: u, SchemaVersion: UserSchemaVersion})
+	if err != nil {
+		return nil, err
 	}
+	return canonicalJSON(v)
+}
 
-	return u, nil
+// canonicalJSON re-encodes v with the keys of every object sorted and no
+// whitespace, so equal users always marshal to the same bytes whatever the
+// field order of the structs. Stored blobs are hashed for deduplication.
+func canonicalJSON(v []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(v))
+	// keep numbers as written rather than round tripping them through float64
+	d.UseNumber()
+
+	var doc interface{}
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func (c jsonUserCodec) Unmarshal(v []byte) (*influxdb.User, error) {
+	u, _, err := c.UnmarshalVersion(v)
+	return u, err
+}
+
+func (jsonUserCodec) UnmarshalVersion(v []byte) (*influxdb.User, int, error) {
+	// a store being reencoded holds some blobs in the binary encoding
+	if isBinaryUser(v) {
+		return BinaryUserCodec{}.UnmarshalVersion(v)
+	}
+
+	ju := jsonUser{User: &influxdb.User{}}
+	if err := json.Unmarshal(v, &ju); err != nil {
+		return nil, 0, err
+	}
+	version, err := decodedSchemaVersion(ju.SchemaVersion)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ju.User, version, nil
+}
+
+func (c jsonUserCodec) UnmarshalInto(v []byte, dst *influxdb.User) error {
+	if isBinaryUser(v) {
+		u, err := c.Unmarshal(v)
+		if err != nil {
+			return err
+		}
+		*dst = *u
+		return nil
+	}
+
+	ju := jsonUser{User: dst}
+	if err := json.Unmarshal(v, &ju); err != nil {
+		return err
+	}
+	_, err := decodedSchemaVersion(ju.SchemaVersion)
+	return err
+}
+
+// decodedSchemaVersion maps the version read from a blob to the one it was
+// written at. Blobs from a newer schema than this build knows are rejected
+// rather than read with fields missing.
+func decodedSchemaVersion(version int) (int, error) {
+	if version == 0 {
+		return legacyUserSchemaVersion, nil
+	}
+	if version > UserSchemaVersion {
+		return 0, fmt.Errorf("user schema version %d is newer than %d", version, UserSchemaVersion)
+	}
+	return version, nil
+}
+
+// unmarshalUser decodes a stored user, rejecting unknown statuses when the
+// store reads strictly.
+func (s *Store) unmarshalUser(v []byte) (*influxdb.User, error) {
+	u, _, err := s.unmarshalUserVersion(v)
+	return u, err
+}
+
+// unmarshalUserVersion decodes a stored user and the schema version it was
+// written at. Codecs that don't stamp versions read as the legacy version.
+func (s *Store) unmarshalUserVersion(v []byte) (*influxdb.User, int, error) {
+	v, err := s.decryptUser(v)
+	if err != nil {
+		return nil, 0, ErrCorruptUser(err)
+	}
+
+	var (
+		u       *influxdb.User
+		version = legacyUserSchemaVersion
+	)
+	if d, ok := s.codec.(UserVersionDecoder); ok {
+		u, version, err = d.UnmarshalVersion(v)
+	} else {
+		u, err = s.codec.Unmarshal(v)
+	}
+	if err != nil {
+		return nil, 0, ErrCorruptUser(err)
+	}
+
+	if s.strictStatus {
+		if err := u.Status.Valid(); err != nil {
+			return nil, 0, ErrCorruptUser(err)
+		}
+	}
+
+	return u, version, nil
+}
+
+// unmarshalUserInto decodes a stored user into dst, which is reset first. Codecs
+// that can't decode in place fall back to Unmarshal and a copy.
+func (s *Store) unmarshalUserInto(v []byte, dst *influxdb.User) error {
+	*dst = influxdb.User{}
+	v, err := s.decryptUser(v)
+	if err != nil {
+		return ErrCorruptUser(err)
+	}
+
+	if d, ok := s.codec.(UserIntoDecoder); ok {
+		if err := d.UnmarshalInto(v, dst); err != nil {
+			return ErrCorruptUser(err)
+		}
+	} else {
+		u, err := s.codec.Unmarshal(v)
+		if err != nil {
+			return ErrCorruptUser(err)
+		}
+		*dst = *u
+	}
+
+	if s.strictStatus {
+		if err := dst.Status.Valid(); err != nil {
+			return ErrCorruptUser(err)
+		}
+	}
+
+	return nil
 }
 
-func marshalUser(u *influxdb.User) ([]byte, error) {
-	v, err := json.Marshal(u)
+// marshalUser encodes a user for storage, runs the configured schema
+// validator over the result and encrypts it when the store has a cipher.
+func (s *Store) marshalUser(u *influxdb.User) ([]byte, error) {
+	v, err := s.codec.Marshal(u)
+	if err != nil {
+		return nil, ErrUnprocessableUser(err)
+	}
+
+	if s.schema != nil {
+		if err := s.schema(v); err != nil {
+			return nil, ErrUnprocessableUser(err)
+		}
+	}
+
+	v, err = s.encryptUser(v)
 	if err != nil {
 		return nil, ErrUnprocessableUser(err)
 	}
@@ -31,17 +238,110 @@ func marshalUser(u *influxdb.User) ([]byte, error) {
 	return v, nil
 }
 
+// MaxUserNameLength is the longest user name accepted, in bytes.
+const MaxUserNameLength = 256
+
+// UserIndexKeyDelimiter is reserved to separate the parts of composite name
+// index keys, such as a collation key and the name it was built from. Names
+// can't contain it.
+const UserIndexKeyDelimiter byte = 0
+
+// validateUserName runs the built in name checks and then the configured name
+// validator.
+func (s *Store) validateUserName(name string) error {
+	if name == "" {
+		return ErrUserNameRequired
+	}
+
+	if len(name) > MaxUserNameLength {
+		return ErrUserNameTooLong
+	}
+
+	if strings.IndexByte(name, UserIndexKeyDelimiter) >= 0 {
+		return ErrUserNameInvalidChars
+	}
+
+	if !utf8.ValidString(name) || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return ErrUserNameInvalidChars
+	}
+
+	if _, ok := s.reservedNames[name]; ok {
+		return ErrUserNameReserved
+	}
+
+	if !s.inScope(name) {
+		return ErrUserNameOutOfScope
+	}
+
+	if s.nameValidator == nil {
+		return nil
+	}
+
+	if err := s.nameValidator(name); err != nil {
+		return InvalidUserNameError(err)
+	}
+
+	return nil
+}
+
+// logSlow warns about a user operation begun at start if it took longer than
+// the slow threshold. It measures wall time, not the store's clock.
+func (s *Store) logSlow(method string, start time.Time, key zap.Field) {
+	if s.slowThreshold <= 0 {
+		return
+	}
+
+	if d := time.Since(start); d > s.slowThreshold {
+		s.log.Warn("Slow user operation",
+			zap.String("method", method),
+			zap.Duration("duration", d),
+			key)
+	}
+}
+
+// userIndexKey is the key a user name is stored under in the name index. Every
+// index read and write goes through it so lookups fold names exactly as they
+// were folded when written. With a collation the key is the name's collation
+// key followed by the name itself, so names that collate equal stay distinct.
+func (s *Store) userIndexKey(name string) []byte {
+	if s.foldNames {
+		name = strings.ToLower(name)
+	}
+	if s.collator == nil {
+		return []byte(name)
+	}
+
+	s.shared.collateMu.Lock()
+	defer s.shared.collateMu.Unlock()
+
+	var buf collate.Buffer
+	k := append([]byte(nil), s.collator.KeyFromString(&buf, name)...)
+	k = append(k, UserIndexKeyDelimiter)
+	return append(k, name...)
+}
+
+// userIndexName recovers the name from a name index key. Names folded to lower
+// case can't be unfolded, they are returned as stored in the index.
+func (s *Store) userIndexName(k []byte) string {
+	if s.collator != nil {
+		if i := bytes.LastIndexByte(k, 0); i >= 0 {
+			return string(k[i+1:])
+		}
+	}
+	return string(k)
+}
+
 func (s *Store) uniqueUserName(ctx context.Context, tx kv.Tx, uname string) error {
 
-	idx, err := tx.Bucket(userIndex)
+	idx, err := tx.Bucket(s.userIndex)
 	if err != nil {
 		return err
 	}
 
-	_, err = idx.Get([]byte(uname))
+	_, err = idx.Get(s.userIndexKey(uname))
 	// if not found then this is  _unique_.
 	if kv.IsNotFound(err) {
-		return nil
+		return s.uniqueExternalUserName(ctx, uname)
 	}
 
 	// no error means this is not unique
@@ -49,93 +349,980 @@ func (s *Store) uniqueUserName(ctx context.Context, tx kv.Tx, uname string) erro
 		return kv.NotUniqueError
 	}
 
-	// any other error is some sort of internal server error
-	return ErrUnprocessableUser(err)
+	// any other error is some sort of internal server error
+	return ErrUnprocessableUser(err)
+}
+
+func (s *Store) GetUser(ctx context.Context, tx kv.Tx, id influxdb.ID) (*influxdb.User, error) {
+	defer s.logSlow("GetUser", time.Now(), zap.Stringer("id", id))
+
+	v, err := s.getUserBlob(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.unmarshalUser(v)
+	if err != nil {
+		return nil, err
+	}
+	if !s.inScope(u.Name) {
+		return nil, ErrUserNotFound
+	}
+	return u, nil
+}
+
+// GetUserInto reads a user into dst instead of allocating a new one, so tight
+// loops can reuse a single struct. dst is reset before it is filled.
+func (s *Store) GetUserInto(ctx context.Context, tx kv.Tx, id influxdb.ID, dst *influxdb.User) error {
+	v, err := s.getUserBlob(tx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.unmarshalUserInto(v, dst); err != nil {
+		return err
+	}
+	if !s.inScope(dst.Name) {
+		*dst = influxdb.User{}
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *Store) getUserBlob(tx kv.Tx, id influxdb.ID) ([]byte, error) {
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return nil, InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := b.Get(encodedID)
+	if kv.IsNotFound(err) {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ErrInternalServiceError(err)
+	}
+
+	return v, nil
+}
+
+func (s *Store) GetUserByName(ctx context.Context, tx kv.Tx, n string) (*influxdb.User, error) {
+	defer s.logSlow("GetUserByName", time.Now(), zap.String("name", n))
+
+	if !s.inScope(n) {
+		return nil, ErrUserNotFound
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	key := s.userIndexKey(n)
+	uid, err := idx.Get(key)
+	if err == kv.ErrKeyNotFound {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ErrInternalServiceError(err)
+	}
+
+	// the index value is the encoded id, so it is used as the blob key as is
+	// once it is known to decode, rather than round tripping through GetUser
+	if _, err := s.decodeID(uid); err != nil {
+		return nil, ErrCorruptID(err)
+	}
+
+	v, err := b.Get(uid)
+	if kv.IsNotFound(err) {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ErrInternalServiceError(err)
+	}
+
+	u, err := s.unmarshalUser(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(s.userIndexKey(u.Name), key) {
+		return nil, s.userIndexMismatch(ctx, tx, n, u)
+	}
+
+	return u, nil
+}
+
+// GetUsersByIDs resolves many ids at once, opening the user bucket a single
+// time. Each id found maps to its user, ids that don't exist or lie outside
+// the store's scope are left out of the result.
+func (s *Store) GetUsersByIDs(ctx context.Context, tx kv.Tx, ids []influxdb.ID) (map[influxdb.ID]*influxdb.User, error) {
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	us := make(map[influxdb.ID]*influxdb.User, len(ids))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		encodedID, err := s.encodeID(id)
+		if err != nil {
+			return nil, InvalidUserIDError(err)
+		}
+
+		v, err := b.Get(encodedID)
+		if kv.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, ErrInternalServiceError(err)
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if !s.inScope(u.Name) {
+			continue
+		}
+
+		us[id] = u
+	}
+
+	return us, nil
+}
+
+// GetUsersOrdered resolves ids like GetUsersByIDs but returns the users
+// positionally aligned with ids, with nil where an id doesn't exist.
+func (s *Store) GetUsersOrdered(ctx context.Context, tx kv.Tx, ids []influxdb.ID) ([]*influxdb.User, error) {
+	return s.GetUsers(ctx, tx, ids, BatchOpts{OnMissing: MissingNilPlaceholder})
+}
+
+// GetUsersByNames resolves many names at once, opening the index and user
+// buckets a single time. Each name found maps to its user, names that don't
+// exist or lie outside the store's scope are left out of the result.
+func (s *Store) GetUsersByNames(ctx context.Context, tx kv.Tx, names []string) (map[string]*influxdb.User, error) {
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	us := make(map[string]*influxdb.User, len(names))
+	for _, n := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if !s.inScope(n) {
+			continue
+		}
+
+		uid, err := idx.Get(s.userIndexKey(n))
+		if kv.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, ErrInternalServiceError(err)
+		}
+
+		v, err := b.Get(uid)
+		if kv.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, ErrInternalServiceError(err)
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return nil, err
+		}
+
+		us[n] = u
+	}
+
+	return us, nil
+}
+
+// UserFilter restricts the users returned by FindUsers.
+type UserFilter struct {
+	// ExcludeIDs are skipped while scanning, limit and offset apply to the
+	// users that remain.
+	ExcludeIDs []influxdb.ID
+	// Status keeps only the users with this status when set.
+	Status *influxdb.Status
+	// NamePrefix keeps only the users whose name starts with it.
+	NamePrefix string
+	// IncludeDeleted lists soft deleted users too, interleaved with the live
+	// ones in id order. Callers tell them apart by DeletedAt. It can't be
+	// combined with sorting by name, tombstones aren't in the name index.
+	IncludeDeleted bool
+}
+
+// decodes reports whether the filter has to look inside the user blobs.
+func (f UserFilter) decodes() bool {
+	return f.Status != nil || f.NamePrefix != ""
+}
+
+func filterUsersFn(f UserFilter) func(u *influxdb.User) bool {
+	return func(u *influxdb.User) bool {
+		if f.Status != nil && u.Status != *f.Status {
+			return false
+		}
+		return strings.HasPrefix(u.Name, f.NamePrefix)
+	}
+}
+
+// excludedKeys returns the encoded keys of the excluded ids as a set.
+func (s *Store) excludedKeys(f UserFilter) (map[string]struct{}, error) {
+	keys := make(map[string]struct{}, len(f.ExcludeIDs))
+	for _, id := range f.ExcludeIDs {
+		k, err := s.encodeID(id)
+		if err != nil {
+			return nil, InvalidUserIDError(err)
+		}
+		keys[string(k)] = struct{}{}
+	}
+	return keys, nil
+}
+
+func (s *Store) ListUsers(ctx context.Context, tx kv.Tx, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	return s.FindUsers(ctx, tx, UserFilter{}, opt...)
+}
+
+// UserListMeta describes how a listing was served.
+type UserListMeta struct {
+	// LimitClamped is set when the requested limit was lowered to
+	// influxdb.MaxPageSize.
+	LimitClamped bool
+}
+
+// ListUsersWithMeta lists users like ListUsers and reports whether the
+// requested limit was clamped, so callers can warn the client.
+func (s *Store) ListUsersWithMeta(ctx context.Context, tx kv.Tx, opt ...influxdb.FindOptions) ([]*influxdb.User, UserListMeta, error) {
+	var meta UserListMeta
+	if len(opt) > 0 && opt[0].Limit > influxdb.MaxPageSize {
+		meta.LimitClamped = true
+	}
+
+	us, err := s.ListUsers(ctx, tx, opt...)
+	if err != nil {
+		return nil, UserListMeta{}, err
+	}
+
+	return us, meta, nil
+}
+
+// FindUsers lists the users matching filter.
+func (s *Store) FindUsers(ctx context.Context, tx kv.Tx, filter UserFilter, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	defer s.logSlow("FindUsers", time.Now(), zap.Skip())
+
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	filter, ok := s.scopeFilter(filter)
+	if !ok {
+		return []*influxdb.User{}, nil
+	}
+
+	exclude, err := s.excludedKeys(filter)
+	if err != nil {
+		return nil, err
+	}
+	match := filterUsersFn(filter)
+
+	switch o.SortBy {
+	case "", "id":
+	case "name":
+		if filter.IncludeDeleted {
+			return nil, ErrUnsupportedSort
+		}
+		return s.listUsersByName(ctx, tx, exclude, match, o)
+	default:
+		return nil, ErrUnsupportedSort
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(nil, cursorDirection(o))
+	if err != nil {
+		return nil, err
+	}
+	if s.readAhead > 0 {
+		cursor = readAhead(cursor, s.readAhead)
+	}
+	defer cursor.Close()
+
+	var seen map[string]struct{}
+	if s.dedupList {
+		seen = map[string]struct{}{}
+	}
+
+	page, scanned := newPaginator(o), 0
+	us := []*influxdb.User{}
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		scanned++
+		if s.scanExceeded(scanned) {
+			return nil, ErrScanLimitExceeded
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		if _, ok := exclude[string(k)]; ok {
+			continue
+		}
+
+		if seen != nil {
+			if _, ok := seen[string(k)]; ok {
+				continue
+			}
+			seen[string(k)] = struct{}{}
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return nil, err
+		}
+
+		// soft deleted users are only found by id unless asked for
+		if (u.DeletedAt != nil && !filter.IncludeDeleted) || !match(u) {
+			continue
+		}
+
+		if !page.take() {
+			continue
+		}
+
+		us = append(us, u)
+
+		if page.full() {
+			break
+		}
+	}
+
+	return us, cursor.Err()
+}
+
+// FindUsersInIDRange lists the users with ids in [lo, hi) in id order, so
+// external jobs can partition the keyspace. It relies on the id encoding
+// keeping ids in order, which the default encoding does. An empty range
+// returns no users.
+func (s *Store) FindUsersInIDRange(ctx context.Context, tx kv.Tx, lo, hi influxdb.ID, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	if lo >= hi {
+		return []*influxdb.User{}, nil
+	}
+
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	start, err := s.encodeID(lo)
+	if err != nil {
+		return nil, InvalidUserIDError(err)
+	}
+
+	stop, err := s.encodeID(hi)
+	if err != nil {
+		return nil, InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(start)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	page := newPaginator(o)
+	us := []*influxdb.User{}
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if bytes.Compare(k, stop) >= 0 {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if u.DeletedAt != nil || !s.inScope(u.Name) {
+			continue
+		}
+
+		if !page.take() {
+			continue
+		}
+
+		us = append(us, u)
+
+		if page.full() {
+			break
+		}
+	}
+
+	return us, cursor.Err()
+}
+
+// scanExceeded reports whether a listing that has traversed scanned records
+// went over the store's scan limit.
+func (s *Store) scanExceeded(scanned int) bool {
+	return s.maxScan > 0 && scanned > s.maxScan
+}
+
+// WalkUsers calls fn for each user in id order, starting after the checkpoint
+// id. An invalid checkpoint walks from the first user. It returns the id of the
+// last user fn processed without error so an interrupted walk can be resumed
+// by passing it back as the checkpoint.
+func (s *Store) WalkUsers(ctx context.Context, tx kv.Tx, checkpoint influxdb.ID, fn func(*influxdb.User) error) (influxdb.ID, error) {
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return checkpoint, err
+	}
+
+	var seek []byte
+	if checkpoint.Valid() {
+		seek, err = s.encodeID(checkpoint)
+		if err != nil {
+			return checkpoint, InvalidUserIDError(err)
+		}
+	}
+
+	cursor, err := b.ForwardCursor(seek)
+	if err != nil {
+		return checkpoint, err
+	}
+	defer cursor.Close()
+
+	last := checkpoint
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		// the cursor starts at the checkpoint itself, which is already done
+		if seek != nil && bytes.Equal(k, seek) {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return last, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return last, err
+		}
+
+		if !s.inScope(u.Name) {
+			continue
+		}
+
+		if err := fn(u); err != nil {
+			return last, err
+		}
+		last = u.ID
+	}
+
+	return last, cursor.Err()
+}
+
+// AnyUserExists reports whether the store holds any live user. It stops at the
+// first name index entry, so it is cheap however many users there are.
+func (s *Store) AnyUserExists(ctx context.Context, tx kv.Tx) (bool, error) {
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		seek []byte
+		opts []kv.CursorOption
+	)
+	if s.collator == nil && s.scope != "" {
+		seek = s.userIndexKey(s.scope)
+		opts = append(opts, kv.WithCursorPrefix(seek))
+	}
+
+	cursor, err := idx.ForwardCursor(seek, opts...)
+	if err != nil {
+		return false, err
+	}
+	defer cursor.Close()
+
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if s.legacyLayout && !s.isIndexEntry(v) {
+			continue
+		}
+
+		if s.inScope(s.userIndexName(k)) {
+			return true, nil
+		}
+	}
+
+	return false, cursor.Err()
+}
+
+// CountUsersWhere counts the users matching filter without building them. What
+// it reads depends on the filter:
+//
+//   - without a Status the count comes from the name index alone, scanning
+//     only the keys under NamePrefix, and no user is read
+//   - with a Status every candidate user has to be read and decoded as the
+//     status lives in the user; a NamePrefix narrows the candidates through
+//     the name index first
+func (s *Store) CountUsersWhere(ctx context.Context, tx kv.Tx, filter UserFilter) (int, error) {
+	filter, ok := s.scopeFilter(filter)
+	if !ok {
+		return 0, nil
+	}
+
+	exclude, err := s.excludedKeys(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	// collation keys of a prefix don't prefix the keys of the names it
+	// prefixes, so a collated index can't be range scanned by name prefix,
+	// and tombstones aren't in the index at all
+	if (filter.Status != nil && filter.NamePrefix == "") || s.collator != nil || filter.IncludeDeleted {
+		return s.countUserBlobs(ctx, tx, exclude, filter.IncludeDeleted, filterUsersFn(filter))
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return 0, err
+	}
+
+	var opts []kv.CursorOption
+	prefix := s.userIndexKey(filter.NamePrefix)
+	if len(prefix) > 0 {
+		opts = append(opts, kv.WithCursorPrefix(prefix))
+	}
+
+	cursor, err := idx.ForwardCursor(prefix, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	count := 0
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		if s.legacyLayout && !s.isIndexEntry(v) {
+			continue
+		}
+
+		if _, ok := exclude[string(v)]; ok {
+			continue
+		}
+
+		if filter.Status != nil {
+			id, err := s.decodeID(v)
+			if err != nil {
+				return 0, ErrCorruptID(err)
+			}
+
+			u, err := s.GetUser(ctx, tx, id)
+			if err != nil {
+				return 0, err
+			}
+
+			if u.Status != *filter.Status {
+				continue
+			}
+		}
+
+		count++
+	}
+
+	return count, cursor.Err()
+}
+
+// UserStats tallies the users of a store.
+type UserStats struct {
+	// Total is the number of live users.
+	Total int
+	// ByStatus counts the live users by status.
+	ByStatus map[influxdb.Status]int
+	// SoftDeleted is the number of tombstones not yet compacted.
+	SoftDeleted int
+}
+
+// GetUserStats tallies the users in a single scan of the user bucket.
+func (s *Store) GetUserStats(ctx context.Context, tx kv.Tx) (UserStats, error) {
+	stats := UserStats{ByStatus: map[influxdb.Status]int{}}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return UserStats{}, err
+	}
+
+	cursor, err := b.ForwardCursor(nil)
+	if err != nil {
+		return UserStats{}, err
+	}
+	defer cursor.Close()
+
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return UserStats{}, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return UserStats{}, err
+		}
+
+		if !s.inScope(u.Name) {
+			continue
+		}
+
+		if u.DeletedAt != nil {
+			stats.SoftDeleted++
+			continue
+		}
+
+		stats.Total++
+		stats.ByStatus[u.Status]++
+	}
+
+	if err := cursor.Err(); err != nil {
+		return UserStats{}, err
+	}
+
+	return stats, nil
+}
+
+// countUserBlobs counts the stored users that match by decoding each of them.
+// Soft deleted users are only counted with includeDeleted.
+func (s *Store) countUserBlobs(ctx context.Context, tx kv.Tx, exclude map[string]struct{}, includeDeleted bool, match func(*influxdb.User) bool) (int, error) {
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := b.ForwardCursor(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	count := 0
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		if _, ok := exclude[string(k)]; ok {
+			continue
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return 0, err
+		}
+
+		if (u.DeletedAt == nil || includeDeleted) && match(u) {
+			count++
+		}
+	}
+
+	return count, cursor.Err()
+}
+
+// listUsersByName walks the name index so users come back ordered by name.
+func (s *Store) listUsersByName(ctx context.Context, tx kv.Tx, exclude map[string]struct{}, match func(*influxdb.User) bool, o influxdb.FindOptions) ([]*influxdb.User, error) {
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := idx.ForwardCursor(nil, cursorDirection(o))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	// a backend that lost transactionality can leave several names pointing
+	// at the same user, only the first one found is listed
+	seen := map[influxdb.ID]struct{}{}
+
+	page, scanned := newPaginator(o), 0
+	us := []*influxdb.User{}
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		scanned++
+		if s.scanExceeded(scanned) {
+			return nil, ErrScanLimitExceeded
+		}
+
+		if s.legacyLayout && !s.isIndexEntry(v) {
+			continue
+		}
+
+		if _, ok := exclude[string(v)]; ok {
+			continue
+		}
+
+		id, err := s.decodeID(v)
+		if err != nil {
+			return nil, ErrCorruptID(err)
+		}
+
+		if _, ok := seen[id]; ok {
+			s.log.Warn("Duplicate user index entry",
+				zap.String("name", s.userIndexName(k)),
+				zap.String("id", id.String()))
+			continue
+		}
+		seen[id] = struct{}{}
+
+		// a dangling entry is skipped, a corrupt user fails the listing
+		u, ok, err := s.getScopedUser(tx, id)
+		if err == ErrUserNotFound {
+			s.log.Warn("Dangling user index entry",
+				zap.String("name", s.userIndexName(k)),
+				zap.String("id", id.String()))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok || !match(u) {
+			continue
+		}
+
+		if !page.take() {
+			continue
+		}
+
+		us = append(us, u)
+
+		if page.full() {
+			break
+		}
+	}
+
+	return us, cursor.Err()
+}
+
+// ListUserNamesOnly returns the user names in name index order reading only
+// the index. With case insensitive names they are returned folded.
+func (s *Store) ListUserNamesOnly(ctx context.Context, tx kv.Tx, opt ...influxdb.FindOptions) ([]string, error) {
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := idx.ForwardCursor(nil, cursorDirection(o))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	page := newPaginator(o)
+	names := []string{}
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if s.legacyLayout && !s.isIndexEntry(v) {
+			continue
+		}
+
+		name := s.userIndexName(k)
+		if !s.inScope(name) {
+			continue
+		}
+
+		if !page.take() {
+			continue
+		}
+
+		names = append(names, name)
+
+		if page.full() {
+			break
+		}
+	}
+
+	return names, cursor.Err()
 }
 
-func (s *Store) GetUser(ctx context.Context, tx kv.Tx, id influxdb.ID) (*influxdb.User, error) {
-	encodedID, err := id.Encode()
+// NextAvailableName returns the name following the highest numbered user
+// name made of prefix and a number, zero padded to pad digits. Gaps left by
+// deleted users aren't filled, after user-0001 and user-0003 comes user-0004.
+func (s *Store) NextAvailableName(ctx context.Context, tx kv.Tx, prefix string, pad int) (string, error) {
+	idx, err := tx.Bucket(s.userIndex)
 	if err != nil {
-		return nil, InvalidUserIDError(err)
+		return "", err
 	}
 
-	b, err := tx.Bucket(userBucket)
-	if err != nil {
-		return nil, err
+	match := prefix
+	if s.foldNames {
+		match = strings.ToLower(prefix)
 	}
 
-	v, err := b.Get(encodedID)
-	if kv.IsNotFound(err) {
-		return nil, ErrUserNotFound
+	// collation keys don't start with the name, every name is checked then
+	var (
+		seek []byte
+		opts []kv.CursorOption
+	)
+	if s.collator == nil {
+		seek = []byte(match)
+		opts = append(opts, kv.WithCursorPrefix(seek))
 	}
 
+	cursor, err := idx.ForwardCursor(seek, opts...)
 	if err != nil {
-		return nil, ErrInternalServiceError(err)
+		return "", err
 	}
+	defer cursor.Close()
 
-	return unmarshalUser(v)
-}
+	// numbers of different widths don't sort numerically, so the highest is
+	// found by looking at all of them
+	var max uint64
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
 
-func (s *Store) GetUserByName(ctx context.Context, tx kv.Tx, n string) (*influxdb.User, error) {
-	b, err := tx.Bucket(userIndex)
-	if err != nil {
-		return nil, err
-	}
+		if s.legacyLayout && !s.isIndexEntry(v) {
+			continue
+		}
 
-	uid, err := b.Get([]byte(n))
-	if err == kv.ErrKeyNotFound {
-		return nil, ErrUserNotFound
-	}
+		name := s.userIndexName(k)
+		if !strings.HasPrefix(name, match) {
+			continue
+		}
 
-	if err != nil {
-		return nil, ErrInternalServiceError(err)
+		n, err := strconv.ParseUint(name[len(match):], 10, 64)
+		if err != nil {
+			// not a numbered name
+			continue
+		}
+		if n > max {
+			max = n
+		}
 	}
 
-	var id influxdb.ID
-	if err := id.Decode(uid); err != nil {
-		return nil, ErrCorruptID(err)
+	if err := cursor.Err(); err != nil {
+		return "", err
 	}
-	return s.GetUser(ctx, tx, id)
+
+	return fmt.Sprintf("%s%0*d", prefix, pad, max+1), nil
 }
 
-func (s *Store) ListUsers(ctx context.Context, tx kv.Tx, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
-	// if we dont have any options it would be irresponsible to just give back all users in the system
-	if len(opt) == 0 {
-		opt = append(opt, influxdb.FindOptions{
-			Limit: influxdb.DefaultPageSize,
-		})
-	}
-	o := opt[0]
-	if o.Limit > influxdb.MaxPageSize || o.Limit == 0 {
-		o.Limit = influxdb.MaxPageSize
+// ListUsersByName returns a page of users in name index order keyed by the
+// name they are indexed under, which is folded with case insensitive names.
+// Being a map the result doesn't keep the index order.
+func (s *Store) ListUsersByName(ctx context.Context, tx kv.Tx, opt ...influxdb.FindOptions) (map[string]*influxdb.User, error) {
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return nil, err
 	}
 
-	b, err := tx.Bucket(userBucket)
+	b, err := tx.Bucket(s.userBucket)
 	if err != nil {
 		return nil, err
 	}
 
-	cursor, err := b.ForwardCursor(nil)
+	cursor, err := idx.ForwardCursor(nil, cursorDirection(o))
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close()
 
-	count := 0
-	us := []*influxdb.User{}
-	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
-		if o.Offset != 0 && count < o.Offset {
-			count++
+	page := newPaginator(o)
+	us := map[string]*influxdb.User{}
+	for k, uid := cursor.Next(); k != nil; k, uid = cursor.Next() {
+		if s.legacyLayout && !s.isIndexEntry(uid) {
+			continue
+		}
+
+		// a dangling entry is skipped, a corrupt user fails the listing
+		v, err := b.Get(uid)
+		if kv.IsNotFound(err) {
+			s.log.Warn("Dangling user index entry",
+				zap.String("name", s.userIndexName(k)),
+				zap.Binary("id", uid))
 			continue
 		}
-		u, err := unmarshalUser(v)
 		if err != nil {
+			return nil, ErrInternalServiceError(err)
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if !s.inScope(u.Name) {
 			continue
 		}
 
-		us = append(us, u)
+		if !page.take() {
+			continue
+		}
 
-		if len(us) >= o.Limit {
+		us[s.userIndexName(k)] = u
+
+		if page.full() {
 			break
 		}
 	}
@@ -144,121 +1331,460 @@ func (s *Store) ListUsers(ctx context.Context, tx kv.Tx, opt ...influxdb.FindOpt
 }
 
 func (s *Store) CreateUser(ctx context.Context, tx kv.Tx, u *influxdb.User) error {
-	encodedID, err := u.ID.Encode()
+	defer s.logSlow("CreateUser", time.Now(), zap.String("name", u.Name))
+
+	return s.createUser(ctx, tx, u, true)
+}
+
+// CreateUserIfAbsent creates u unless its name is taken, for idempotent
+// provisioning. It returns true and u when u was created, false and the user
+// holding the name when it already exists, leaving the caller to check the two
+// match.
+func (s *Store) CreateUserIfAbsent(ctx context.Context, tx kv.Tx, u *influxdb.User) (bool, *influxdb.User, error) {
+	existing, err := s.GetUserByName(ctx, tx, u.Name)
+	if err == nil {
+		return false, existing, nil
+	}
+	if err != ErrUserNotFound {
+		return false, nil, err
+	}
+
+	if err := s.CreateUser(ctx, tx, u); err != nil {
+		return false, nil, err
+	}
+
+	return true, u, nil
+}
+
+// createUser stores a new user. The name uniqueness probe is only skipped by
+// imports of data known to be unique.
+func (s *Store) createUser(ctx context.Context, tx kv.Tx, u *influxdb.User, checkUnique bool) error {
+	encodedID, err := s.encodeNewID(u.ID)
 	if err != nil {
 		return InvalidUserIDError(err)
 	}
 
-	if err := s.uniqueUserName(ctx, tx, u.Name); err != nil {
+	if err := s.validateUserName(u.Name); err != nil {
+		return err
+	}
+
+	if err := s.validateUserFields(u); err != nil {
+		return err
+	}
+
+	if checkUnique {
+		if err := s.uniqueUserName(ctx, tx, u.Name); err != nil {
+			return err
+		}
+	}
+
+	if err := s.checkUserFields(ctx, tx, encodedID, u); err != nil {
 		return err
 	}
 
-	idx, err := tx.Bucket(userIndex)
+	// stamp only once the user is accepted, a rejected create leaves the
+	// caller's user as it was
+	created := u.CreatedAt
+	s.stampCreatedAt(u)
+
+	// marshal before touching any bucket so a failure can't leave a
+	// half written index behind
+	v, err := s.marshalUser(u)
 	if err != nil {
+		u.CreatedAt = created
 		return err
 	}
 
-	b, err := tx.Bucket(userBucket)
+	idx, err := tx.Bucket(s.userIndex)
 	if err != nil {
 		return err
 	}
 
-	v, err := marshalUser(u)
+	b, err := tx.Bucket(s.userBucket)
 	if err != nil {
 		return err
 	}
 
-	if err := idx.Put([]byte(u.Name), encodedID); err != nil {
-		return ErrInternalServiceError(err)
+	if err := idx.Put(s.userIndexKey(u.Name), encodedID); err != nil {
+		return ErrWriteFailed(err)
 	}
 
 	if err := b.Put(encodedID, v); err != nil {
-		return ErrInternalServiceError(err)
+		return ErrWriteFailed(err)
+	}
+
+	if err := s.indexUserFields(ctx, tx, encodedID, nil, u); err != nil {
+		return err
+	}
+
+	if err := s.verifyUserWrite(ctx, tx, u); err != nil {
+		return err
+	}
+
+	return s.userMutated(ctx, tx, UserAuditCreate, nil, u)
+}
+
+// verifyUserWrite reads u back by id and by name when the store verifies its
+// writes.
+func (s *Store) verifyUserWrite(ctx context.Context, tx kv.Tx, u *influxdb.User) error {
+	if !s.verifyWrites {
+		return nil
+	}
+
+	byID, err := s.GetUser(ctx, tx, u.ID)
+	if err != nil {
+		return UserWriteVerificationError("user could not be read back by id", err)
+	}
+	if !reflect.DeepEqual(byID, u) {
+		return UserWriteVerificationError("user read back by id differs from the write", nil)
+	}
+
+	byName, err := s.GetUserByName(ctx, tx, u.Name)
+	if err != nil {
+		return UserWriteVerificationError("user could not be read back by name", err)
+	}
+	if !reflect.DeepEqual(byName, u) {
+		return UserWriteVerificationError("user read back by name differs from the write", nil)
 	}
 
 	return nil
 }
 
-func (s *Store) UpdateUser(ctx context.Context, tx kv.Tx, id influxdb.ID, upd influxdb.UserUpdate) (*influxdb.User, error) {
-	encodedID, err := id.Encode()
+// CanRenameUser runs the checks UpdateUser makes before renaming a user
+// without writing anything. It returns nil when the rename would succeed.
+func (s *Store) CanRenameUser(ctx context.Context, tx kv.Tx, id influxdb.ID, newName string) error {
+	u, err := s.GetUser(ctx, tx, id)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if u.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	return s.checkRename(ctx, tx, newName)
+}
+
+// uniqueExternalUserName asks the external uniqueness authority, if there is
+// one, whether a name unique in this store is taken elsewhere.
+func (s *Store) uniqueExternalUserName(ctx context.Context, uname string) error {
+	if s.nameAuthority == nil {
+		return nil
+	}
+
+	taken, err := s.nameAuthority(ctx, uname)
+	if err != nil {
+		return ErrInternalServiceError(err)
+	}
+	if taken {
+		return ErrUserNameTaken
+	}
+	return nil
+}
+
+// checkRename validates a new user name and makes sure it is free.
+func (s *Store) checkRename(ctx context.Context, tx kv.Tx, newName string) error {
+	if err := s.validateUserName(newName); err != nil {
+		return err
 	}
 
+	return s.uniqueUserName(ctx, tx, newName)
+}
+
+func (s *Store) UpdateUser(ctx context.Context, tx kv.Tx, id influxdb.ID, upd influxdb.UserUpdate) (*influxdb.User, error) {
+	defer s.logSlow("UpdateUser", time.Now(), zap.Stringer("id", id))
+
+	// GetUser reports both invalid and missing ids, as it does for DeleteUser
 	u, err := s.GetUser(ctx, tx, id)
 	if err != nil {
 		return nil, err
 	}
+	// a tombstone can't be brought back into the indexes
+	if u.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return nil, InvalidUserIDError(err)
+	}
 
+	old := *u
+	oldName := u.Name
 	if upd.Name != nil {
-		if err := s.uniqueUserName(ctx, tx, *upd.Name); err != nil {
+		if err := s.checkRename(ctx, tx, *upd.Name); err != nil {
 			return nil, err
 		}
+		u.Name = *upd.Name
+	}
 
-		idx, err := tx.Bucket(userIndex)
-		if err != nil {
-			return nil, err
-		}
+	if upd.Status != nil {
+		u.Status = *upd.Status
+	}
 
-		if err := idx.Delete([]byte(u.Name)); err != nil {
-			return nil, ErrInternalServiceError(err)
+	if upd.Labels != nil {
+		u.Labels = upd.Labels
+		if len(u.Labels) == 0 {
+			u.Labels = nil
 		}
+	}
 
-		u.Name = *upd.Name
-
-		if err := idx.Put([]byte(u.Name), encodedID); err != nil {
-			return nil, ErrInternalServiceError(err)
+	if upd.Flags != nil {
+		u.Flags = upd.Flags
+		if len(u.Flags) == 0 {
+			u.Flags = nil
 		}
 	}
 
-	if upd.Status != nil {
-		u.Status = *upd.Status
+	if err := s.validateUserFields(u); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkUserFields(ctx, tx, encodedID, u); err != nil {
+		return nil, err
 	}
 
-	v, err := marshalUser(u)
+	// in UTC without a monotonic reading, as it decodes
+	now := s.now().UTC()
+	u.UpdatedAt = &now
+
+	// marshal before touching the index so a failure leaves it untouched
+	v, err := s.marshalUser(u)
 	if err != nil {
 		return nil, err
 	}
 
-	b, err := tx.Bucket(userBucket)
+	if upd.Name != nil {
+		idx, err := tx.Bucket(s.userIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := idx.Delete(s.userIndexKey(oldName)); err != nil {
+			return nil, ErrWriteFailed(err)
+		}
+
+		if err := idx.Put(s.userIndexKey(u.Name), encodedID); err != nil {
+			return nil, ErrWriteFailed(err)
+		}
+	}
+
+	b, err := tx.Bucket(s.userBucket)
 	if err != nil {
 		return nil, err
 	}
 	if err := b.Put(encodedID, v); err != nil {
-		return nil, ErrInternalServiceError(err)
+		return nil, ErrWriteFailed(err)
+	}
+
+	if err := s.indexUserFields(ctx, tx, encodedID, &old, u); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyUserWrite(ctx, tx, u); err != nil {
+		return nil, err
+	}
+
+	if err := s.userMutated(ctx, tx, UserAuditUpdate, &old, u); err != nil {
+		return nil, err
 	}
 
 	return u, nil
 }
 
+// CompareAndSwapStatus sets the status of user id to next only if it is
+// currently expect, and reports whether it did. The read and the write happen
+// in tx, so two writers racing on the same user can't both swap.
+func (s *Store) CompareAndSwapStatus(ctx context.Context, tx kv.Tx, id influxdb.ID, expect, next influxdb.Status) (bool, error) {
+	u, err := s.GetUser(ctx, tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	if u.Status != expect {
+		return false, nil
+	}
+
+	if _, err := s.UpdateUser(ctx, tx, id, influxdb.UserUpdate{Status: &next}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// TouchUser bumps the UpdatedAt time of the user to now without making any
+// other change. The name index is left alone, but the touch is recorded and
+// hooked like any other update.
+func (s *Store) TouchUser(ctx context.Context, tx kv.Tx, id influxdb.ID) error {
+	u, err := s.GetUser(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if u.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	old := *u
+	now := s.now().UTC()
+	u.UpdatedAt = &now
+
+	v, err := s.marshalUser(u)
+	if err != nil {
+		return err
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Put(encodedID, v); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	return s.userMutated(ctx, tx, UserAuditUpdate, &old, u)
+}
+
+// checkLastUser refuses to take a live user away when the store prevents the
+// deletion of its last one.
+func (s *Store) checkLastUser(ctx context.Context, tx kv.Tx) error {
+	if !s.keepLastUser {
+		return nil
+	}
+
+	// counted in tx so a concurrent delete can't slip past the check
+	n, err := s.countUserBlobs(ctx, tx, nil, false, func(*influxdb.User) bool { return true })
+	if err != nil {
+		return err
+	}
+	if n <= 1 {
+		return ErrCannotDeleteLastUser
+	}
+	return nil
+}
+
 func (s *Store) DeleteUser(ctx context.Context, tx kv.Tx, id influxdb.ID) error {
+	defer s.logSlow("DeleteUser", time.Now(), zap.Stringer("id", id))
+
 	u, err := s.GetUser(ctx, tx, id)
 	if err != nil {
 		return err
 	}
+	// its index entries went with the soft delete and may be another user's
+	if u.DeletedAt != nil {
+		return s.purgeUser(ctx, tx, id)
+	}
+
+	if err := s.checkLastUser(ctx, tx); err != nil {
+		return err
+	}
 
-	encodedID, err := id.Encode()
+	encodedID, err := s.encodeID(id)
 	if err != nil {
 		return InvalidUserIDError(err)
 	}
 
-	idx, err := tx.Bucket(userIndex)
+	idx, err := tx.Bucket(s.userIndex)
 	if err != nil {
 		return err
 	}
 
-	if err := idx.Delete([]byte(u.Name)); err != nil {
-		return ErrInternalServiceError(err)
+	if err := idx.Delete(s.userIndexKey(u.Name)); err != nil {
+		return ErrWriteFailed(err)
 	}
 
-	b, err := tx.Bucket(userBucket)
+	b, err := tx.Bucket(s.userBucket)
 	if err != nil {
 		return err
 	}
 
 	if err := b.Delete(encodedID); err != nil {
-		return ErrInternalServiceError(err)
+		return ErrWriteFailed(err)
 	}
 
-	return nil
+	if err := s.indexUserFields(ctx, tx, encodedID, u, nil); err != nil {
+		return err
+	}
+
+	if err := s.DeletePassword(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if err := s.deleteUserMeta(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if err := s.deleteLastLogin(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return s.userMutated(ctx, tx, UserAuditDelete, nil, u)
+}
+
+// ViewUsers runs fn in a single read transaction of store so every read it
+// makes sees the same snapshot, e.g. a listing and the per user reads that
+// follow it.
+func (s *Store) ViewUsers(ctx context.Context, store kv.Store, fn func(tx kv.Tx) error) error {
+	return store.View(ctx, fn)
+}
+
+// SelfTestUsers confirms the user store can be written to and read from. It
+// creates a throwaway user, reads it back by id and by name and deletes it, all
+// in a transaction that is rolled back so nothing is left behind. The
+// throwaway user is exempt from the store's scope and from
+// WithPreventLastUserDeletion.
+func (s *Store) SelfTestUsers(ctx context.Context, store kv.Store) error {
+	// a copy of the store relaxed for the throwaway user
+	st := *s
+	st.scope = ""
+	st.keepLastUser = false
+	s = &st
+
+	// the mutations are rolled back, their events are never published
+	ctx = context.WithValue(ctx, pendingUserEventsKey{}, &pendingUserEvents{})
+
+	err := store.Update(ctx, func(tx kv.Tx) error {
+		u := &influxdb.User{
+			ID:     selfTestUserID,
+			Name:   selfTestUserName,
+			Status: influxdb.Active,
+		}
+		if err := s.CreateUser(ctx, tx, u); err != nil {
+			return err
+		}
+
+		byID, err := s.GetUser(ctx, tx, u.ID)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(byID, u) {
+			return ErrUserSelfTest("user read by id does not match user written")
+		}
+
+		byName, err := s.GetUserByName(ctx, tx, u.Name)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(byName, u) {
+			return ErrUserSelfTest("user read by name does not match user written")
+		}
+
+		if err := s.DeleteUser(ctx, tx, u.ID); err != nil {
+			return err
+		}
+
+		if _, err := s.GetUser(ctx, tx, u.ID); err != ErrUserNotFound {
+			return ErrUserSelfTest("user still present after delete")
+		}
+
+		return errSelfTestRollback
+	})
+	if err == errSelfTestRollback {
+		return nil
+	}
+
+	return err
 }
@@ -0,0 +1,319 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+var (
+	userAuditBucket = []byte("userauditv1")
+)
+
+// UserAuditAction is the kind of mutation recorded in the user audit log.
+type UserAuditAction string
+
+const (
+	// UserAuditCreate is recorded when a user is created.
+	UserAuditCreate UserAuditAction = "create"
+	// UserAuditUpdate is recorded when a user is updated.
+	UserAuditUpdate UserAuditAction = "update"
+	// UserAuditDelete is recorded when a user is deleted.
+	UserAuditDelete UserAuditAction = "delete"
+)
+
+// UserAuditEntry is a single mutation recorded in the user audit log.
+type UserAuditEntry struct {
+	Action UserAuditAction `json:"action"`
+	UserID influxdb.ID     `json:"userID"`
+	Name   string          `json:"name"`
+	At     time.Time       `json:"at"`
+	// Actor is the user that made the mutation, SystemActor when none was
+	// set on the context.
+	Actor influxdb.ID `json:"actor,omitempty"`
+	// Changes lists the fields an update changed.
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// SystemActor is recorded as the actor of mutations made without one on the
+// context.
+const SystemActor influxdb.ID = 0
+
+type actorKey struct{}
+
+// WithActor returns a context attributing the mutations made with it to
+// actorID in the audit log.
+func WithActor(ctx context.Context, actorID influxdb.ID) context.Context {
+	return context.WithValue(ctx, actorKey{}, actorID)
+}
+
+// ActorFromContext returns the actor set by WithActor, SystemActor if none
+// was set.
+func ActorFromContext(ctx context.Context) influxdb.ID {
+	if id, ok := ctx.Value(actorKey{}).(influxdb.ID); ok {
+		return id
+	}
+	return SystemActor
+}
+
+// auditKeyLength is an 8 byte big endian timestamp followed by a 4 byte
+// sequence that separates entries written at the same instant.
+const auditKeyLength = 12
+
+// userAuditKey keys audit entries by time so they can be ranged over in order.
+func userAuditKey(at time.Time, seq uint32) []byte {
+	k := make([]byte, auditKeyLength)
+	binary.BigEndian.PutUint64(k, uint64(at.UnixNano()))
+	binary.BigEndian.PutUint32(k[8:], seq)
+	return k
+}
+
+func (s *Store) now() time.Time {
+	return s.clock.Now()
+}
+
+// writeUserAudit records a mutation in the audit log and returns the key of
+// the entry.
+func (s *Store) writeUserAudit(ctx context.Context, tx kv.Tx, action UserAuditAction, u *influxdb.User, changes []FieldChange) ([]byte, error) {
+	e := &UserAuditEntry{
+		Action:  action,
+		UserID:  u.ID,
+		Name:    u.Name,
+		At:      s.now().UTC(),
+		Actor:   ActorFromContext(ctx),
+		Changes: changes,
+	}
+
+	v, err := json.Marshal(e)
+	if err != nil {
+		return nil, ErrInternalServiceError(err)
+	}
+
+	b, err := tx.Bucket(s.auditBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var key []byte
+	for seq := uint32(0); ; seq++ {
+		key = userAuditKey(e.At, seq)
+		_, err := b.Get(key)
+		if kv.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			return nil, ErrInternalServiceError(err)
+		}
+	}
+
+	if err := b.Put(key, v); err != nil {
+		return nil, ErrWriteFailed(err)
+	}
+
+	return key, nil
+}
+
+// walkUserAudit calls fn for each audit entry recorded in [start, end).
+func (s *Store) walkUserAudit(ctx context.Context, tx kv.Tx, start, end time.Time, fn func(*UserAuditEntry) error) error {
+	b, err := tx.Bucket(s.auditBucket)
+	if err != nil {
+		return err
+	}
+
+	stop := userAuditKey(end, 0)
+	cursor, err := b.ForwardCursor(userAuditKey(start, 0))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if bytes.Compare(k, stop) >= 0 {
+			break
+		}
+
+		e := &UserAuditEntry{}
+		if err := json.Unmarshal(v, e); err != nil {
+			return ErrCorruptUserAudit(err)
+		}
+
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// ListUserAudit returns the audit entries recorded in [start, end) oldest
+// first.
+func (s *Store) ListUserAudit(ctx context.Context, tx kv.Tx, start, end time.Time) ([]*UserAuditEntry, error) {
+	es := []*UserAuditEntry{}
+	err := s.walkUserAudit(ctx, tx, start, end, func(e *UserAuditEntry) error {
+		es = append(es, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return es, nil
+}
+
+// UserCreationCountByDay counts the users created in [start, end) grouped by
+// UTC date formatted as YYYY-MM-DD. It reads only the audit log.
+func (s *Store) UserCreationCountByDay(ctx context.Context, tx kv.Tx, start, end time.Time) (map[string]int, error) {
+	counts := map[string]int{}
+	err := s.walkUserAudit(ctx, tx, start, end, func(e *UserAuditEntry) error {
+		if e.Action == UserAuditCreate {
+			counts[e.At.UTC().Format("2006-01-02")]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// FindUsersCreatedBy lists in creation order the users actorID created,
+// resolved from the audit log to their current records. Users deleted since,
+// or deleted and created again by someone else, are skipped. Audit entries
+// purged by CompactUserAudit can't be found.
+func (s *Store) FindUsersCreatedBy(ctx context.Context, tx kv.Tx, actorID influxdb.ID, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	ids, err := s.usersCreatedBy(ctx, tx, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	page := newPaginator(o)
+	us := []*influxdb.User{}
+	for _, id := range ids {
+		u, err := s.GetUser(ctx, tx, id)
+		if err == ErrUserNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if u.DeletedAt != nil {
+			continue
+		}
+
+		if !page.take() {
+			continue
+		}
+
+		us = append(us, u)
+
+		if page.full() {
+			break
+		}
+	}
+
+	return us, nil
+}
+
+// usersCreatedBy returns in creation order the ids whose latest create in the
+// audit log was made by actorID.
+func (s *Store) usersCreatedBy(ctx context.Context, tx kv.Tx, actorID influxdb.ID) ([]influxdb.ID, error) {
+	b, err := tx.Bucket(s.auditBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var (
+		order   []influxdb.ID
+		creator = map[influxdb.ID]influxdb.ID{}
+	)
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		e := &UserAuditEntry{}
+		if err := json.Unmarshal(v, e); err != nil {
+			return nil, ErrCorruptUserAudit(err)
+		}
+
+		if e.Action != UserAuditCreate {
+			continue
+		}
+		if _, ok := creator[e.UserID]; !ok {
+			order = append(order, e.UserID)
+		}
+		creator[e.UserID] = e.Actor
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := []influxdb.ID{}
+	for _, id := range order {
+		if creator[id] == actorID {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+// CompactUserAudit deletes the audit entries recorded more than retain ago and
+// returns how many were purged. Entries are keyed by time so only the purged
+// range is scanned. The changes StreamUserChanges replays go with them.
+func (s *Store) CompactUserAudit(ctx context.Context, tx kv.Tx, retain time.Duration) (int, error) {
+	b, err := tx.Bucket(s.auditBucket)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := userAuditKey(s.now().Add(-retain), 0)
+	cursor, err := b.ForwardCursor(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// collect the keys first so deletes can't invalidate the cursor
+	var keys [][]byte
+	for k, _ := cursor.Next(); k != nil; k, _ = cursor.Next() {
+		if bytes.Compare(k, cutoff) >= 0 {
+			break
+		}
+		keys = append(keys, append([]byte(nil), k...))
+	}
+
+	if err := cursor.Err(); err != nil {
+		cursor.Close()
+		return 0, err
+	}
+	if err := cursor.Close(); err != nil {
+		return 0, err
+	}
+
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return 0, ErrWriteFailed(err)
+		}
+	}
+
+	if err := s.compactUserChanges(tx, cutoff); err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
@@ -0,0 +1,277 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestUserCreationCountByDay(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day1 := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2020, 1, 2, 23, 59, 0, 0, time.UTC)
+	day3 := time.Date(2020, 1, 3, 0, 1, 0, 0, time.UTC)
+	day4 := time.Date(2020, 1, 4, 12, 0, 0, 0, time.UTC)
+
+	id := influxdb.ID(1)
+	create := func(tx kv.Tx, at time.Time, n int) {
+		clock.Set(at)
+		for i := 0; i < n; i++ {
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: id, Name: fmt.Sprintf("user%d", id), Status: "active"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id++
+		}
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		create(tx, day1, 2)
+		create(tx, day2, 3)
+		create(tx, day3, 1)
+		create(tx, day4, 4)
+
+		// updates and deletes are not creations
+		clock.Set(day2)
+		inactive := influxdb.Status("inactive")
+		if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Status: &inactive}); err != nil {
+			return err
+		}
+		return store.DeleteUser(ctx, tx, 2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		counts, err := store.UserCreationCountByDay(ctx, tx, day1, day4)
+		if err != nil {
+			return err
+		}
+
+		expected := map[string]int{
+			"2020-01-01": 2,
+			"2020-01-02": 3,
+			"2020-01-03": 1,
+		}
+		if !reflect.DeepEqual(counts, expected) {
+			t.Fatalf("expected identical counts: \n%+v\n%+v", counts, expected)
+		}
+
+		counts, err = store.UserCreationCountByDay(ctx, tx, day2, day4.Add(time.Hour))
+		if err != nil {
+			return err
+		}
+
+		expected = map[string]int{
+			"2020-01-02": 3,
+			"2020-01-03": 1,
+			"2020-01-04": 4,
+		}
+		if !reflect.DeepEqual(counts, expected) {
+			t.Fatalf("expected identical counts: \n%+v\n%+v", counts, expected)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompactUserAudit(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	at := []time.Time{
+		now.Add(-72 * time.Hour),
+		now.Add(-49 * time.Hour),
+		now.Add(-47 * time.Hour),
+		now.Add(-time.Hour),
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i, ts := range at {
+			clock.Set(ts)
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 1), Name: fmt.Sprintf("user%d", i+1), Status: "active"})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Set(now)
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		n, err := store.CompactUserAudit(ctx, tx, 48*time.Hour)
+		if err != nil {
+			return err
+		}
+		if n != 2 {
+			t.Fatalf("expected 2 audit entries purged got: %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		counts, err := store.UserCreationCountByDay(ctx, tx, now.Add(-100*time.Hour), now)
+		if err != nil {
+			return err
+		}
+
+		expected := map[string]int{
+			"2020-01-30": 1,
+			"2020-01-31": 1,
+		}
+		if !reflect.DeepEqual(counts, expected) {
+			t.Fatalf("expected only recent entries to remain: \n%+v\n%+v", counts, expected)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserAuditActor(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	clock.Set(at)
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+		return store.CreateUser(tenant.WithActor(ctx, 1), tx, &influxdb.User{ID: 2, Name: "user2", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		entries, err := store.ListUserAudit(ctx, tx, at, at.Add(time.Second))
+		if err != nil {
+			return err
+		}
+
+		var actors []influxdb.ID
+		for _, e := range entries {
+			actors = append(actors, e.Actor)
+		}
+
+		expected := []influxdb.ID{tenant.SystemActor, 1}
+		if !reflect.DeepEqual(actors, expected) {
+			t.Fatalf("expected audit actors to match: \n%+v\n%+v", expected, actors)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindUsersCreatedBy(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, alice := influxdb.ID(100), influxdb.ID(200)
+
+	at := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	create := func(tx kv.Tx, actor, id influxdb.ID) {
+		at = at.Add(time.Second)
+		clock.Set(at)
+		err := store.CreateUser(tenant.WithActor(ctx, actor), tx, &influxdb.User{ID: id, Name: fmt.Sprintf("user%d", id), Status: "active"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		create(tx, bob, 1)
+		create(tx, bob, 2)
+		create(tx, alice, 3)
+		create(tx, bob, 4)
+		create(tx, bob, 5)
+
+		// deleted users are skipped, as are ones created again by someone else
+		if err := store.DeleteUser(ctx, tx, 2); err != nil {
+			return err
+		}
+		if err := store.DeleteUser(ctx, tx, 5); err != nil {
+			return err
+		}
+		create(tx, alice, 5)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		actor    influxdb.ID
+		opt      []influxdb.FindOptions
+		expected []influxdb.ID
+	}{
+		{name: "bob", actor: bob, expected: []influxdb.ID{1, 4}},
+		{name: "alice", actor: alice, expected: []influxdb.ID{3, 5}},
+		{name: "paged", actor: bob, opt: []influxdb.FindOptions{{Limit: 1, Offset: 1}}, expected: []influxdb.ID{4}},
+		{name: "nobody", actor: 300, expected: []influxdb.ID{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.View(ctx, func(tx kv.Tx) error {
+				us, err := store.FindUsersCreatedBy(ctx, tx, tt.actor, tt.opt...)
+				if err != nil {
+					return err
+				}
+
+				ids := []influxdb.ID{}
+				for _, u := range us {
+					ids = append(ids, u.ID)
+				}
+				if !reflect.DeepEqual(ids, tt.expected) {
+					t.Fatalf("expected users created by actor: \n%+v\n%+v", tt.expected, ids)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package tenant
+
+import (
+	"context"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// MissingPolicy is what a batch operation does with ids that don't exist.
+type MissingPolicy int
+
+const (
+	// MissingSkip leaves missing ids out of the result.
+	MissingSkip MissingPolicy = iota
+	// MissingError fails the whole batch before anything is written.
+	MissingError
+	// MissingNilPlaceholder keeps the result aligned with the ids, with nil
+	// where an id doesn't exist.
+	MissingNilPlaceholder
+)
+
+// BatchOpts configures the batch user operations.
+type BatchOpts struct {
+	OnMissing MissingPolicy
+}
+
+// GetUsers resolves ids in order, treating missing ids as opts says.
+func (s *Store) GetUsers(ctx context.Context, tx kv.Tx, ids []influxdb.ID, opts BatchOpts) ([]*influxdb.User, error) {
+	found, err := s.GetUsersByIDs(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	us := make([]*influxdb.User, 0, len(ids))
+	for _, id := range ids {
+		u, ok := found[id]
+		if !ok {
+			switch opts.OnMissing {
+			case MissingError:
+				return nil, MissingUserError(id)
+			case MissingSkip:
+				continue
+			}
+		}
+		us = append(us, u)
+	}
+
+	return us, nil
+}
+
+// DeleteUsers deletes the users with the given ids and returns them, treating
+// missing ids as opts says.
+func (s *Store) DeleteUsers(ctx context.Context, tx kv.Tx, ids []influxdb.ID, opts BatchOpts) ([]*influxdb.User, error) {
+	us, err := s.GetUsers(ctx, tx, ids, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// an id listed twice is only deleted once
+	deleted := make(map[influxdb.ID]struct{}, len(us))
+	for _, u := range us {
+		if u == nil {
+			continue
+		}
+		if _, ok := deleted[u.ID]; ok {
+			continue
+		}
+		if err := s.DeleteUser(ctx, tx, u.ID); err != nil {
+			return nil, err
+		}
+		deleted[u.ID] = struct{}{}
+	}
+
+	return us, nil
+}
+
+// SetUsersStatus sets the status of the users with the given ids and returns
+// them updated, treating missing ids as opts says.
+func (s *Store) SetUsersStatus(ctx context.Context, tx kv.Tx, ids []influxdb.ID, status influxdb.Status, opts BatchOpts) ([]*influxdb.User, error) {
+	us, err := s.GetUsers(ctx, tx, ids, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, u := range us {
+		if u == nil {
+			continue
+		}
+		updated, err := s.UpdateUser(ctx, tx, u.ID, influxdb.UserUpdate{Status: &status})
+		if err != nil {
+			return nil, err
+		}
+		us[i] = updated
+	}
+
+	return us, nil
+}
+
+// DeleteUsersByStatus deletes every user with status, e.g. all the inactive
+// ones when decommissioning, and returns how many were deleted.
+func (s *Store) DeleteUsersByStatus(ctx context.Context, tx kv.Tx, status influxdb.Status) (int, error) {
+	// collect the ids first so deletes can't invalidate the cursor
+	ids, err := s.userIDsWhere(ctx, tx, func(u *influxdb.User) bool {
+		return u.Status == status
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return s.deleteUserIDs(ctx, tx, ids)
+}
+
+// DeleteUsersWhere deletes every user match selects and returns how many were
+// deleted. As a guard against a filter selecting far more than intended it
+// deletes nothing and fails with ErrTooManyMatches when more than expectedMax
+// users match.
+func (s *Store) DeleteUsersWhere(ctx context.Context, tx kv.Tx, match func(*influxdb.User) bool, expectedMax int) (int, error) {
+	ids, err := s.userIDsWhere(ctx, tx, match)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) > expectedMax {
+		return 0, ErrTooManyMatches
+	}
+
+	return s.deleteUserIDs(ctx, tx, ids)
+}
+
+func (s *Store) deleteUserIDs(ctx context.Context, tx kv.Tx, ids []influxdb.ID) (int, error) {
+	for _, id := range ids {
+		if err := s.DeleteUser(ctx, tx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), nil
+}
+
+// userIDsWhere returns the ids of the live users match selects in id order.
+func (s *Store) userIDsWhere(ctx context.Context, tx kv.Tx, match func(*influxdb.User) bool) ([]influxdb.ID, error) {
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var ids []influxdb.ID
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return nil, err
+		}
+
+		// tombstones are left for CompactUsers
+		if u.DeletedAt == nil && s.inScope(u.Name) && match(u) {
+			ids = append(ids, u.ID)
+		}
+	}
+
+	return ids, cursor.Err()
+}
+
+// CreateUsers stores many new users at once. The whole batch is validated
+// before anything is written, then the writes are grouped per bucket, all the
+// blobs first and all the name index entries after, so backends that suffer
+// from alternating between the two buckets' pages touch each of them once.
+func (s *Store) CreateUsers(ctx context.Context, tx kv.Tx, us []*influxdb.User) error {
+	type pending struct {
+		u         *influxdb.User
+		encodedID []byte
+		v         []byte
+		created   *time.Time
+	}
+
+	batch := make([]pending, 0, len(us))
+	ids := make(map[string]struct{}, len(us))
+	names := make(map[string]struct{}, len(us))
+	fields := map[string]struct{}{}
+	for _, u := range us {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		encodedID, err := s.encodeNewID(u.ID)
+		if err != nil {
+			return InvalidUserIDError(err)
+		}
+
+		// a later blob would overwrite an earlier one and leave its name
+		// index entry pointing at the wrong user
+		if _, ok := ids[string(encodedID)]; ok {
+			return DuplicateUserIDError(u.ID)
+		}
+		ids[string(encodedID)] = struct{}{}
+
+		if err := s.validateUserName(u.Name); err != nil {
+			return err
+		}
+
+		if err := s.validateUserFields(u); err != nil {
+			return err
+		}
+
+		// the store only knows about the users written before the batch, so
+		// the batch is also checked against itself
+		key := string(s.userIndexKey(u.Name))
+		if _, ok := names[key]; ok {
+			return UserAlreadyExistsError(u.Name)
+		}
+		names[key] = struct{}{}
+
+		if err := s.uniqueUserName(ctx, tx, u.Name); err != nil {
+			return err
+		}
+
+		for _, idx := range s.fieldIndexes {
+			if !idx.unique {
+				continue
+			}
+			for _, fv := range idx.fieldValues(u) {
+				k := string(idx.key(fv, encodedID))
+				if _, ok := fields[k]; ok {
+					return UserFieldConflictError(idx.field, fv)
+				}
+				fields[k] = struct{}{}
+			}
+		}
+
+		if err := s.checkUserFields(ctx, tx, encodedID, u); err != nil {
+			return err
+		}
+
+		batch = append(batch, pending{u: u, encodedID: encodedID, created: u.CreatedAt})
+	}
+
+	// stamp only once the whole batch is accepted, a rejected batch leaves
+	// the caller's users as they were
+	for i := range batch {
+		p := &batch[i]
+		s.stampCreatedAt(p.u)
+
+		v, err := s.marshalUser(p.u)
+		if err != nil {
+			for _, p := range batch[:i+1] {
+				p.u.CreatedAt = p.created
+			}
+			return err
+		}
+		p.v = v
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range batch {
+		if err := b.Put(p.encodedID, p.v); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range batch {
+		if err := idx.Put(s.userIndexKey(p.u.Name), p.encodedID); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	for _, p := range batch {
+		if err := s.indexUserFields(ctx, tx, p.encodedID, nil, p.u); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range batch {
+		if err := s.verifyUserWrite(ctx, tx, p.u); err != nil {
+			return err
+		}
+
+		if err := s.userMutated(ctx, tx, UserAuditCreate, nil, p.u); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,368 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestCreateUsers(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		err := store.CreateUsers(ctx, tx, []*influxdb.User{
+			{ID: 2, Name: "user2", Status: "active"},
+			{ID: 3, Name: "user1", Status: "active"},
+		})
+		if influxdb.ErrorCode(err) != influxdb.EConflict {
+			t.Fatalf("expected a batch clashing with the store to fail, got: %v", err)
+		}
+
+		err = store.CreateUsers(ctx, tx, []*influxdb.User{
+			{ID: 2, Name: "user2", Status: "active"},
+			{ID: 3, Name: "user2", Status: "active"},
+		})
+		if influxdb.ErrorCode(err) != influxdb.EConflict {
+			t.Fatalf("expected a batch clashing with itself to fail, got: %v", err)
+		}
+
+		err = store.CreateUsers(ctx, tx, []*influxdb.User{
+			{ID: 2, Name: "user2", Status: "active"},
+			{ID: 2, Name: "user3", Status: "active"},
+		})
+		if influxdb.ErrorCode(err) != influxdb.EConflict {
+			t.Fatalf("expected a batch repeating an id to fail, got: %v", err)
+		}
+
+		if _, err := store.GetUser(ctx, tx, 2); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected nothing of a rejected batch to be written, got: %v", err)
+		}
+		if _, err := store.GetUserByName(ctx, tx, "user3"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected nothing of a rejected batch to be indexed, got: %v", err)
+		}
+
+		return store.CreateUsers(ctx, tx, []*influxdb.User{
+			{ID: 2, Name: "user2", Status: "active"},
+			{ID: 3, Name: "user3", Status: "active"},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 3; i++ {
+			u, err := store.GetUserByName(ctx, tx, fmt.Sprintf("user%d", i))
+			if err != nil {
+				return err
+			}
+			if u.ID != influxdb.ID(i) {
+				t.Fatalf("expected user%d to resolve to %d got: %v", i, i, u.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// switchCountingTx counts how often consecutive puts move to another bucket.
+type switchCountingTx struct {
+	kv.Tx
+	last     *string
+	switches *int
+}
+
+func (tx switchCountingTx) Bucket(b []byte) (kv.Bucket, error) {
+	bkt, err := tx.Tx.Bucket(b)
+	if err != nil {
+		return nil, err
+	}
+	return switchCountingBucket{Bucket: bkt, name: string(b), tx: tx}, nil
+}
+
+type switchCountingBucket struct {
+	kv.Bucket
+	name string
+	tx   switchCountingTx
+}
+
+func (b switchCountingBucket) Put(k, v []byte) error {
+	if *b.tx.last != b.name {
+		*b.tx.switches++
+		*b.tx.last = b.name
+	}
+	return b.Bucket.Put(k, v)
+}
+
+func benchmarkCreateUsers(b *testing.B, create func(ctx context.Context, store *tenant.Store, tx kv.Tx, us []*influxdb.User) error) {
+	ctx := context.Background()
+	const n = 100
+
+	switches := 0
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		store, err := tenant.NewStore(inmem.NewKVStore())
+		if err != nil {
+			b.Fatal(err)
+		}
+		us := make([]*influxdb.User, n)
+		for j := range us {
+			us[j] = &influxdb.User{ID: influxdb.ID(j + 1), Name: fmt.Sprintf("user%d", j), Status: "active"}
+		}
+		b.StartTimer()
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			var last string
+			return create(ctx, store, switchCountingTx{Tx: tx, last: &last, switches: &switches}, us)
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(switches)/float64(b.N), "bucketswitches/op")
+}
+
+func BenchmarkCreateUserLoop(b *testing.B) {
+	benchmarkCreateUsers(b, func(ctx context.Context, store *tenant.Store, tx kv.Tx, us []*influxdb.User) error {
+		for _, u := range us {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func BenchmarkCreateUsers(b *testing.B) {
+	benchmarkCreateUsers(b, func(ctx context.Context, store *tenant.Store, tx kv.Tx, us []*influxdb.User) error {
+		return store.CreateUsers(ctx, tx, us)
+	})
+}
+
+func TestBatchMissingPolicy(t *testing.T) {
+	ids := []influxdb.ID{1, 42, 2}
+
+	userIDs := func(us []*influxdb.User) []influxdb.ID {
+		out := []influxdb.ID{}
+		for _, u := range us {
+			if u == nil {
+				out = append(out, 0)
+				continue
+			}
+			out = append(out, u.ID)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		policy   tenant.MissingPolicy
+		expected []influxdb.ID
+		fails    bool
+	}{
+		{name: "skip", policy: tenant.MissingSkip, expected: []influxdb.ID{1, 2}},
+		{name: "error", policy: tenant.MissingError, fails: true},
+		{name: "nil placeholder", policy: tenant.MissingNilPlaceholder, expected: []influxdb.ID{1, 0, 2}},
+	}
+
+	ops := map[string]func(ctx context.Context, store *tenant.Store, tx kv.Tx, opts tenant.BatchOpts) ([]*influxdb.User, error){
+		"get": func(ctx context.Context, store *tenant.Store, tx kv.Tx, opts tenant.BatchOpts) ([]*influxdb.User, error) {
+			return store.GetUsers(ctx, tx, ids, opts)
+		},
+		"delete": func(ctx context.Context, store *tenant.Store, tx kv.Tx, opts tenant.BatchOpts) ([]*influxdb.User, error) {
+			return store.DeleteUsers(ctx, tx, ids, opts)
+		},
+		"set status": func(ctx context.Context, store *tenant.Store, tx kv.Tx, opts tenant.BatchOpts) ([]*influxdb.User, error) {
+			return store.SetUsersStatus(ctx, tx, ids, influxdb.Inactive, opts)
+		},
+	}
+
+	for opName, op := range ops {
+		for _, tt := range tests {
+			t.Run(opName+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				store, err := tenant.NewStore(inmem.NewKVStore())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = store.Update(ctx, func(tx kv.Tx) error {
+					return store.CreateUsers(ctx, tx, []*influxdb.User{
+						{ID: 1, Name: "user1", Status: influxdb.Active},
+						{ID: 2, Name: "user2", Status: influxdb.Active},
+					})
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = store.Update(ctx, func(tx kv.Tx) error {
+					us, err := op(ctx, store, tx, tenant.BatchOpts{OnMissing: tt.policy})
+					if tt.fails {
+						if influxdb.ErrorCode(err) != influxdb.ENotFound {
+							t.Fatalf("expected the batch to fail with not found, got: %v", err)
+						}
+
+						// nothing may have been touched
+						for _, id := range []influxdb.ID{1, 2} {
+							u, err := store.GetUser(ctx, tx, id)
+							if err != nil {
+								t.Fatalf("expected user %v to survive a failed batch: %v", id, err)
+							}
+							if u.Status != influxdb.Active {
+								t.Fatalf("expected user %v to be unchanged got: %v", id, u.Status)
+							}
+						}
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+
+					if got := userIDs(us); !reflect.DeepEqual(got, tt.expected) {
+						t.Fatalf("expected batch result to match: \n%+v\n%+v", tt.expected, got)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+func TestDeleteUsersByStatus(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUsers(ctx, tx, []*influxdb.User{
+			{ID: 1, Name: "user1", Status: influxdb.Active},
+			{ID: 2, Name: "user2", Status: influxdb.Inactive},
+			{ID: 3, Name: "user3", Status: influxdb.Active},
+			{ID: 4, Name: "user4", Status: influxdb.Inactive},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		n, err = store.DeleteUsersByStatus(ctx, tx, influxdb.Inactive)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 inactive users deleted, got: %d", n)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		us, err := store.ListUsers(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		expected := []*influxdb.User{
+			{ID: 1, Name: "user1", Status: influxdb.Active},
+			{ID: 3, Name: "user3", Status: influxdb.Active},
+		}
+		if !reflect.DeepEqual(us, expected) {
+			t.Fatalf("expected only the active users to remain: \n%+v\n%+v", us, expected)
+		}
+
+		if _, err := store.GetUserByName(ctx, tx, "user2"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected the name index entry to be removed, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteUsersWhere(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUsers(ctx, tx, []*influxdb.User{
+			{ID: 1, Name: "tmp-1", Status: influxdb.Active},
+			{ID: 2, Name: "tmp-2", Status: influxdb.Active},
+			{ID: 3, Name: "user3", Status: influxdb.Active},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	temporary := func(u *influxdb.User) bool { return strings.HasPrefix(u.Name, "tmp-") }
+	count := func() int {
+		var n int
+		err := store.View(ctx, func(tx kv.Tx) error {
+			us, err := store.ListUsers(ctx, tx)
+			n = len(us)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		_, err := store.DeleteUsersWhere(ctx, tx, temporary, 1)
+		return err
+	})
+	if err != tenant.ErrTooManyMatches {
+		t.Fatalf("expected deleting over the limit to be refused, got: %v", err)
+	}
+	if n := count(); n != 3 {
+		t.Fatalf("expected nothing to be deleted, %d users remain", n)
+	}
+
+	var n int
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		n, err = store.DeleteUsersWhere(ctx, tx, temporary, 2)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 users deleted, got: %d", n)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("expected only the unmatched user to remain, %d users remain", n)
+	}
+}
@@ -0,0 +1,152 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// UserCache caches the users of a Store by name. Entries are dropped once a
+// mutation of their user commits, and users read from a snapshot older than
+// the last commit are not cached, so a cached user is never older than the
+// last committed write. Mutations made in transactions not opened through
+// Store.Update drop entries as they are made. A read made in a transaction
+// that writes the same user and then rolls back can leave the uncommitted
+// user cached.
+type UserCache struct {
+	// the counters come first to keep them 64 bit aligned for atomic use
+	hits   uint64
+	misses uint64
+
+	store *Store
+
+	mu     sync.Mutex
+	byName map[string]*influxdb.User
+	names  map[influxdb.ID]string
+	// minGen is the generation of the last committed mutation, users read
+	// at an older one may be stale
+	minGen uint64
+}
+
+// UserCacheMetrics counts the lookups served by a UserCache.
+type UserCacheMetrics struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// NewUserCache builds an empty cache in front of s and registers the hook
+// that keeps it current.
+func NewUserCache(s *Store) *UserCache {
+	c := &UserCache{
+		store:  s,
+		byName: map[string]*influxdb.User{},
+		names:  map[influxdb.ID]string{},
+	}
+
+	s.registerCommitHook(func(e hookEvent) {
+		c.invalidate(e.user.ID, e.gen)
+	})
+
+	return c
+}
+
+// GetUserByName returns the user with the given name, from the cache when it
+// holds it.
+func (c *UserCache) GetUserByName(ctx context.Context, tx kv.Tx, n string) (*influxdb.User, error) {
+	key := string(c.store.userIndexKey(n))
+
+	c.mu.Lock()
+	u, ok := c.byName[key]
+	c.mu.Unlock()
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+		return copyUser(u), nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	gen, err := c.store.UsersGeneration(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err = c.store.GetUserByName(ctx, tx, n)
+	if err != nil {
+		return nil, err
+	}
+
+	c.add(u, gen)
+	return u, nil
+}
+
+// Metrics returns the hits and misses counted so far.
+func (c *UserCache) Metrics() UserCacheMetrics {
+	return UserCacheMetrics{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+var errWarmCacheFull = errors.New("user cache warmed up to its limit")
+
+// WarmCache loads up to limit users into the cache in id order, so the first
+// lookups after startup don't all miss.
+func (c *UserCache) WarmCache(ctx context.Context, store kv.Store, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	return store.View(ctx, func(tx kv.Tx) error {
+		gen, err := c.store.UsersGeneration(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		loaded := 0
+		_, err = c.store.WalkUsers(ctx, tx, influxdb.InvalidID(), func(u *influxdb.User) error {
+			if u.DeletedAt != nil {
+				return nil
+			}
+
+			c.add(u, gen)
+			loaded++
+			if loaded == limit {
+				return errWarmCacheFull
+			}
+			return nil
+		})
+		if err == errWarmCacheFull {
+			return nil
+		}
+		return err
+	})
+}
+
+// add caches u read at generation gen, unless a mutation has committed since.
+func (c *UserCache) add(u *influxdb.User, gen uint64) {
+	key := string(c.store.userIndexKey(u.Name))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen < c.minGen {
+		return
+	}
+	c.byName[key] = copyUser(u)
+	c.names[u.ID] = key
+}
+
+// invalidate drops the user id, mutated by the commit of generation gen.
+func (c *UserCache) invalidate(id influxdb.ID, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen > c.minGen {
+		c.minGen = gen
+	}
+	if key, ok := c.names[id]; ok {
+		delete(c.byName, key)
+		delete(c.names, id)
+	}
+}
@@ -0,0 +1,238 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestUserCacheWarm(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 5; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := tenant.NewUserCache(store)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := cache.WarmCache(canceled, kvStore, 5); err != context.Canceled {
+		t.Fatalf("expected warming with a canceled context to fail, got: %v", err)
+	}
+
+	if err := cache.WarmCache(ctx, kvStore, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 5; i++ {
+			u, err := cache.GetUserByName(ctx, tx, fmt.Sprintf("user%d", i))
+			if err != nil {
+				return err
+			}
+			if u.ID != influxdb.ID(i) {
+				t.Fatalf("expected user%d to resolve to %d got: %v", i, i, u.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m := cache.Metrics(); m != (tenant.UserCacheMetrics{Hits: 3, Misses: 2}) {
+		t.Fatalf("expected only the warmed users to hit, got: %+v", m)
+	}
+
+	// a rename drops the cached user
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		name := "renamed"
+		_, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := cache.GetUserByName(ctx, tx, "user1"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected the old name to be gone after a rename, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserCacheStaleSnapshot(t *testing.T) {
+	ctx := context.Background()
+	kvStore, closeStore := newBoltStore(t)
+	defer closeStore()
+
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// leave free pages behind so the writes below don't have to remap the
+	// file, which waits for the open reader
+	for _, fill := range [][]byte{make([]byte, 1<<20), nil} {
+		err := kvStore.Update(ctx, func(tx kv.Tx) error {
+			b, err := tx.Bucket([]byte("scratch"))
+			if err != nil {
+				return err
+			}
+			if fill == nil {
+				return b.Delete([]byte("fill"))
+			}
+			return b.Put([]byte("fill"), fill)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache := tenant.NewUserCache(store)
+	status := func(tx kv.Tx) (influxdb.Status, error) {
+		u, err := cache.GetUserByName(ctx, tx, "user1")
+		if err != nil {
+			return "", err
+		}
+		return u.Status, nil
+	}
+
+	// a reader whose snapshot predates a write reads the old user after the
+	// write committed
+	opened, committed, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- kvStore.View(ctx, func(tx kv.Tx) error {
+			close(opened)
+			<-committed
+			s, err := status(tx)
+			if err != nil {
+				return err
+			}
+			if s != influxdb.Active {
+				t.Errorf("expected the reader's snapshot to hold the old user, got: %s", s)
+			}
+			return nil
+		})
+	}()
+
+	<-opened
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		inactive := influxdb.Status("inactive")
+		_, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Status: &inactive})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(committed)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the old user it read wasn't cached
+	err = store.View(ctx, func(tx kv.Tx) error {
+		s, err := status(tx)
+		if err != nil {
+			return err
+		}
+		if s != influxdb.Inactive {
+			t.Fatalf("expected the committed user, got: %s", s)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserCacheTouch(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := tenant.NewUserCache(store)
+
+	var before *influxdb.User
+	var gen uint64
+	err = store.View(ctx, func(tx kv.Tx) error {
+		var err error
+		if before, err = cache.GetUserByName(ctx, tx, "user1"); err != nil {
+			return err
+		}
+		gen, err = store.UsersGeneration(ctx, tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.TouchUser(ctx, tx, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		u, err := cache.GetUserByName(ctx, tx, "user1")
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(u.UpdatedAt, before.UpdatedAt) {
+			t.Fatalf("expected the touch to drop the cached user, got: %+v", u)
+		}
+
+		after, err := store.UsersGeneration(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if after <= gen {
+			t.Fatalf("expected the touch to advance the generation past %d, got: %d", gen, after)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
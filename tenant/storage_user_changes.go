@@ -0,0 +1,204 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/influxdata/influxdb/kv"
+	"go.uber.org/zap"
+)
+
+var (
+	userChangeIndex = []byte("userchangeindexv1")
+)
+
+const (
+	// userChangesBatch caps the changes read in a single transaction.
+	userChangesBatch = 100
+	// userChangePollInterval is how often StreamUserChanges looks for new
+	// changes once it has caught up.
+	userChangePollInterval = 100 * time.Millisecond
+)
+
+// UserChange is a user mutation streamed by StreamUserChanges.
+type UserChange struct {
+	// Generation is the users generation the mutation advanced to. Resume a
+	// stream from the last one received.
+	Generation uint64 `json:"generation"`
+	UserAuditEntry
+}
+
+// userChangePrefix scopes the change index to the store's user bucket.
+func (s *Store) userChangePrefix() []byte {
+	return append(append([]byte(nil), s.userBucket...), 0)
+}
+
+// userChangeKey orders the change index by generation.
+func (s *Store) userChangeKey(gen uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, gen)
+	return append(s.userChangePrefix(), k...)
+}
+
+// indexUserChange points the generation a mutation advanced to at its audit
+// entry.
+func (s *Store) indexUserChange(tx kv.Tx, gen uint64, auditKey []byte) error {
+	b, err := tx.Bucket(userChangeIndex)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Put(s.userChangeKey(gen), auditKey); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	return nil
+}
+
+// userChangesSince returns up to userChangesBatch changes after generation
+// last, oldest first. Changes whose audit entry has been purged are skipped.
+func (s *Store) userChangesSince(ctx context.Context, tx kv.Tx, last uint64) ([]UserChange, error) {
+	idx, err := tx.Bucket(userChangeIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := tx.Bucket(s.auditBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := s.userChangePrefix()
+	cursor, err := idx.ForwardCursor(s.userChangeKey(last+1), kv.WithCursorPrefix(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	cs := []UserChange{}
+	for k, v := cursor.Next(); k != nil && len(cs) < userChangesBatch; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		av, err := audit.Get(v)
+		if kv.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, ErrInternalServiceError(err)
+		}
+
+		c := UserChange{Generation: binary.BigEndian.Uint64(k[len(prefix):])}
+		if err := json.Unmarshal(av, &c.UserAuditEntry); err != nil {
+			return nil, ErrCorruptUserAudit(err)
+		}
+
+		cs = append(cs, c)
+	}
+
+	return cs, cursor.Err()
+}
+
+// StreamUserChanges replays the user changes made after generation sinceGen
+// and then tails new ones, sending them on the returned channel oldest first
+// until ctx is cancelled. The channel is closed when the stream ends. Pass the
+// Generation of the last change received to resume a stream. Changes whose
+// audit entries CompactUserAudit purged are not replayed.
+func (s *Store) StreamUserChanges(ctx context.Context, store kv.Store, sinceGen uint64) (<-chan UserChange, error) {
+	err := store.View(ctx, func(tx kv.Tx) error {
+		_, err := tx.Bucket(userChangeIndex)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan UserChange)
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(userChangePollInterval)
+		defer ticker.Stop()
+
+		last := sinceGen
+		for {
+			var cs []UserChange
+			err := store.View(ctx, func(tx kv.Tx) error {
+				var err error
+				cs, err = s.userChangesSince(ctx, tx, last)
+				return err
+			})
+			if err != nil && ctx.Err() == nil {
+				s.log.Warn("Failed to read user changes",
+					zap.Uint64("generation", last),
+					zap.Error(err))
+			}
+
+			for _, c := range cs {
+				select {
+				case ch <- c:
+					last = c.Generation
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if len(cs) == userChangesBatch {
+				// more may be waiting, read on without sleeping
+				continue
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// compactUserChanges drops the change index entries pointing at audit entries
+// before cutoff. Generations and audit keys grow together so the scan stops at
+// the first entry that is kept.
+func (s *Store) compactUserChanges(tx kv.Tx, cutoff []byte) error {
+	b, err := tx.Bucket(userChangeIndex)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := b.ForwardCursor(s.userChangePrefix(), kv.WithCursorPrefix(s.userChangePrefix()))
+	if err != nil {
+		return err
+	}
+
+	// collect the keys first so deletes can't invalidate the cursor
+	var keys [][]byte
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if bytes.Compare(v, cutoff) >= 0 {
+			break
+		}
+		keys = append(keys, append([]byte(nil), k...))
+	}
+
+	if err := cursor.Err(); err != nil {
+		cursor.Close()
+		return err
+	}
+	if err := cursor.Close(); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestStreamUserChanges(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(id influxdb.ID) {
+		t.Helper()
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUser(ctx, tx, &influxdb.User{ID: id, Name: fmt.Sprintf("user%d", id), Status: "active"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	receive := func(ch <-chan tenant.UserChange, n int) []string {
+		t.Helper()
+		var got []string
+		for len(got) < n {
+			select {
+			case c, ok := <-ch:
+				if !ok {
+					t.Fatalf("expected the stream to stay open, got: %v", got)
+				}
+				got = append(got, fmt.Sprintf("%d %s %s", c.Generation, c.Action, c.Name))
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expected %d changes, got: %v", n, got)
+			}
+		}
+		return got
+	}
+
+	for i := 1; i <= 3; i++ {
+		create(influxdb.ID(i))
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	ch, err := store.StreamUserChanges(streamCtx, kvStore, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"1 create user1", "2 create user2", "3 create user3"}
+	if got := receive(ch, 3); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected historical changes to be replayed: \n%+v\n%+v", got, expected)
+	}
+
+	// made after the stream caught up
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.DeleteUser(ctx, tx, 2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []string{"4 delete user2"}
+	if got := receive(ch, 1); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the live change to be tailed: \n%+v\n%+v", got, expected)
+	}
+
+	cancel()
+	for range ch {
+	}
+
+	// resumed from a checkpoint only what came after it is sent
+	create(4)
+
+	resumeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err = store.StreamUserChanges(resumeCtx, kvStore, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []string{"4 delete user2", "5 create user4"}
+	if got := receive(ch, 2); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the stream to resume after the checkpoint: \n%+v\n%+v", got, expected)
+	}
+}
@@ -0,0 +1,221 @@
+package tenant_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+// xorCipher is a stand in for a real cipher, it is only good enough to make
+// the stored bytes unreadable.
+type xorCipher struct {
+	key byte
+}
+
+func (c xorCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	return c.xor(plaintext), nil
+}
+
+func (c xorCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, errors.New("empty ciphertext")
+	}
+	return c.xor(ciphertext), nil
+}
+
+func (c xorCipher) xor(v []byte) []byte {
+	out := make([]byte, len(v))
+	for i, b := range v {
+		out[i] = b ^ c.key
+	}
+	return out
+}
+
+func TestUserBlobCipher(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	plain, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := tenant.NewStore(kvStore, tenant.WithBlobCipher(xorCipher{key: 0x5a}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := &influxdb.User{ID: 1, Name: "user1", Status: "active", Email: "user1@example.com"}
+	user := &influxdb.User{ID: 2, Name: "user2", Status: "active", Email: "user2@example.com"}
+
+	err = plain.Update(ctx, func(tx kv.Tx) error {
+		return plain.CreateUser(ctx, tx, legacy)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encrypted.Update(ctx, func(tx kv.Tx) error {
+		return encrypted.CreateUser(ctx, tx, user)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readable := func(s *tenant.Store, id influxdb.ID) bool {
+		t.Helper()
+		var raw []byte
+		err := s.View(ctx, func(tx kv.Tx) error {
+			var err error
+			raw, err = s.GetUserRaw(ctx, tx, id)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Contains(raw, []byte("@example.com"))
+	}
+
+	if !readable(encrypted, 1) || readable(encrypted, 2) {
+		t.Fatalf("expected only blobs written with the cipher to be encrypted")
+	}
+
+	err = encrypted.View(ctx, func(tx kv.Tx) error {
+		for _, expected := range []*influxdb.User{legacy, user} {
+			u, err := encrypted.GetUserByName(ctx, tx, expected.Name)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(u, expected) {
+				t.Fatalf("expected user to round trip: \n%+v\n%+v", u, expected)
+			}
+
+			into := &influxdb.User{}
+			if err := encrypted.GetUserInto(ctx, tx, expected.ID, into); err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(into, expected) {
+				t.Fatalf("expected user to round trip in place: \n%+v\n%+v", into, expected)
+			}
+		}
+
+		var buf bytes.Buffer
+		if _, err := encrypted.ExportUsers(ctx, tx, &buf, tenant.UserFilter{}); err != nil {
+			return err
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected every user exported: \n%s", buf.String())
+		}
+		for _, l := range lines {
+			var u influxdb.User
+			if err := json.Unmarshal([]byte(l), &u); err != nil {
+				t.Fatalf("expected exported users as plaintext JSON: %v\n%s", err, l)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = plain.View(ctx, func(tx kv.Tx) error {
+		if _, err := plain.GetUser(ctx, tx, 2); influxdb.ErrorCode(err) != influxdb.EInternal {
+			t.Fatalf("expected an encrypted blob not to read without the cipher, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the legacy blob is encrypted by rewriting it
+	if _, err := encrypted.ReencodeUsers(ctx, kvStore, tenant.BinaryUserCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	if readable(encrypted, 1) {
+		t.Fatalf("expected reencoding to encrypt the legacy blob")
+	}
+
+	err = encrypted.View(ctx, func(tx kv.Tx) error {
+		u, err := encrypted.GetUser(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(u, legacy) {
+			t.Fatalf("expected reencoded user to round trip: \n%+v\n%+v", u, legacy)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutUserRawEncryptedSchema(t *testing.T) {
+	ctx := context.Background()
+	requireOAuthID := func(raw []byte) error {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		if _, ok := doc["oauthID"]; !ok {
+			return errors.New("missing required field oauthID")
+		}
+		return nil
+	}
+
+	cipher := xorCipher{key: 0x5a}
+	source, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithBlobCipher(cipher))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithBlobCipher(cipher), tenant.WithSchemaValidator(requireOAuthID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs := map[influxdb.ID][]byte{}
+	err = source.Update(ctx, func(tx kv.Tx) error {
+		for _, u := range []*influxdb.User{
+			{ID: 1, Name: "user1", OAuthID: "abc", Status: "active"},
+			{ID: 2, Name: "user2", Status: "active"},
+		} {
+			if err := source.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+			raw, err := source.GetUserRaw(ctx, tx, u.ID)
+			if err != nil {
+				return err
+			}
+			blobs[u.ID] = raw
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the validator sees the decrypted document, not the ciphertext
+	err = target.Update(ctx, func(tx kv.Tx) error {
+		return target.PutUserRaw(ctx, tx, 1, blobs[1])
+	})
+	if err != nil {
+		t.Fatalf("expected an encrypted blob passing the schema to be stored: %v", err)
+	}
+
+	err = target.Update(ctx, func(tx kv.Tx) error {
+		return target.PutUserRaw(ctx, tx, 2, blobs[2])
+	})
+	if influxdb.ErrorCode(err) != influxdb.EUnprocessableEntity {
+		t.Fatalf("expected an encrypted blob failing the schema to be rejected, got: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"sort"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// binaryUserVersion prefixes every blob BinaryUserCodec writes. JSON blobs
+// start with '{', so the builtin codecs tell the two apart while a store is
+// being reencoded.
+const binaryUserVersion byte = 1
+
+// reencodeUsersChunk is how many user records ReencodeUsers rewrites per
+// transaction.
+const reencodeUsersChunk = 100
+
+// BinaryUserCodec stores users gob encoded behind a version byte, which is
+// smaller and faster to decode than JSON. It still reads JSON blobs so a store
+// can switch to it before ReencodeUsers has rewritten them all.
+type BinaryUserCodec struct{}
+
+// binaryUser is what BinaryUserCodec encodes. Gob writes maps in iteration
+// order, so the maps are carried as sorted pairs to keep equal users encoding
+// to the same bytes.
+type binaryUser struct {
+	User          influxdb.User
+	Labels        []binaryLabel
+	Flags         []binaryFlag
+	SchemaVersion int
+}
+
+type binaryLabel struct {
+	Key, Value string
+}
+
+type binaryFlag struct {
+	Key   string
+	Value bool
+}
+
+func (BinaryUserCodec) Marshal(u *influxdb.User) ([]byte, error) {
+	bu := binaryUser{User: *u, SchemaVersion: UserSchemaVersion}
+	bu.User.Labels, bu.User.Flags = nil, nil
+	for k, v := range u.Labels {
+		bu.Labels = append(bu.Labels, binaryLabel{Key: k, Value: v})
+	}
+	for k, v := range u.Flags {
+		bu.Flags = append(bu.Flags, binaryFlag{Key: k, Value: v})
+	}
+	sort.Slice(bu.Labels, func(i, j int) bool { return bu.Labels[i].Key < bu.Labels[j].Key })
+	sort.Slice(bu.Flags, func(i, j int) bool { return bu.Flags[i].Key < bu.Flags[j].Key })
+
+	var buf bytes.Buffer
+	buf.WriteByte(binaryUserVersion)
+	if err := gob.NewEncoder(&buf).Encode(&bu); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c BinaryUserCodec) Unmarshal(v []byte) (*influxdb.User, error) {
+	u, _, err := c.UnmarshalVersion(v)
+	return u, err
+}
+
+func (BinaryUserCodec) UnmarshalVersion(v []byte) (*influxdb.User, int, error) {
+	if isJSONUser(v) {
+		return jsonUserCodec{}.UnmarshalVersion(v)
+	}
+	if len(v) == 0 || v[0] != binaryUserVersion {
+		return nil, 0, errors.New("unknown user encoding")
+	}
+
+	bu := binaryUser{}
+	if err := gob.NewDecoder(bytes.NewReader(v[1:])).Decode(&bu); err != nil {
+		return nil, 0, err
+	}
+
+	u := bu.User
+	if len(bu.Labels) > 0 {
+		u.Labels = make(map[string]string, len(bu.Labels))
+		for _, l := range bu.Labels {
+			u.Labels[l.Key] = l.Value
+		}
+	}
+	if len(bu.Flags) > 0 {
+		u.Flags = make(map[string]bool, len(bu.Flags))
+		for _, f := range bu.Flags {
+			u.Flags[f.Key] = f.Value
+		}
+	}
+	version, err := decodedSchemaVersion(bu.SchemaVersion)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &u, version, nil
+}
+
+func isJSONUser(v []byte) bool {
+	return len(v) > 0 && v[0] == '{'
+}
+
+func isBinaryUser(v []byte) bool {
+	return len(v) > 0 && v[0] == binaryUserVersion
+}
+
+// ReencodeUsers rewrites every user blob with codec and returns how many were
+// rewritten. Each chunk of users is rewritten in its own write transaction,
+// the builtin codecs read both encodings so the store keeps serving in
+// between. It should be rebuilt with codec once ReencodeUsers returns.
+func (s *Store) ReencodeUsers(ctx context.Context, store kv.Store, codec UserCodec) (int, error) {
+	rewritten := 0
+	var seek []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return rewritten, err
+		}
+
+		var done bool
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			last, n, err := s.reencodeUserChunk(tx, seek, codec)
+			if err != nil {
+				return err
+			}
+
+			rewritten += n
+			done = last == nil
+			seek = last
+			return nil
+		})
+		if err != nil {
+			return rewritten, err
+		}
+
+		if done {
+			return rewritten, nil
+		}
+	}
+}
+
+// reencodeUserChunk rewrites up to reencodeUsersChunk users after seek and
+// returns the last key scanned, nil once the bucket is exhausted.
+func (s *Store) reencodeUserChunk(tx kv.Tx, seek []byte, codec UserCodec) ([]byte, int, error) {
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := b.ForwardCursor(seek)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	type blob struct {
+		k, v []byte
+	}
+
+	// collect the chunk first so the puts can't invalidate the cursor
+	var (
+		last  []byte
+		blobs []blob
+	)
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		// the cursor starts at the last key of the previous chunk
+		if seek != nil && bytes.Equal(k, seek) {
+			continue
+		}
+
+		if len(blobs) == reencodeUsersChunk {
+			break
+		}
+		last = append([]byte(nil), k...)
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+		blobs = append(blobs, blob{k: last, v: append([]byte(nil), v...)})
+	}
+
+	if err := cursor.Err(); err != nil {
+		cursor.Close()
+		return nil, 0, err
+	}
+	if err := cursor.Close(); err != nil {
+		return nil, 0, err
+	}
+
+	if len(blobs) < reencodeUsersChunk {
+		last = nil
+	}
+
+	for _, bl := range blobs {
+		u, err := s.unmarshalUser(bl.v)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		v, err := codec.Marshal(u)
+		if err != nil {
+			return nil, 0, ErrUnprocessableUser(err)
+		}
+
+		if v, err = s.encryptUser(v); err != nil {
+			return nil, 0, ErrUnprocessableUser(err)
+		}
+
+		if err := b.Put(bl.k, v); err != nil {
+			return nil, 0, ErrWriteFailed(err)
+		}
+	}
+
+	return last, len(blobs), nil
+}
@@ -0,0 +1,174 @@
+package tenant_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestBinaryUserCodec(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithCodec(tenant.BinaryUserCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	user := &influxdb.User{
+		ID:        1,
+		Name:      "user1",
+		Status:    "active",
+		Email:     "user1@example.com",
+		ExpiresAt: &expires,
+		Labels:    map[string]string{"team": "storage", "region": "eu"},
+		Flags:     map[string]bool{"beta": true, "admin": false},
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, user); err != nil {
+			return err
+		}
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "inactive"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		raw, err := store.GetUserRaw(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if raw[0] != 1 {
+			t.Fatalf("expected the blob to carry the binary version byte, got: %q", raw)
+		}
+
+		u, err := store.GetUserByName(ctx, tx, "user1")
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(u, user) {
+			t.Fatalf("expected user to round trip: \n%+v\n%+v", u, user)
+		}
+
+		u, err = store.GetUser(ctx, tx, 2)
+		if err != nil {
+			return err
+		}
+		expected := &influxdb.User{ID: 2, Name: "user2", Status: "inactive"}
+		if !reflect.DeepEqual(u, expected) {
+			t.Fatalf("expected user without maps to round trip: \n%+v\n%+v", u, expected)
+		}
+
+		// exports stay JSON whatever the stored encoding
+		var buf bytes.Buffer
+		if _, err := store.ExportUsers(ctx, tx, &buf, tenant.UserFilter{}); err != nil {
+			return err
+		}
+		line, err := buf.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		exported := &influxdb.User{}
+		if err := json.Unmarshal(line, exported); err != nil {
+			t.Fatalf("expected export to be JSON: %v", err)
+		}
+		if !reflect.DeepEqual(exported, user) {
+			t.Fatalf("expected exported user to match: \n%+v\n%+v", exported, user)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// equal users encode to the same bytes
+	codec := tenant.BinaryUserCodec{}
+	first, err := codec.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		v, err := codec.Marshal(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, first) {
+			t.Fatalf("expected identical bytes for equal users: \n%q\n%q", v, first)
+		}
+	}
+}
+
+func TestReencodeUsers(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more than one chunk of users
+	var users []*influxdb.User
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 250; i++ {
+			u := &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active", Labels: map[string]string{"n": fmt.Sprint(i)}}
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+			users = append(users, u)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := store.ReencodeUsers(ctx, kvStore, tenant.BinaryUserCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(users) {
+		t.Fatalf("expected every user to be rewritten, got: %d", n)
+	}
+
+	binary, err := tenant.NewStore(kvStore, tenant.WithCodec(tenant.BinaryUserCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the JSON store keeps reading while the blobs are binary
+	for _, s := range []*tenant.Store{store, binary} {
+		err = s.View(ctx, func(tx kv.Tx) error {
+			for _, u := range users {
+				raw, err := s.GetUserRaw(ctx, tx, u.ID)
+				if err != nil {
+					return err
+				}
+				if raw[0] != 1 {
+					t.Fatalf("expected user %v to be reencoded, got: %q", u.ID, raw)
+				}
+
+				got, err := s.GetUserByName(ctx, tx, u.Name)
+				if err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(got, u) {
+					t.Fatalf("expected reencoded user to match: \n%+v\n%+v", got, u)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package tenant
+
+import (
+	"context"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+var (
+	userCreatedIndex = []byte("usercreatedindexv1")
+)
+
+// userCreatedKey orders the created index by time, laid out like the expiry
+// index.
+func userCreatedKey(at time.Time, encodedID []byte) []byte {
+	return userExpiryKey(at, encodedID)
+}
+
+// stampCreatedAt sets CreatedAt on a user being created when the store tracks
+// creation times. The monotonic reading is stripped so the user compares
+// equal to itself read back.
+func (s *Store) stampCreatedAt(u *influxdb.User) {
+	if !s.stampCreated || u.CreatedAt != nil {
+		return
+	}
+	now := s.now().UTC()
+	u.CreatedAt = &now
+}
+
+// indexUserCreated moves the created index entry of a user from old to u.
+func (s *Store) indexUserCreated(tx kv.Tx, encodedID []byte, old, u *influxdb.User) error {
+	var before, after *time.Time
+	if old != nil {
+		before = old.CreatedAt
+	}
+	if u != nil {
+		after = u.CreatedAt
+	}
+
+	if before == nil && after == nil {
+		return nil
+	}
+	if before != nil && after != nil && before.Equal(*after) {
+		return nil
+	}
+
+	b, err := tx.Bucket(s.createdIndex)
+	if err != nil {
+		return err
+	}
+
+	if before != nil {
+		if err := b.Delete(userCreatedKey(*before, encodedID)); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	if after != nil {
+		if err := b.Put(userCreatedKey(*after, encodedID), encodedID); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	return nil
+}
+
+// RecentUsers returns the n most recently created users, newest first. Only
+// the newest end of the created index is read, users without a CreatedAt are
+// never listed.
+func (s *Store) RecentUsers(ctx context.Context, tx kv.Tx, n int) ([]*influxdb.User, error) {
+	b, err := tx.Bucket(s.createdIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(nil, kv.WithCursorDirection(kv.CursorDescending))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	us := []*influxdb.User{}
+	for k, v := cursor.Next(); k != nil && len(us) < n; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		id, err := s.decodeID(v)
+		if err != nil {
+			return nil, ErrCorruptID(err)
+		}
+
+		u, err := s.GetUser(ctx, tx, id)
+		if err == ErrUserNotFound {
+			// outside the store's scope
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		us = append(us, u)
+	}
+
+	return us, cursor.Err()
+}
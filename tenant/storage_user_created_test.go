@@ -0,0 +1,126 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestRecentUsers(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(clock), tenant.WithCreationTimes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		// ids don't follow creation order
+		for i, id := range []influxdb.ID{3, 1, 5, 2} {
+			clock.Set(start.Add(time.Duration(i) * time.Hour))
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: id, Name: fmt.Sprintf("user%d", id), Status: "active"}); err != nil {
+				return err
+			}
+		}
+
+		// carried over from another store, created before all the others
+		created := start.Add(-time.Hour)
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 4, Name: "user4", Status: "active", CreatedAt: &created})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recent := func(n int, expected []influxdb.ID) {
+		t.Helper()
+		err := store.View(ctx, func(tx kv.Tx) error {
+			us, err := store.RecentUsers(ctx, tx, n)
+			if err != nil {
+				return err
+			}
+
+			ids := []influxdb.ID{}
+			for _, u := range us {
+				ids = append(ids, u.ID)
+			}
+			if !reflect.DeepEqual(ids, expected) {
+				t.Fatalf("expected newest users first: \n%+v\n%+v", ids, expected)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recent(3, []influxdb.ID{2, 5, 1})
+	recent(10, []influxdb.ID{2, 5, 1, 3, 4})
+	recent(0, []influxdb.ID{})
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.DeleteUser(ctx, tx, 2); err != nil {
+			return err
+		}
+		if err := store.SoftDeleteUser(ctx, tx, 1); err != nil {
+			return err
+		}
+
+		name := "user5b"
+		_, err := store.UpdateUser(ctx, tx, 5, influxdb.UserUpdate{Name: &name})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recent(10, []influxdb.ID{5, 3, 4})
+}
+
+func TestRejectedCreateLeavesUser(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	clock.Set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(clock), tenant.WithCreationTimes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+
+		taken := &influxdb.User{ID: 2, Name: "user1", Status: "active"}
+		if err := store.CreateUser(ctx, tx, taken); err == nil {
+			t.Fatal("expected a taken name to be rejected")
+		}
+		if taken.CreatedAt != nil {
+			t.Fatalf("expected a rejected create to leave the user alone, got: %v", taken.CreatedAt)
+		}
+
+		batch := []*influxdb.User{
+			{ID: 3, Name: "user3", Status: "active"},
+			{ID: 4, Name: "", Status: "active"},
+		}
+		if err := store.CreateUsers(ctx, tx, batch); err == nil {
+			t.Fatal("expected a batch with an empty name to be rejected")
+		}
+		for _, u := range batch {
+			if u.CreatedAt != nil {
+				t.Fatalf("expected a rejected batch to leave its users alone, got: %v", u.CreatedAt)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,74 @@
+package tenant
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// deleteTokenLength is an 8 byte big endian issue time followed by the
+// sha256 mac of the user bucket, the encoded user id and that time.
+const deleteTokenLength = 8 + sha256.Size
+
+// PrepareDeleteUser checks the user exists and returns a token that lets
+// ConfirmDeleteUser delete it until the store's delete token ttl runs out. The
+// token is signed rather than stored, so preparing a delete writes nothing.
+// Nothing records that a token was used either: it can be replayed until the
+// ttl runs out, which deletes a user re-created under the same id within it.
+func (s *Store) PrepareDeleteUser(ctx context.Context, tx kv.Tx, id influxdb.ID) (string, error) {
+	if _, err := s.GetUser(ctx, tx, id); err != nil {
+		return "", err
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return "", InvalidUserIDError(err)
+	}
+
+	token := make([]byte, 8, deleteTokenLength)
+	binary.BigEndian.PutUint64(token, uint64(s.now().UnixNano()))
+	token = append(token, s.deleteTokenMAC(encodedID, token[:8])...)
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// ConfirmDeleteUser deletes the user if token was issued for it by
+// PrepareDeleteUser and hasn't expired.
+func (s *Store) ConfirmDeleteUser(ctx context.Context, tx kv.Tx, id influxdb.ID, token string) error {
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != deleteTokenLength {
+		return ErrInvalidDeleteToken
+	}
+
+	if !hmac.Equal(b[8:], s.deleteTokenMAC(encodedID, b[:8])) {
+		return ErrInvalidDeleteToken
+	}
+
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(b[:8])))
+	if s.now().Sub(issued) > s.deleteTTL {
+		return ErrDeleteTokenExpired
+	}
+
+	return s.DeleteUser(ctx, tx, id)
+}
+
+func (s *Store) deleteTokenMAC(encodedID, issued []byte) []byte {
+	mac := hmac.New(sha256.New, s.deleteSecret)
+	// stores sharing a secret over different buckets can't confirm each
+	// other's tokens
+	mac.Write(s.userBucket)
+	mac.Write(encodedID)
+	mac.Write(issued)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,121 @@
+package tenant_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestConfirmDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	clock.Set(time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(clock), tenant.WithDeleteTokens([]byte("secret"), time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for _, u := range []*influxdb.User{
+			{ID: 1, Name: "user1", Status: "active"},
+			{ID: 2, Name: "user2", Status: "active"},
+		} {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if _, err := store.PrepareDeleteUser(ctx, tx, 3); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected preparing to delete a missing user to fail, got: %v", err)
+		}
+
+		token1, err := store.PrepareDeleteUser(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		token2, err := store.PrepareDeleteUser(ctx, tx, 2)
+		if err != nil {
+			return err
+		}
+
+		// a token only deletes the user it was issued for
+		if err := store.ConfirmDeleteUser(ctx, tx, 1, token2); err != tenant.ErrInvalidDeleteToken {
+			t.Fatalf("expected another user's token to be rejected, got: %v", err)
+		}
+		if err := store.ConfirmDeleteUser(ctx, tx, 1, "garbage"); err != tenant.ErrInvalidDeleteToken {
+			t.Fatalf("expected a malformed token to be rejected, got: %v", err)
+		}
+
+		if err := store.ConfirmDeleteUser(ctx, tx, 1, token1); err != nil {
+			return err
+		}
+		if _, err := store.GetUser(ctx, tx, 1); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected user to be deleted, got: %v", err)
+		}
+
+		clock.Set(clock.Now().Add(2 * time.Minute))
+		if err := store.ConfirmDeleteUser(ctx, tx, 2, token2); err != tenant.ErrDeleteTokenExpired {
+			t.Fatalf("expected an expired token to be rejected, got: %v", err)
+		}
+		if _, err := store.GetUser(ctx, tx, 2); err != nil {
+			t.Fatalf("expected user to survive an expired token: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfirmDeleteUserBuckets(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	secret := tenant.WithDeleteTokens([]byte("secret"), time.Minute)
+
+	prod, err := tenant.NewStore(kvStore, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging, err := tenant.NewStore(kvStore, secret, tenant.WithUserBuckets([]byte("stagingusersv1"), []byte("stagingindexv1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = prod.Update(ctx, func(tx kv.Tx) error {
+		for _, s := range []*tenant.Store{prod, staging} {
+			if err := s.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+				return err
+			}
+		}
+
+		token, err := staging.PrepareDeleteUser(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+
+		// same secret and id, but issued for another bucket
+		if err := prod.ConfirmDeleteUser(ctx, tx, 1, token); err != tenant.ErrInvalidDeleteToken {
+			t.Fatalf("expected a token for another bucket to be rejected, got: %v", err)
+		}
+		if _, err := prod.GetUser(ctx, tx, 1); err != nil {
+			t.Fatalf("expected user to survive another bucket's token: %v", err)
+		}
+
+		return staging.ConfirmDeleteUser(ctx, tx, 1, token)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
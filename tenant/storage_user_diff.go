@@ -0,0 +1,75 @@
+package tenant
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/influxdata/influxdb"
+)
+
+// FieldChange is a field that differs between two versions of a user.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// DiffUsers returns the fields that differ from old to updated in a stable
+// order. Labels and flags are compared key by key and reported as
+// labels.<key> and flags.<key>, an unset flag diffs as false. The
+// UpdatedAt bookkeeping is not reported. A nil user diffs as an empty one.
+func DiffUsers(old, updated *influxdb.User) []FieldChange {
+	if old == nil {
+		old = &influxdb.User{}
+	}
+	if updated == nil {
+		updated = &influxdb.User{}
+	}
+
+	var changes []FieldChange
+	diff := func(field, before, after string) {
+		if before != after {
+			changes = append(changes, FieldChange{Field: field, Before: before, After: after})
+		}
+	}
+
+	if old.ID != updated.ID {
+		diff("id", old.ID.String(), updated.ID.String())
+	}
+	diff("name", old.Name, updated.Name)
+	diff("oauthID", old.OAuthID, updated.OAuthID)
+	diff("email", old.Email, updated.Email)
+	diff("status", string(old.Status), string(updated.Status))
+
+	keys := make([]string, 0, len(old.Labels)+len(updated.Labels))
+	for k := range old.Labels {
+		keys = append(keys, k)
+	}
+	for k := range updated.Labels {
+		if _, ok := old.Labels[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		diff("labels."+k, old.Labels[k], updated.Labels[k])
+	}
+
+	flags := make([]string, 0, len(old.Flags)+len(updated.Flags))
+	for k := range old.Flags {
+		flags = append(flags, k)
+	}
+	for k := range updated.Flags {
+		if _, ok := old.Flags[k]; !ok {
+			flags = append(flags, k)
+		}
+	}
+	sort.Strings(flags)
+
+	for _, k := range flags {
+		diff("flags."+k, strconv.FormatBool(old.Flags[k]), strconv.FormatBool(updated.Flags[k]))
+	}
+
+	return changes
+}
@@ -0,0 +1,108 @@
+package tenant_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/mock"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestDiffUsers(t *testing.T) {
+	base := &influxdb.User{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "ui", "site": "eu"}}
+
+	tests := []struct {
+		name     string
+		updated  *influxdb.User
+		expected []tenant.FieldChange
+	}{
+		{
+			name:    "name change",
+			updated: &influxdb.User{ID: 1, Name: "user10", Status: "active", Labels: map[string]string{"team": "ui", "site": "eu"}},
+			expected: []tenant.FieldChange{
+				{Field: "name", Before: "user1", After: "user10"},
+			},
+		},
+		{
+			name:    "status change",
+			updated: &influxdb.User{ID: 1, Name: "user1", Status: "inactive", Labels: map[string]string{"team": "ui", "site": "eu"}},
+			expected: []tenant.FieldChange{
+				{Field: "status", Before: "active", After: "inactive"},
+			},
+		},
+		{
+			name:    "label changes",
+			updated: &influxdb.User{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "storage", "role": "dev"}},
+			expected: []tenant.FieldChange{
+				{Field: "labels.role", Before: "", After: "dev"},
+				{Field: "labels.site", Before: "eu", After: ""},
+				{Field: "labels.team", Before: "ui", After: "storage"},
+			},
+		},
+		{
+			name: "no change",
+			updated: &influxdb.User{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "ui", "site": "eu"},
+				UpdatedAt: &testUpdatedAt},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if changes := tenant.DiffUsers(base, tt.updated); !reflect.DeepEqual(changes, tt.expected) {
+				t.Fatalf("expected identical changes: \n%+v\n%+v", changes, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUpdateUserAuditChanges(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(mock.TimeGenerator{FakeValue: testUpdatedAt}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+
+		name, status := "user10", influxdb.Inactive
+		_, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name, Status: &status})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		entries, err := store.ListUserAudit(ctx, tx, testUpdatedAt, testUpdatedAt.Add(time.Second))
+		if err != nil {
+			return err
+		}
+		if len(entries) != 2 {
+			t.Fatalf("expected create and update entries got: %d", len(entries))
+		}
+
+		if entries[0].Changes != nil {
+			t.Fatalf("expected no changes recorded on create got: %+v", entries[0].Changes)
+		}
+
+		expected := []tenant.FieldChange{
+			{Field: "name", Before: "user1", After: "user10"},
+			{Field: "status", Before: "active", After: "inactive"},
+		}
+		if !reflect.DeepEqual(entries[1].Changes, expected) {
+			t.Fatalf("expected update changes to be recorded: \n%+v\n%+v", entries[1].Changes, expected)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
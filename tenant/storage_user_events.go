@@ -0,0 +1,44 @@
+package tenant
+
+import (
+	"context"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"go.uber.org/zap"
+)
+
+// UserEvent describes a user mutation for an EventPublisher.
+type UserEvent struct {
+	Type   UserAuditAction `json:"type"`
+	UserID influxdb.ID     `json:"userID"`
+	Name   string          `json:"name"`
+	At     time.Time       `json:"at"`
+}
+
+// EventPublisher publishes user events, for instance to a message broker. It
+// is called once the transaction of the mutation has committed, so a rolled
+// back mutation is never published.
+type EventPublisher interface {
+	Publish(ctx context.Context, e UserEvent) error
+}
+
+// NopEventPublisher drops every event.
+type NopEventPublisher struct{}
+
+// Publish does nothing.
+func (NopEventPublisher) Publish(context.Context, UserEvent) error { return nil }
+
+func (s *Store) publishUserEvent(ctx context.Context, e UserEvent) error {
+	if err := s.publisher.Publish(ctx, e); err != nil {
+		if s.failOnPublish {
+			return ErrInternalServiceError(err)
+		}
+		s.log.Warn("Failed to publish user event",
+			zap.String("type", string(e.Type)),
+			zap.String("id", e.UserID.String()),
+			zap.Error(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/mock"
+	"github.com/influxdata/influxdb/tenant"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type recordingPublisher struct {
+	events []tenant.UserEvent
+	err    error
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, e tenant.UserEvent) error {
+	p.events = append(p.events, e)
+	return p.err
+}
+
+func TestEventPublisher(t *testing.T) {
+	ctx := context.Background()
+	pub := &recordingPublisher{}
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore, tenant.WithEventPublisher(pub), tenant.WithClock(mock.TimeGenerator{FakeValue: testUpdatedAt}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+		name := "user10"
+		if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name}); err != nil {
+			return err
+		}
+		// a failed mutation publishes nothing
+		if err := store.DeleteUser(ctx, tx, 2); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected deleting a missing user to fail, got: %v", err)
+		}
+		return store.DeleteUser(ctx, tx, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := testUpdatedAt.UTC()
+	expected := []tenant.UserEvent{
+		{Type: tenant.UserAuditCreate, UserID: 1, Name: "user1", At: at},
+		{Type: tenant.UserAuditUpdate, UserID: 1, Name: "user10", At: at},
+		{Type: tenant.UserAuditDelete, UserID: 1, Name: "user10", At: at},
+	}
+	if !reflect.DeepEqual(pub.events, expected) {
+		t.Fatalf("expected published events to match: \n%+v\n%+v", expected, pub.events)
+	}
+
+	// rolled back work is never published
+	pub.events = nil
+	rollback := errors.New("rollback")
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "active"}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if err != rollback {
+		t.Fatalf("expected the rollback error, got: %v", err)
+	}
+	if err := store.SelfTestUsers(ctx, kvStore); err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.events) != 0 {
+		t.Fatalf("expected rolled back mutations not to be published, got: %+v", pub.events)
+	}
+}
+
+func TestEventPublisherErrors(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		ctx := context.Background()
+		core, logs := observer.New(zap.WarnLevel)
+		opts := []tenant.StoreOption{
+			tenant.WithEventPublisher(&recordingPublisher{err: errors.New("broker unavailable")}),
+			tenant.WithLogger(zap.New(core)),
+		}
+		if fail {
+			opts = append(opts, tenant.WithFailOnPublishError())
+		}
+
+		store, err := tenant.NewStore(inmem.NewKVStore(), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+		})
+
+		if fail {
+			if influxdb.ErrorCode(err) != influxdb.EInternal {
+				t.Fatalf("expected the publish error to fail the create, got: %v", err)
+			}
+			// published after the commit, so the user is kept
+			err = store.View(ctx, func(tx kv.Tx) error {
+				_, err := store.GetUser(ctx, tx, 1)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("expected the user to be committed before publishing: %v", err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("expected the publish error not to fail the create: %v", err)
+		}
+		if n := logs.FilterMessage("Failed to publish user event").Len(); n != 1 {
+			t.Fatalf("expected the publish error to be logged once, got %d", n)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+	"go.uber.org/zap"
+)
+
+var (
+	userExpiryIndex = []byte("userexpiryindexv1")
+)
+
+// userExpiryKey orders the expiry index by time, an 8 byte big endian
+// timestamp followed by the encoded id so users expiring together don't
+// collide.
+func userExpiryKey(at time.Time, encodedID []byte) []byte {
+	k := make([]byte, 8, 8+len(encodedID))
+	binary.BigEndian.PutUint64(k, uint64(at.UnixNano()))
+	return append(k, encodedID...)
+}
+
+// indexUserExpiry moves the expiry index entry of a user from old to u.
+func (s *Store) indexUserExpiry(tx kv.Tx, encodedID []byte, old, u *influxdb.User) error {
+	var before, after *time.Time
+	if old != nil {
+		before = old.ExpiresAt
+	}
+	if u != nil {
+		after = u.ExpiresAt
+	}
+
+	if before == nil && after == nil {
+		return nil
+	}
+	if before != nil && after != nil && before.Equal(*after) {
+		return nil
+	}
+
+	b, err := tx.Bucket(s.expiryIndex)
+	if err != nil {
+		return err
+	}
+
+	if before != nil {
+		if err := b.Delete(userExpiryKey(*before, encodedID)); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	if after != nil {
+		if err := b.Put(userExpiryKey(*after, encodedID), encodedID); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	return nil
+}
+
+// ReapReport is what ReapExpiredUsers did.
+type ReapReport struct {
+	// Reaped is the number of expired users deleted.
+	Reaped int
+	// Skipped lists the ids of the expired users that couldn't be deleted,
+	// such as the last user of a store preventing its deletion. Why each
+	// was skipped is logged.
+	Skipped []influxdb.ID
+}
+
+// ReapExpiredUsers deletes the users whose ExpiresAt is before now. Only the
+// expired range of the expiry index is read. A user that can't be deleted is
+// skipped and reported rather than holding up the others, only storage errors
+// stop the reap.
+func (s *Store) ReapExpiredUsers(ctx context.Context, store kv.Store, now time.Time) (ReapReport, error) {
+	var r ReapReport
+	err := store.Update(ctx, func(tx kv.Tx) error {
+		r = ReapReport{}
+
+		b, err := tx.Bucket(s.expiryIndex)
+		if err != nil {
+			return err
+		}
+
+		cursor, err := b.ForwardCursor(nil)
+		if err != nil {
+			return err
+		}
+
+		// collect the ids first so deletes can't invalidate the cursor
+		stop := userExpiryKey(now, nil)
+		var ids []influxdb.ID
+		for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+			if bytes.Compare(k, stop) >= 0 {
+				break
+			}
+
+			id, err := s.decodeID(v)
+			if err != nil {
+				cursor.Close()
+				return ErrCorruptID(err)
+			}
+			ids = append(ids, id)
+		}
+
+		if err := cursor.Err(); err != nil {
+			cursor.Close()
+			return err
+		}
+		if err := cursor.Close(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			u, ok, err := s.getScopedUser(tx, id)
+			if err == ErrUserNotFound {
+				// a dangling index entry, nothing is left to delete
+				s.reapSkipped(&r, id, err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// soft deleted users are reaped by CompactUsers
+			if !ok || u.DeletedAt != nil {
+				continue
+			}
+
+			err = s.DeleteUser(ctx, tx, id)
+			if err == ErrCannotDeleteLastUser {
+				s.reapSkipped(&r, id, err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			r.Reaped++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return ReapReport{}, err
+	}
+
+	return r, nil
+}
+
+// reapSkipped records an expired user ReapExpiredUsers couldn't delete.
+func (s *Store) reapSkipped(r *ReapReport, id influxdb.ID, err error) {
+	s.log.Warn("Skipped reaping expired user",
+		zap.Stringer("id", id),
+		zap.Error(err))
+	r.Skipped = append(r.Skipped, id)
+}
@@ -0,0 +1,127 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestReapExpiredUsers(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	expiry := map[influxdb.ID]*time.Time{}
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	expiry[1] = at(-time.Hour)
+	expiry[2] = at(time.Hour)
+	expiry[3] = nil
+	expiry[4] = at(-time.Minute)
+	expiry[5] = at(0)
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 5; i++ {
+			id := influxdb.ID(i)
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: id, Name: fmt.Sprintf("user%d", i), Status: "active", ExpiresAt: expiry[id]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.ReapExpiredUsers(ctx, kvStore, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Reaped != 2 {
+		t.Fatalf("expected 2 users reaped got: %d", r.Reaped)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 5; i++ {
+			_, err := store.GetUserByName(ctx, tx, fmt.Sprintf("user%d", i))
+			expired := i == 1 || i == 4
+			if expired && err != tenant.ErrUserNotFound {
+				t.Fatalf("expected user%d to be reaped, got: %v", i, err)
+			}
+			if !expired && err != nil {
+				t.Fatalf("expected user%d to be kept, got: %v", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reaping again finds nothing, the reaped users left the expiry index
+	r, err = store.ReapExpiredUsers(ctx, kvStore, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Reaped != 0 {
+		t.Fatalf("expected nothing left to reap got: %d", r.Reaped)
+	}
+}
+
+func TestReapExpiredUsersSkipsFailures(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore, tenant.WithPreventLastUserDeletion())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	expired := now.Add(-time.Hour)
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 2; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active", ExpiresAt: &expired}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second user is the last one left once the first is reaped
+	r, err := store.ReapExpiredUsers(ctx, kvStore, now)
+	if err != nil {
+		t.Fatalf("expected the last user not to abort the reap: %v", err)
+	}
+	if expected := (tenant.ReapReport{Reaped: 1, Skipped: []influxdb.ID{2}}); !reflect.DeepEqual(r, expected) {
+		t.Fatalf("expected the reap to be reported: \n%+v\n%+v", expected, r)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.GetUser(ctx, tx, 1); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected user1 to be reaped, got: %v", err)
+		}
+		if _, err := store.GetUser(ctx, tx, 2); err != nil {
+			t.Fatalf("expected the last user to be kept: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
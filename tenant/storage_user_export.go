@@ -0,0 +1,299 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/models"
+	"golang.org/x/sync/errgroup"
+)
+
+// jsonDeletedAtKey is in every JSON tombstone, blobs without it are live.
+var jsonDeletedAtKey = []byte(`"deletedAt"`)
+
+// ExportUsers streams the users matching filter to w as newline delimited JSON
+// in id order. It returns the number of users written.
+func (s *Store) ExportUsers(ctx context.Context, tx kv.Tx, w io.Writer, filter UserFilter) (int, error) {
+	return s.exportUserRange(ctx, tx, w, filter, nil, nil)
+}
+
+// ExportUsersParallel produces the same output as ExportUsers but splits the id
+// keyspace into shards ranges that are scanned concurrently, each in its own
+// read transaction. The shard outputs are written to w in shard order so the
+// result stays ordered by id. Like FindUsersInIDRange it relies on the id
+// encoding keeping ids in order.
+func (s *Store) ExportUsersParallel(ctx context.Context, store kv.Store, w io.Writer, filter UserFilter, shards int) (int, error) {
+	if shards < 1 {
+		shards = 1
+	}
+
+	bufs := make([]bytes.Buffer, shards)
+	counts := make([]int, shards)
+
+	step := uint64(math.MaxUint64) / uint64(shards)
+
+	g, ctx := errgroup.WithContext(ctx)
+	for i := 0; i < shards; i++ {
+		i := i
+
+		var start, stop []byte
+		if i > 0 {
+			lo, err := s.encodeID(influxdb.ID(uint64(i) * step))
+			if err != nil {
+				return 0, InvalidUserIDError(err)
+			}
+			start = lo
+		}
+		if i < shards-1 {
+			hi, err := s.encodeID(influxdb.ID(uint64(i+1) * step))
+			if err != nil {
+				return 0, InvalidUserIDError(err)
+			}
+			stop = hi
+		}
+
+		g.Go(func() error {
+			return store.View(ctx, func(tx kv.Tx) error {
+				n, err := s.exportUserRange(ctx, tx, &bufs[i], filter, start, stop)
+				counts[i] = n
+				return err
+			})
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for i := range bufs {
+		if _, err := bufs[i].WriteTo(w); err != nil {
+			return total, err
+		}
+		total += counts[i]
+	}
+
+	return total, nil
+}
+
+// exportUserRange writes the users matching filter with encoded ids in
+// [start, stop) to w. A nil start begins at the first user and a nil stop runs
+// to the last. Tombstones are skipped unless the filter includes them. The
+// stored JSON blobs are written as is, they are only decoded when the filter
+// needs to look inside them, they may be tombstones or are binary encoded or
+// encrypted.
+func (s *Store) exportUserRange(ctx context.Context, tx kv.Tx, w io.Writer, filter UserFilter, start, stop []byte) (int, error) {
+	filter, ok := s.scopeFilter(filter)
+	if !ok {
+		return 0, nil
+	}
+
+	exclude, err := s.excludedKeys(filter)
+	if err != nil {
+		return 0, err
+	}
+	match := filterUsersFn(filter)
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := b.ForwardCursor(start)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	count := 0
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if stop != nil && bytes.Compare(k, stop) >= 0 {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		if _, ok := exclude[string(k)]; ok {
+			continue
+		}
+
+		if filter.decodes() || !isJSONUser(v) || bytes.Contains(v, jsonDeletedAtKey) {
+			u, err := s.unmarshalUser(v)
+			if err != nil {
+				return count, err
+			}
+			if (u.DeletedAt != nil && !filter.IncludeDeleted) || !match(u) {
+				continue
+			}
+
+			if !isJSONUser(v) {
+				if v, err = (jsonUserCodec{}).Marshal(u); err != nil {
+					return count, ErrUnprocessableUser(err)
+				}
+			}
+		}
+
+		if _, err := w.Write(v); err != nil {
+			return count, err
+		}
+		if _, err := w.Write([]byte("\n")); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, cursor.Err()
+}
+
+// StreamUsersJSON writes the users ListUsers would return to w as a JSON array
+// without holding them all in memory. Only listing in id order is supported.
+func (s *Store) StreamUsersJSON(ctx context.Context, tx kv.Tx, w io.Writer, opt ...influxdb.FindOptions) error {
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	switch o.SortBy {
+	case "", "id":
+	default:
+		return ErrUnsupportedSort
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := b.ForwardCursor(nil, cursorDirection(o))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	var seen map[string]struct{}
+	if s.dedupList {
+		seen = map[string]struct{}{}
+	}
+
+	page, scanned := newPaginator(o), 0
+	for k, v := cursor.Next(); k != nil && !page.full(); k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		scanned++
+		if s.scanExceeded(scanned) {
+			return ErrScanLimitExceeded
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		if seen != nil {
+			if _, ok := seen[string(k)]; ok {
+				continue
+			}
+			seen[string(k)] = struct{}{}
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil || u.DeletedAt != nil || !s.inScope(u.Name) {
+			continue
+		}
+
+		if !page.take() {
+			continue
+		}
+
+		j, err := json.Marshal(u)
+		if err != nil {
+			return ErrUnprocessableUser(err)
+		}
+
+		if page.taken > 1 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(j); err != nil {
+			return err
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// WriteUsersLineProtocol streams one line protocol point per live user to w,
+// tagged with the user id and status and carrying the name as a string field.
+// Every point is stamped with the current time. It returns the number of
+// points written.
+func (s *Store) WriteUsersLineProtocol(ctx context.Context, tx kv.Tx, w io.Writer, measurement string) (int, error) {
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := b.ForwardCursor(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	now := s.now()
+
+	count := 0
+	var buf []byte
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return count, err
+		}
+		if u.DeletedAt != nil || !s.inScope(u.Name) {
+			continue
+		}
+
+		tags := map[string]string{"id": u.ID.String()}
+		if u.Status != "" {
+			tags["status"] = string(u.Status)
+		}
+
+		pt, err := models.NewPoint(measurement, models.NewTags(tags), models.Fields{"name": u.Name}, now)
+		if err != nil {
+			return count, ErrUnprocessableUser(err)
+		}
+
+		buf = append(pt.AppendString(buf[:0]), '\n')
+		if _, err := w.Write(buf); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, cursor.Err()
+}
@@ -0,0 +1,399 @@
+package tenant_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/mock"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestExportUsersParallel(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// spread ids across the whole keyspace so every shard gets some users
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		for i := uint64(1); i <= 50; i++ {
+			err := store.CreateUser(ctx, tx, &influxdb.User{
+				ID:     influxdb.ID(i * 0x0500000000000000 / 2),
+				Name:   fmt.Sprintf("user%d", i),
+				Status: "active",
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var serial bytes.Buffer
+	err = kvStore.View(ctx, func(tx kv.Tx) error {
+		n, err := store.ExportUsers(ctx, tx, &serial, tenant.UserFilter{})
+		if err != nil {
+			return err
+		}
+		if n != 50 {
+			t.Fatalf("expected 50 users exported got: %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, shards := range []int{1, 3, 8, 64} {
+		t.Run(fmt.Sprintf("%d shards", shards), func(t *testing.T) {
+			var parallel bytes.Buffer
+			n, err := store.ExportUsersParallel(ctx, kvStore, &parallel, tenant.UserFilter{}, shards)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != 50 {
+				t.Fatalf("expected 50 users exported got: %d", n)
+			}
+
+			if !bytes.Equal(parallel.Bytes(), serial.Bytes()) {
+				t.Fatalf("expected parallel export to match serial export: \n%s\n%s", parallel.String(), serial.String())
+			}
+		})
+	}
+}
+
+// scanCountingStore records the most keys a single read transaction took off
+// its cursors.
+type scanCountingStore struct {
+	kv.Store
+	mu  sync.Mutex
+	max int
+}
+
+func (s *scanCountingStore) View(ctx context.Context, fn func(kv.Tx) error) error {
+	n := 0
+	err := s.Store.View(ctx, func(tx kv.Tx) error { return fn(&scanCountingTx{Tx: tx, n: &n}) })
+
+	s.mu.Lock()
+	if n > s.max {
+		s.max = n
+	}
+	s.mu.Unlock()
+	return err
+}
+
+type scanCountingTx struct {
+	kv.Tx
+	n *int
+}
+
+func (tx *scanCountingTx) Bucket(b []byte) (kv.Bucket, error) {
+	bkt, err := tx.Tx.Bucket(b)
+	if err != nil {
+		return nil, err
+	}
+	return &scanCountingBucket{Bucket: bkt, n: tx.n}, nil
+}
+
+type scanCountingBucket struct {
+	kv.Bucket
+	n *int
+}
+
+func (b *scanCountingBucket) ForwardCursor(seek []byte, opts ...kv.CursorOption) (kv.ForwardCursor, error) {
+	c, err := b.Bucket.ForwardCursor(seek, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &scanCountingCursor{ForwardCursor: c, n: b.n}, nil
+}
+
+type scanCountingCursor struct {
+	kv.ForwardCursor
+	n *int
+}
+
+func (c *scanCountingCursor) Next() ([]byte, []byte) {
+	k, v := c.ForwardCursor.Next()
+	if k != nil {
+		*c.n++
+	}
+	return k, v
+}
+
+func TestExportUsersParallelIDEncoding(t *testing.T) {
+	ctx := context.Background()
+	kvStore := &scanCountingStore{Store: inmem.NewKVStore()}
+
+	// ordered like the ids, but every key sorts after any bare hex id
+	encode := func(id influxdb.ID) ([]byte, error) {
+		b, err := id.Encode()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte("user/"), b...), nil
+	}
+	decode := func(v []byte) (influxdb.ID, error) {
+		var id influxdb.ID
+		err := id.Decode(bytes.TrimPrefix(v, []byte("user/")))
+		return id, err
+	}
+
+	store, err := tenant.NewStore(kvStore, tenant.WithIDEncoding(encode, decode))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		for i := uint64(1); i <= 50; i++ {
+			err := store.CreateUser(ctx, tx, &influxdb.User{
+				ID:     influxdb.ID(i * 0x0500000000000000 / 2),
+				Name:   fmt.Sprintf("user%d", i),
+				Status: "active",
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var serial bytes.Buffer
+	err = kvStore.View(ctx, func(tx kv.Tx) error {
+		_, err := store.ExportUsers(ctx, tx, &serial, tenant.UserFilter{})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kvStore.max = 0
+	var parallel bytes.Buffer
+	n, err := store.ExportUsersParallel(ctx, kvStore, &parallel, tenant.UserFilter{}, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 50 {
+		t.Fatalf("expected 50 users exported got: %d", n)
+	}
+	if !bytes.Equal(parallel.Bytes(), serial.Bytes()) {
+		t.Fatalf("expected parallel export to match serial export: \n%s\n%s", parallel.String(), serial.String())
+	}
+
+	// the ids are spread over the lower half of the keyspace, the shards cut
+	// from the stored keys split them between four of the eight
+	if kvStore.max > 25 {
+		t.Fatalf("expected the shards to split the users, one scanned %d", kvStore.max)
+	}
+}
+
+func TestExportUsersFiltered(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 10; i++ {
+			status := influxdb.Active
+			if i%3 == 0 {
+				status = influxdb.Inactive
+			}
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: status})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active := influxdb.Active
+	var buf bytes.Buffer
+	err = store.View(ctx, func(tx kv.Tx) error {
+		n, err := store.ExportUsers(ctx, tx, &buf, tenant.UserFilter{Status: &active})
+		if err != nil {
+			return err
+		}
+		if n != 7 {
+			t.Fatalf("expected 7 active users exported got: %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []influxdb.ID
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		u := &influxdb.User{}
+		if err := dec.Decode(u); err != nil {
+			t.Fatal(err)
+		}
+		if u.Status != influxdb.Active {
+			t.Errorf("expected only active users got: %+v", u)
+		}
+		ids = append(ids, u.ID)
+	}
+
+	expected := []influxdb.ID{1, 2, 4, 5, 7, 8, 10}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected active users in id order: \n%v\n%v", ids, expected)
+	}
+}
+
+func TestWriteUsersLineProtocol(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(mock.TimeGenerator{FakeValue: now}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []*influxdb.User{
+		{ID: 1, Name: "plain", Status: "active"},
+		{ID: 2, Name: "with space, and comma", Status: "in active"},
+		{ID: 3, Name: `quote"d back\slash`, Status: "a=b,c"},
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for _, u := range users {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = store.View(ctx, func(tx kv.Tx) error {
+		n, err := store.WriteUsersLineProtocol(ctx, tx, &buf, "user accounts,v2")
+		if err != nil {
+			return err
+		}
+		if n != len(users) {
+			t.Fatalf("expected %d points written got: %d", len(users), n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := models.ParsePointsWithPrecision(buf.Bytes(), []byte("users"), time.Time{}, "n")
+	if err != nil {
+		t.Fatalf("failed to parse line protocol %q: %v", buf.String(), err)
+	}
+
+	if len(points) != len(users) {
+		t.Fatalf("expected %d points got: %d", len(users), len(points))
+	}
+
+	for i, p := range points {
+		u := users[i]
+		if got := string(p.Tags().Get(models.MeasurementTagKeyBytes)); got != "user accounts,v2" {
+			t.Errorf("expected measurement %q got: %q", "user accounts,v2", got)
+		}
+
+		if got := string(p.Tags().Get([]byte("id"))); got != u.ID.String() {
+			t.Errorf("expected id tag %q got: %q", u.ID.String(), got)
+		}
+
+		if got := string(p.Tags().Get([]byte("status"))); got != string(u.Status) {
+			t.Errorf("expected status tag %q got: %q", u.Status, got)
+		}
+
+		fields, err := p.Fields()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fields["name"]; got != u.Name {
+			t.Errorf("expected name field %q got: %q", u.Name, got)
+		}
+
+		if !p.Time().Equal(now) {
+			t.Errorf("expected time %v got: %v", now, p.Time())
+		}
+	}
+}
+
+func TestStreamUsersJSON(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 5; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, o := range []influxdb.FindOptions{
+		{},
+		{Limit: 2},
+		{Limit: 2, Offset: 1},
+		{Offset: 3, Descending: true},
+		{Offset: 10},
+	} {
+		t.Run(fmt.Sprintf("%+v", o), func(t *testing.T) {
+			err := store.View(ctx, func(tx kv.Tx) error {
+				var buf bytes.Buffer
+				if err := store.StreamUsersJSON(ctx, tx, &buf, o); err != nil {
+					return err
+				}
+
+				streamed := []*influxdb.User{}
+				if err := json.Unmarshal(buf.Bytes(), &streamed); err != nil {
+					t.Fatalf("expected streamed output to be a JSON array: %v\n%s", err, buf.String())
+				}
+
+				listed, err := store.ListUsers(ctx, tx, o)
+				if err != nil {
+					return err
+				}
+
+				if !reflect.DeepEqual(streamed, listed) {
+					t.Fatalf("expected streamed users to match the listing: \n%+v\n%+v", listed, streamed)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// FindUsersWithFlag lists the users that have flag enabled in id order. The
+// flags field must be indexed, which it is by default.
+func (s *Store) FindUsersWithFlag(ctx context.Context, tx kv.Tx, flag string, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	return s.FindUsersByField(ctx, tx, "flags", flag, opt...)
+}
@@ -0,0 +1,102 @@
+package tenant_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestFindUsersWithFlag(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findIDs := func(t *testing.T, flag string) []influxdb.ID {
+		t.Helper()
+		var ids []influxdb.ID
+		err := store.View(ctx, func(tx kv.Tx) error {
+			users, err := store.FindUsersWithFlag(ctx, tx, flag)
+			if err != nil {
+				return err
+			}
+			for _, u := range users {
+				ids = append(ids, u.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		users := []*influxdb.User{
+			{ID: 1, Name: "user1", Status: "active", Flags: map[string]bool{"beta": true, "darkmode": true}},
+			{ID: 2, Name: "user2", Status: "active", Flags: map[string]bool{"beta": true}},
+			{ID: 3, Name: "user3", Status: "active", Flags: map[string]bool{"beta": false}},
+			{ID: 4, Name: "user4", Status: "active"},
+		}
+		for _, u := range users {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		if ids := findIDs(t, "beta"); !reflect.DeepEqual(ids, []influxdb.ID{1, 2}) {
+			t.Fatalf("expected users 1 and 2 got: %v", ids)
+		}
+		if ids := findIDs(t, "darkmode"); !reflect.DeepEqual(ids, []influxdb.ID{1}) {
+			t.Fatalf("expected user 1 got: %v", ids)
+		}
+		if ids := findIDs(t, "unknown"); len(ids) != 0 {
+			t.Fatalf("expected no users got: %v", ids)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Flags: map[string]bool{"beta": false, "darkmode": true}}); err != nil {
+				return err
+			}
+			_, err := store.UpdateUser(ctx, tx, 3, influxdb.UserUpdate{Flags: map[string]bool{"beta": true}})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids := findIDs(t, "beta"); !reflect.DeepEqual(ids, []influxdb.ID{2, 3}) {
+			t.Fatalf("expected users 2 and 3 got: %v", ids)
+		}
+		if ids := findIDs(t, "darkmode"); !reflect.DeepEqual(ids, []influxdb.ID{1}) {
+			t.Fatalf("expected user 1 got: %v", ids)
+		}
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			return store.DeleteUser(ctx, tx, 2)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids := findIDs(t, "beta"); !reflect.DeepEqual(ids, []influxdb.ID{3}) {
+			t.Fatalf("expected user 3 got: %v", ids)
+		}
+	})
+}
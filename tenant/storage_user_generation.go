@@ -0,0 +1,64 @@
+package tenant
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"github.com/influxdata/influxdb/kv"
+)
+
+var (
+	userGenerationBucket = []byte("usergenerationv1")
+)
+
+// UsersGeneration returns a counter advanced by every user mutation, so caches
+// can poll it and only refresh when it moved. It is zero until the first
+// mutation. Stores sharing a kv store through WithUserBuckets each keep their
+// own.
+func (s *Store) UsersGeneration(ctx context.Context, tx kv.Tx) (uint64, error) {
+	b, err := tx.Bucket(userGenerationBucket)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.usersGeneration(b)
+}
+
+func (s *Store) usersGeneration(b kv.Bucket) (uint64, error) {
+	v, err := b.Get(s.userBucket)
+	if kv.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, ErrInternalServiceError(err)
+	}
+
+	if len(v) != 8 {
+		return 0, ErrInternalServiceError(errors.New("corrupt users generation"))
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// bumpUsersGeneration advances the generation in the mutation's transaction
+// and returns the new one.
+func (s *Store) bumpUsersGeneration(tx kv.Tx) (uint64, error) {
+	b, err := tx.Bucket(userGenerationBucket)
+	if err != nil {
+		return 0, err
+	}
+
+	gen, err := s.usersGeneration(b)
+	if err != nil {
+		return 0, err
+	}
+	gen++
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, gen)
+	if err := b.Put(s.userBucket, v); err != nil {
+		return 0, ErrWriteFailed(err)
+	}
+
+	return gen, nil
+}
@@ -0,0 +1,79 @@
+package tenant_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestUsersGeneration(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generation := func() uint64 {
+		t.Helper()
+		var gen uint64
+		err := store.View(ctx, func(tx kv.Tx) error {
+			var err error
+			gen, err = store.UsersGeneration(ctx, tx)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gen
+	}
+
+	if gen := generation(); gen != 0 {
+		t.Fatalf("expected an untouched store to be at generation 0, got: %d", gen)
+	}
+
+	last := generation()
+	mutations := []struct {
+		name string
+		fn   func(tx kv.Tx) error
+	}{
+		{name: "create", fn: func(tx kv.Tx) error {
+			return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+		}},
+		{name: "update", fn: func(tx kv.Tx) error {
+			inactive := influxdb.Status("inactive")
+			_, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Status: &inactive})
+			return err
+		}},
+		{name: "delete", fn: func(tx kv.Tx) error {
+			return store.DeleteUser(ctx, tx, 1)
+		}},
+	}
+
+	for _, m := range mutations {
+		if err := store.Update(ctx, m.fn); err != nil {
+			t.Fatal(err)
+		}
+
+		gen := generation()
+		if gen <= last {
+			t.Fatalf("expected %s to advance the generation past %d, got: %d", m.name, last, gen)
+		}
+
+		// reads leave it alone
+		err := store.View(ctx, func(tx kv.Tx) error {
+			_, err := store.ListUsers(ctx, tx)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again := generation(); again != gen {
+			t.Fatalf("expected the generation to be stable across reads: \n%d\n%d", gen, again)
+		}
+		last = gen
+	}
+}
@@ -0,0 +1,314 @@
+package tenant
+
+import (
+	"context"
+	"sync"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// UserHook is called after a user has been created, updated or deleted, inside
+// the transaction that made the change. Returning an error fails the mutation.
+type UserHook func(ctx context.Context, tx kv.Tx, action UserAuditAction, u *influxdb.User) error
+
+// ProjectionUpdater maintains a read model derived from the users. It is
+// called inside the transaction of every user mutation, so the projection
+// commits or rolls back with it. Returning an error fails the mutation.
+type ProjectionUpdater func(tx kv.Tx, e UserEvent) error
+
+// AsyncUserHook is called from the hook worker pool after the transaction that
+// created, updated or deleted a user has committed, so a slow hook doesn't
+// hold up writes. Delivery order is only kept with a single worker.
+type AsyncUserHook func(ctx context.Context, action UserAuditAction, u *influxdb.User)
+
+// HookBackpressure decides what a writer does when the async hook queue is
+// full.
+type HookBackpressure int
+
+const (
+	// HookBlock waits for room in the queue, slowing the writer down.
+	HookBlock HookBackpressure = iota
+	// HookDropOldest discards the oldest queued mutation to make room.
+	HookDropOldest
+)
+
+// RegisterUserHook adds a hook run after every user mutation. It is safe to
+// call while the store is in use.
+func (s *Store) RegisterUserHook(h UserHook) {
+	s.shared.hooksMu.Lock()
+	defer s.shared.hooksMu.Unlock()
+	s.shared.hooks = append(s.shared.hooks, h)
+}
+
+// RegisterProjection adds a projection updated with every user mutation. It
+// is safe to call while the store is in use.
+func (s *Store) RegisterProjection(p ProjectionUpdater) {
+	s.shared.hooksMu.Lock()
+	defer s.shared.hooksMu.Unlock()
+	s.shared.projections = append(s.shared.projections, p)
+}
+
+// RegisterAsyncUserHook adds a hook delivered through the worker pool after
+// every committed user mutation, starting the pool on first use. Mutations
+// made in transactions not opened through Store.Update are delivered as they
+// are made, as their commit can't be observed. It is safe to call while the
+// store is in use.
+func (s *Store) RegisterAsyncUserHook(h AsyncUserHook) {
+	s.shared.hooksMu.Lock()
+	defer s.shared.hooksMu.Unlock()
+	s.shared.asyncHooks = append(s.shared.asyncHooks, h)
+	if s.shared.pool == nil {
+		s.shared.pool = newHookPool(s, s.poolWorkers, s.poolQueue, s.poolPolicy)
+	}
+}
+
+// Close stops the async hook pool once the queued mutations have been
+// delivered. Mutations made after Close are not delivered to async hooks.
+func (s *Store) Close() error {
+	if p := s.asyncHookPool(); p != nil {
+		p.close()
+	}
+	return nil
+}
+
+func (s *Store) userHooks() []UserHook {
+	s.shared.hooksMu.RLock()
+	defer s.shared.hooksMu.RUnlock()
+	return s.shared.hooks
+}
+
+func (s *Store) userProjections() []ProjectionUpdater {
+	s.shared.hooksMu.RLock()
+	defer s.shared.hooksMu.RUnlock()
+	return s.shared.projections
+}
+
+func (s *Store) userAsyncHooks() []AsyncUserHook {
+	s.shared.hooksMu.RLock()
+	defer s.shared.hooksMu.RUnlock()
+	return s.shared.asyncHooks
+}
+
+func (s *Store) asyncHookPool() *hookPool {
+	s.shared.hooksMu.RLock()
+	defer s.shared.hooksMu.RUnlock()
+	return s.shared.pool
+}
+
+// userMutated records the mutation in the audit log, runs the registered
+// hooks and updates the projections. old is the user before an update,
+// updates record how it changed. The event is published and the async hooks
+// are called once the transaction commits, or right away when it wasn't
+// opened through Store.Update.
+func (s *Store) userMutated(ctx context.Context, tx kv.Tx, action UserAuditAction, old, u *influxdb.User) error {
+	var changes []FieldChange
+	if action == UserAuditUpdate {
+		changes = DiffUsers(old, u)
+	}
+
+	auditKey, err := s.writeUserAudit(ctx, tx, action, u, changes)
+	if err != nil {
+		return err
+	}
+
+	gen, err := s.bumpUsersGeneration(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.indexUserChange(tx, gen, auditKey); err != nil {
+		return err
+	}
+
+	for _, h := range s.userHooks() {
+		if err := h(ctx, tx, action, u); err != nil {
+			return err
+		}
+	}
+
+	e := UserEvent{
+		Type:   action,
+		UserID: u.ID,
+		Name:   u.Name,
+		At:     s.now().UTC(),
+	}
+
+	for _, p := range s.userProjections() {
+		if err := p(tx, e); err != nil {
+			return err
+		}
+	}
+
+	// copy the user, the caller may keep mutating it after we return
+	he := hookEvent{action: action, user: copyUser(u), event: e, gen: gen}
+	if pending, ok := tx.Context().Value(pendingUserEventsKey{}).(*pendingUserEvents); ok {
+		pending.events = append(pending.events, he)
+		return nil
+	}
+
+	return s.userEventsCommitted(ctx, []hookEvent{he})
+}
+
+func copyUser(u *influxdb.User) *influxdb.User {
+	c := *u
+	if u.Labels != nil {
+		c.Labels = make(map[string]string, len(u.Labels))
+		for k, v := range u.Labels {
+			c.Labels[k] = v
+		}
+	}
+	if u.Flags != nil {
+		c.Flags = make(map[string]bool, len(u.Flags))
+		for k, v := range u.Flags {
+			c.Flags[k] = v
+		}
+	}
+	return &c
+}
+
+type hookEvent struct {
+	action UserAuditAction
+	user   *influxdb.User
+	event  UserEvent
+	// gen is the users generation the mutation advanced to
+	gen uint64
+}
+
+// commitHook is called synchronously for every committed user mutation,
+// before the async hooks.
+type commitHook func(e hookEvent)
+
+func (s *Store) registerCommitHook(h commitHook) {
+	s.shared.hooksMu.Lock()
+	defer s.shared.hooksMu.Unlock()
+	s.shared.commitHooks = append(s.shared.commitHooks, h)
+}
+
+func (s *Store) userCommitHooks() []commitHook {
+	s.shared.hooksMu.RLock()
+	defer s.shared.hooksMu.RUnlock()
+	return s.shared.commitHooks
+}
+
+// pendingUserEvents collects the mutations of a transaction opened through
+// Store.Update until it commits.
+type pendingUserEvents struct {
+	events []hookEvent
+}
+
+type pendingUserEventsKey struct{}
+
+// userEventsCommitted runs the commit hooks for committed mutations, publishes
+// their events and hands them to the async hooks. Every event is published
+// even if one fails, the first error is returned.
+func (s *Store) userEventsCommitted(ctx context.Context, events []hookEvent) error {
+	hooks := s.userCommitHooks()
+
+	var perr error
+	for _, e := range events {
+		for _, h := range hooks {
+			h(e)
+		}
+		if err := s.publishUserEvent(ctx, e.event); err != nil && perr == nil {
+			perr = err
+		}
+	}
+
+	s.dispatchUserEvents(events)
+	return perr
+}
+
+func (s *Store) dispatchUserEvents(events []hookEvent) {
+	p := s.asyncHookPool()
+	if p == nil {
+		return
+	}
+
+	for _, e := range events {
+		p.send(e)
+	}
+}
+
+// hookPool delivers user mutations to the async hooks from a fixed set of
+// workers reading a bounded queue.
+type hookPool struct {
+	store  *Store
+	policy HookBackpressure
+	queue  chan hookEvent
+	wg     sync.WaitGroup
+
+	// mu keeps sends from racing the queue being closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newHookPool(s *Store, workers, queue int, policy HookBackpressure) *hookPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 1 {
+		queue = 1
+	}
+
+	p := &hookPool{
+		store:  s,
+		policy: policy,
+		queue:  make(chan hookEvent, queue),
+	}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *hookPool) work() {
+	defer p.wg.Done()
+	for e := range p.queue {
+		for _, h := range p.store.userAsyncHooks() {
+			h(context.Background(), e.action, e.user)
+		}
+	}
+}
+
+func (p *hookPool) send(e hookEvent) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return
+	}
+
+	if p.policy == HookBlock {
+		p.queue <- e
+		return
+	}
+
+	for {
+		select {
+		case p.queue <- e:
+			return
+		default:
+		}
+
+		select {
+		case <-p.queue:
+		default:
+		}
+	}
+}
+
+// close stops accepting mutations and waits for the queued ones to be
+// delivered.
+func (p *hookPool) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
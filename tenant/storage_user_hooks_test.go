@@ -0,0 +1,250 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestUserHooks(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []tenant.UserAuditAction
+	store.RegisterUserHook(func(ctx context.Context, tx kv.Tx, action tenant.UserAuditAction, u *influxdb.User) error {
+		actions = append(actions, action)
+		if u.Name == "rejected" {
+			return errors.New("rejected by hook")
+		}
+		return nil
+	})
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+
+		inactive := influxdb.Status("inactive")
+		if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Status: &inactive}); err != nil {
+			return err
+		}
+
+		if err := store.DeleteUser(ctx, tx, 1); err != nil {
+			return err
+		}
+
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "rejected", Status: "active"}); err == nil {
+			t.Fatal("expected hook error to fail the mutation")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []tenant.UserAuditAction{tenant.UserAuditCreate, tenant.UserAuditUpdate, tenant.UserAuditDelete, tenant.UserAuditCreate}
+	if fmt.Sprint(actions) != fmt.Sprint(expected) {
+		t.Fatalf("expected hooks for each mutation: \n%v\n%v", actions, expected)
+	}
+}
+
+func TestUserHooksConcurrentRegistration(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int64
+	hook := func(ctx context.Context, tx kv.Tx, action tenant.UserAuditAction, u *influxdb.User) error {
+		atomic.AddInt64(&calls, 1)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			store.RegisterUserHook(hook)
+		}()
+		go func(i int) {
+			defer wg.Done()
+			err := store.Update(ctx, func(tx kv.Tx) error {
+				return store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"})
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		users, err := store.ListUsers(ctx, tx, influxdb.FindOptions{Limit: 100})
+		if err != nil {
+			return err
+		}
+		if len(users) != 50 {
+			t.Fatalf("expected 50 users got: %d", len(users))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := atomic.LoadInt64(&calls)
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 100, Name: "user100", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := atomic.LoadInt64(&calls) - before; got != 50 {
+		t.Fatalf("expected every registered hook to run once got: %d", got)
+	}
+}
+
+func TestAsyncUserHooks(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivered := make(chan string, 10)
+	store.RegisterAsyncUserHook(func(ctx context.Context, action tenant.UserAuditAction, u *influxdb.User) {
+		delivered <- fmt.Sprintf("%s %s", action, u.Name)
+	})
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errRollback := errors.New("rollback")
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "active"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("expected rolled back update, got: %v", err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.DeleteUser(ctx, tx, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(delivered)
+
+	var got []string
+	for d := range delivered {
+		got = append(got, d)
+	}
+
+	expected := []string{"create user1", "delete user1"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected only committed mutations to be delivered: \n%v\n%v", got, expected)
+	}
+}
+
+func TestAsyncUserHooksCloseDrains(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithAsyncHookPool(1, 10, tenant.HookBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gate := make(chan struct{})
+	var calls int64
+	store.RegisterAsyncUserHook(func(ctx context.Context, action tenant.UserAuditAction, u *influxdb.User) {
+		<-gate
+		atomic.AddInt64(&calls, 1)
+	})
+
+	for i := 1; i <= 5; i++ {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		store.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("expected Close to wait for pending hooks")
+	default:
+	}
+
+	close(gate)
+	<-closed
+
+	if got := atomic.LoadInt64(&calls); got != 5 {
+		t.Fatalf("expected Close to drain every pending hook got: %d", got)
+	}
+}
+
+func TestAsyncUserHooksDropOldest(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithAsyncHookPool(1, 1, tenant.HookDropOldest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gate := make(chan struct{})
+	var mu sync.Mutex
+	var got []string
+	store.RegisterAsyncUserHook(func(ctx context.Context, action tenant.UserAuditAction, u *influxdb.User) {
+		<-gate
+		mu.Lock()
+		got = append(got, u.Name)
+		mu.Unlock()
+	})
+
+	// every write returns straight away even though the hook is stuck
+	for i := 1; i <= 5; i++ {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(gate)
+	store.Close()
+
+	if len(got) == 0 || len(got) > 2 || got[len(got)-1] != "user5" {
+		t.Fatalf("expected older mutations to be dropped in favour of user5 got: %v", got)
+	}
+}
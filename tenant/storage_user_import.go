@@ -0,0 +1,54 @@
+package tenant
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/influxdata/influxdb/kv"
+)
+
+// ImportOpts configures ImportUsers.
+type ImportOpts struct {
+	// SkipUniquenessCheck creates users without first probing the name index
+	// for their name. The index is still written, but a duplicate name in the
+	// input or one already stored silently repoints the name at the imported
+	// user and leaves the other user unreachable by name. Only set it for
+	// data known to have unique names that don't clash with stored users.
+	SkipUniquenessCheck bool
+}
+
+// ImportUsers creates the users read from r, one encoded user per line as
+// written by ExportUsers. It returns the number of users created.
+func (s *Store) ImportUsers(ctx context.Context, tx kv.Tx, r io.Reader, opts ImportOpts) (int, error) {
+	br := bufio.NewReader(r)
+
+	count := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return count, err
+		}
+
+		if v := bytes.TrimSpace(line); len(v) > 0 {
+			u, uerr := s.codec.Unmarshal(v)
+			if uerr != nil {
+				return count, ErrUnprocessableUser(uerr)
+			}
+
+			if cerr := s.createUser(ctx, tx, u, !opts.SkipUniquenessCheck); cerr != nil {
+				return count, cerr
+			}
+			count++
+		}
+
+		if err == io.EOF {
+			return count, nil
+		}
+	}
+}
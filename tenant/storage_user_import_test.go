@@ -0,0 +1,126 @@
+package tenant_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+// exportedUsers returns n users in the export format.
+func exportedUsers(tb testing.TB, n int) []byte {
+	tb.Helper()
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= n; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		_, err := store.ExportUsers(ctx, tx, &buf, tenant.UserFilter{})
+		return err
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestImportUsers(t *testing.T) {
+	data := exportedUsers(t, 20)
+
+	for _, opts := range []tenant.ImportOpts{{}, {SkipUniquenessCheck: true}} {
+		t.Run(fmt.Sprintf("skip uniqueness %v", opts.SkipUniquenessCheck), func(t *testing.T) {
+			ctx := context.Background()
+			store, err := tenant.NewStore(inmem.NewKVStore())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				n, err := store.ImportUsers(ctx, tx, bytes.NewReader(data), opts)
+				if err != nil {
+					return err
+				}
+				if n != 20 {
+					t.Fatalf("expected 20 users imported got: %d", n)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.View(ctx, func(tx kv.Tx) error {
+				for i := 1; i <= 20; i++ {
+					expected := &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}
+					u, err := store.GetUserByName(ctx, tx, expected.Name)
+					if err != nil {
+						return err
+					}
+					if !reflect.DeepEqual(u, expected) {
+						t.Fatalf("expected identical users: \n%+v\n%+v", u, expected)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the check still guards against clashes unless it is skipped
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				_, err := store.ImportUsers(ctx, tx, bytes.NewReader([]byte(`{"id":"0000000000000063","name":"user1","status":"active"}`)), opts)
+				return err
+			})
+			if opts.SkipUniquenessCheck != (err == nil) {
+				t.Fatalf("expected clash to fail only when checked, got: %v", err)
+			}
+		})
+	}
+}
+
+func benchmarkImportUsers(b *testing.B, opts tenant.ImportOpts) {
+	data := exportedUsers(b, 1000)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		store, err := tenant.NewStore(inmem.NewKVStore())
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			_, err := store.ImportUsers(ctx, tx, bytes.NewReader(data), opts)
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkImportUsers(b *testing.B) {
+	benchmarkImportUsers(b, tenant.ImportOpts{})
+}
+
+func BenchmarkImportUsersSkipUniquenessCheck(b *testing.B) {
+	benchmarkImportUsers(b, tenant.ImportOpts{SkipUniquenessCheck: true})
+}
@@ -0,0 +1,296 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+var (
+	userFieldIndex = []byte("userfieldindexv1")
+)
+
+// IndexConfig declares which user fields get a secondary index. Values of
+// Unique fields may only be held by one user, NonUnique fields can be shared
+// and are only used for lookups. The name is always uniquely indexed in the
+// name index, listing it under Unique is allowed but changes nothing.
+//
+// The indexable fields are name, email, oauthID, status, labels and flags. A
+// label is indexed as key=value and a flag by its name while it is enabled.
+type IndexConfig struct {
+	Unique    []string
+	NonUnique []string
+}
+
+// defaultIndexConfig indexes the name uniquely and labels and flags for
+// lookups.
+var defaultIndexConfig = IndexConfig{
+	Unique:    []string{"name"},
+	NonUnique: []string{"labels", "flags"},
+}
+
+// userIndexFields reads the values of each indexable field. Empty values are
+// not indexed.
+var userIndexFields = map[string]func(u *influxdb.User) []string{
+	"name":    func(u *influxdb.User) []string { return []string{u.Name} },
+	"email":   func(u *influxdb.User) []string { return []string{u.Email} },
+	"oauthID": func(u *influxdb.User) []string { return []string{u.OAuthID} },
+	"status":  func(u *influxdb.User) []string { return []string{string(u.Status)} },
+	"labels": func(u *influxdb.User) []string {
+		vs := make([]string, 0, len(u.Labels))
+		for k, v := range u.Labels {
+			vs = append(vs, k+"="+v)
+		}
+		return vs
+	},
+	"flags": func(u *influxdb.User) []string {
+		vs := make([]string, 0, len(u.Flags))
+		for k, enabled := range u.Flags {
+			if enabled {
+				vs = append(vs, k)
+			}
+		}
+		return vs
+	},
+}
+
+// fieldIndex is a configured secondary index.
+type fieldIndex struct {
+	field  string
+	unique bool
+	values func(u *influxdb.User) []string
+}
+
+// fieldIndexes resolves c into the indexes kept in the field index bucket.
+func (c IndexConfig) fieldIndexes() ([]fieldIndex, error) {
+	var idxs []fieldIndex
+	seen := map[string]struct{}{}
+	add := func(fields []string, unique bool) error {
+		for _, f := range fields {
+			values, ok := userIndexFields[f]
+			if !ok {
+				return UnknownUserIndexFieldError(f)
+			}
+			if _, ok := seen[f]; ok {
+				return UnknownUserIndexFieldError(f)
+			}
+			seen[f] = struct{}{}
+
+			// the name index has its own bucket
+			if f == "name" {
+				if !unique {
+					return UnknownUserIndexFieldError(f)
+				}
+				continue
+			}
+			idxs = append(idxs, fieldIndex{field: f, unique: unique, values: values})
+		}
+		return nil
+	}
+
+	if err := add(c.Unique, true); err != nil {
+		return nil, err
+	}
+	if err := add(c.NonUnique, false); err != nil {
+		return nil, err
+	}
+
+	return idxs, nil
+}
+
+// fieldValues returns the non empty values idx indexes for u.
+func (idx fieldIndex) fieldValues(u *influxdb.User) []string {
+	if u == nil {
+		return nil
+	}
+
+	var vs []string
+	for _, v := range idx.values(u) {
+		if v != "" {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// userFieldPrefix is the prefix shared by the entries of a field value. Unique
+// entries are keyed by it alone, non unique entries append the user id.
+func userFieldPrefix(field, value string) []byte {
+	return []byte(field + "\x00" + value + "\x00")
+}
+
+func (idx fieldIndex) key(value string, encodedID []byte) []byte {
+	k := userFieldPrefix(idx.field, value)
+	if idx.unique {
+		return k
+	}
+	return append(k, encodedID...)
+}
+
+// validateUserFields rejects field values that would make index keys
+// ambiguous.
+func (s *Store) validateUserFields(u *influxdb.User) error {
+	for k, v := range u.Labels {
+		if k == "" || strings.ContainsAny(k, "=\x00") || strings.Contains(v, "\x00") {
+			return InvalidUserLabelError(k)
+		}
+	}
+
+	for _, idx := range s.fieldIndexes {
+		for _, v := range idx.fieldValues(u) {
+			if strings.Contains(v, "\x00") {
+				return InvalidUserIndexValueError(idx.field)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkUserFields makes sure none of the unique values u is moving to is held
+// by another user.
+func (s *Store) checkUserFields(ctx context.Context, tx kv.Tx, encodedID []byte, u *influxdb.User) error {
+	b, err := tx.Bucket(s.fieldBucket)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range s.fieldIndexes {
+		if !idx.unique {
+			continue
+		}
+
+		for _, v := range idx.fieldValues(u) {
+			id, err := b.Get(idx.key(v, encodedID))
+			if kv.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return ErrInternalServiceError(err)
+			}
+			if !bytes.Equal(id, encodedID) {
+				return UserFieldConflictError(idx.field, v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// indexUserFields moves the field index and expiry index entries of a user
+// from old to u. A nil old indexes a new user and a nil u removes the user's
+// entries.
+func (s *Store) indexUserFields(ctx context.Context, tx kv.Tx, encodedID []byte, old, u *influxdb.User) error {
+	if err := s.indexUserExpiry(tx, encodedID, old, u); err != nil {
+		return err
+	}
+
+	if err := s.indexUserCreated(tx, encodedID, old, u); err != nil {
+		return err
+	}
+
+	if len(s.fieldIndexes) == 0 {
+		return nil
+	}
+
+	b, err := tx.Bucket(s.fieldBucket)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range s.fieldIndexes {
+		for _, v := range idx.fieldValues(old) {
+			if err := b.Delete(idx.key(v, encodedID)); err != nil {
+				return ErrWriteFailed(err)
+			}
+		}
+
+		for _, v := range idx.fieldValues(u) {
+			if err := b.Put(idx.key(v, encodedID), encodedID); err != nil {
+				return ErrWriteFailed(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// FindUsersByField lists the users whose field holds value in id order. The
+// field must be configured in the store's IndexConfig.
+func (s *Store) FindUsersByField(ctx context.Context, tx kv.Tx, field, value string, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	if field == "name" {
+		u, err := s.GetUserByName(ctx, tx, value)
+		if err == ErrUserNotFound {
+			return []*influxdb.User{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*influxdb.User{u}, nil
+	}
+
+	var indexed bool
+	for _, idx := range s.fieldIndexes {
+		if idx.field == field {
+			indexed = true
+		}
+	}
+	if !indexed {
+		return nil, UnindexedUserFieldError(field)
+	}
+
+	return s.findUsersByFieldPrefix(ctx, tx, userFieldPrefix(field, value), applyFindOptions(opt, s.defaultLimit))
+}
+
+// findUsersByFieldPrefix lists the users of the field index entries starting
+// with prefix, in key order.
+func (s *Store) findUsersByFieldPrefix(ctx context.Context, tx kv.Tx, prefix []byte, o influxdb.FindOptions) ([]*influxdb.User, error) {
+	b, err := tx.Bucket(s.fieldBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(prefix, kv.WithCursorPrefix(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	page, scanned := newPaginator(o), 0
+	us := []*influxdb.User{}
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+
+		scanned++
+		if s.scanExceeded(scanned) {
+			return nil, ErrScanLimitExceeded
+		}
+
+		id, err := s.decodeID(v)
+		if err != nil {
+			return nil, ErrCorruptID(err)
+		}
+
+		u, ok, err := s.getScopedUser(tx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok || !page.take() {
+			continue
+		}
+
+		us = append(us, u)
+
+		if page.full() {
+			break
+		}
+	}
+
+	return us, cursor.Err()
+}
@@ -0,0 +1,137 @@
+package tenant_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestUserIndexConfig(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithIndexConfig(tenant.IndexConfig{
+		Unique:    []string{"name", "email"},
+		NonUnique: []string{"labels"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(users []*influxdb.User) []influxdb.ID {
+		var ids []influxdb.ID
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		users := []*influxdb.User{
+			{ID: 1, Name: "user1", Email: "one@example.com", Status: "active", Labels: map[string]string{"team": "ui"}},
+			{ID: 2, Name: "user2", Email: "two@example.com", Status: "active", Labels: map[string]string{"team": "ui"}},
+			{ID: 3, Name: "user3", Status: "active"},
+			{ID: 4, Name: "user4", Status: "active"},
+		}
+		for _, u := range users {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("unique", func(t *testing.T) {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: 5, Name: "user5", Email: "one@example.com", Status: "active"})
+			if influxdb.ErrorCode(err) != influxdb.EConflict {
+				t.Fatalf("expected taken email to conflict on create, got: %v", err)
+			}
+
+			err = store.PutUserRaw(ctx, tx, 2, []byte(`{"id":"0000000000000002","name":"user2","email":"one@example.com","status":"active"}`))
+			if influxdb.ErrorCode(err) != influxdb.EConflict {
+				t.Fatalf("expected taken email to conflict on replace, got: %v", err)
+			}
+
+			name := "user10"
+			if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name}); err != nil {
+				t.Fatalf("expected a user to keep its own email: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			users, err := store.FindUsersByField(ctx, tx, "email", "one@example.com")
+			if err != nil {
+				return err
+			}
+			if got := ids(users); len(got) != 1 || got[0] != 1 {
+				t.Fatalf("expected user 1 by email got: %v", got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("non unique", func(t *testing.T) {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			_, err := store.UpdateUser(ctx, tx, 2, influxdb.UserUpdate{Labels: map[string]string{"team": "storage"}})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			users, err := store.FindUsersByLabel(ctx, tx, "team", "ui")
+			if err != nil {
+				return err
+			}
+			if got := ids(users); len(got) != 1 || got[0] != 1 {
+				t.Fatalf("expected user 1 left in team ui got: %v", got)
+			}
+
+			if _, err := store.FindUsersByField(ctx, tx, "status", "active"); influxdb.ErrorCode(err) != influxdb.EInvalid {
+				t.Fatalf("expected unindexed field lookup to be invalid, got: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			if err := store.DeleteUser(ctx, tx, 1); err != nil {
+				return err
+			}
+			return store.CreateUser(ctx, tx, &influxdb.User{ID: 6, Name: "user6", Email: "one@example.com", Status: "active"})
+		})
+		if err != nil {
+			t.Fatalf("expected a deleted user's email to be free: %v", err)
+		}
+	})
+}
+
+func TestUserIndexConfigInvalid(t *testing.T) {
+	for _, c := range []tenant.IndexConfig{
+		{Unique: []string{"phone"}},
+		{NonUnique: []string{"name"}},
+		{Unique: []string{"email"}, NonUnique: []string{"email"}},
+	} {
+		if _, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithIndexConfig(c)); influxdb.ErrorCode(err) != influxdb.EInvalid {
+			t.Errorf("expected config %+v to be rejected, got: %v", c, err)
+		}
+	}
+}
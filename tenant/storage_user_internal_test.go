@@ -0,0 +1,177 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+)
+
+func TestUserMarshalFailure(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(fn func(interface{}) ([]byte, error)) { userJSONMarshal = fn }(userJSONMarshal)
+	userJSONMarshal = func(interface{}) ([]byte, error) {
+		return nil, errors.New("unsupported value")
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		err := store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "active"})
+		if influxdb.ErrorCode(err) != influxdb.EUnprocessableEntity {
+			t.Fatalf("expected unprocessable entity on create, got: %v", err)
+		}
+
+		name := "user10"
+		_, err = store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name})
+		if influxdb.ErrorCode(err) != influxdb.EUnprocessableEntity {
+			t.Fatalf("expected unprocessable entity on update, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		idx, err := tx.Bucket(userIndex)
+		if err != nil {
+			return err
+		}
+
+		if _, err := idx.Get([]byte("user2")); !kv.IsNotFound(err) {
+			t.Fatalf("expected no index entry for failed create, got: %v", err)
+		}
+
+		if _, err := idx.Get([]byte("user10")); !kv.IsNotFound(err) {
+			t.Fatalf("expected no index entry for failed rename, got: %v", err)
+		}
+
+		if _, err := idx.Get([]byte("user1")); err != nil {
+			t.Fatalf("expected original index entry to remain: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getUserByNameTwoStep is how GetUserByName used to resolve a name, decoding
+// the index value and handing the id to GetUser.
+func (s *Store) getUserByNameTwoStep(ctx context.Context, tx kv.Tx, n string) (*influxdb.User, error) {
+	b, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := b.Get(s.userIndexKey(n))
+	if err == kv.ErrKeyNotFound {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, ErrInternalServiceError(err)
+	}
+
+	id, err := s.decodeID(uid)
+	if err != nil {
+		return nil, ErrCorruptID(err)
+	}
+	return s.GetUser(ctx, tx, id)
+}
+
+func TestGetUserByNameFastPath(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "storage"}}); err != nil {
+			return err
+		}
+
+		idx, err := tx.Bucket(userIndex)
+		if err != nil {
+			return err
+		}
+		// an entry pointing at no user and one that isn't an id
+		dangling, _ := influxdb.ID(42).Encode()
+		if err := idx.Put([]byte("dangling"), dangling); err != nil {
+			return err
+		}
+		return idx.Put([]byte("corrupt"), []byte("nope"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		for _, n := range []string{"user1", "missing", "dangling", "corrupt"} {
+			want, wantErr := store.getUserByNameTwoStep(ctx, tx, n)
+			got, gotErr := store.GetUserByName(ctx, tx, n)
+			if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(gotErr, wantErr) {
+				t.Fatalf("expected identical results for %q: \n%+v %v\n%+v %v", n, want, wantErr, got, gotErr)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkGetUserByName(b *testing.B) {
+	ctx := context.Background()
+	store, err := NewStore(inmem.NewKVStore())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bb := range []struct {
+		name string
+		get  func(context.Context, kv.Tx, string) (*influxdb.User, error)
+	}{
+		{name: "two step", get: store.getUserByNameTwoStep},
+		{name: "fast path", get: store.GetUserByName},
+	} {
+		get := bb.get
+		b.Run(bb.name, func(b *testing.B) {
+			err := store.View(ctx, func(tx kv.Tx) error {
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := get(ctx, tx, "user1"); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
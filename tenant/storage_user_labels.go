@@ -0,0 +1,54 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// FindUsersByLabel lists the users carrying the label key=value in id order.
+// The labels field must be indexed, which it is by default.
+func (s *Store) FindUsersByLabel(ctx context.Context, tx kv.Tx, key, value string, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	return s.FindUsersByField(ctx, tx, "labels", key+"="+value, opt...)
+}
+
+// FindUsersWithLabelKey lists the users carrying the label key with any value,
+// ordered by the label value and then by id. The labels field must be
+// indexed, which it is by default.
+func (s *Store) FindUsersWithLabelKey(ctx context.Context, tx kv.Tx, key string, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	if key == "" || strings.ContainsAny(key, "=\x00") {
+		return nil, InvalidUserLabelError(key)
+	}
+
+	var indexed bool
+	for _, idx := range s.fieldIndexes {
+		if idx.field == "labels" {
+			indexed = true
+		}
+	}
+	if !indexed {
+		return nil, UnindexedUserFieldError("labels")
+	}
+
+	// label keys can't hold '=', so key= only prefixes the entries of key
+	prefix := []byte("labels\x00" + key + "=")
+	return s.findUsersByFieldPrefix(ctx, tx, prefix, applyFindOptions(opt, s.defaultLimit))
+}
+
+// GetUserWithLabels returns the user id together with its labels, never nil.
+// Labels are stored inline on the user so both come from the one read.
+func (s *Store) GetUserWithLabels(ctx context.Context, tx kv.Tx, id influxdb.ID) (*influxdb.User, map[string]string, error) {
+	u, err := s.GetUser(ctx, tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labels := make(map[string]string, len(u.Labels))
+	for k, v := range u.Labels {
+		labels[k] = v
+	}
+
+	return u, labels, nil
+}
@@ -0,0 +1,260 @@
+package tenant_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestFindUsersByLabel(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findIDs := func(t *testing.T, key, value string) []influxdb.ID {
+		t.Helper()
+		var ids []influxdb.ID
+		err := store.View(ctx, func(tx kv.Tx) error {
+			users, err := store.FindUsersByLabel(ctx, tx, key, value)
+			if err != nil {
+				return err
+			}
+			for _, u := range users {
+				ids = append(ids, u.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		users := []*influxdb.User{
+			{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "storage", "site": "eu"}},
+			{ID: 2, Name: "user2", Status: "active", Labels: map[string]string{"team": "storage"}},
+			{ID: 3, Name: "user3", Status: "active", Labels: map[string]string{"team": "storagex"}},
+			{ID: 4, Name: "user4", Status: "active"},
+		}
+		for _, u := range users {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("added", func(t *testing.T) {
+		if ids := findIDs(t, "team", "storage"); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+			t.Fatalf("expected users 1 and 2 got: %v", ids)
+		}
+		if ids := findIDs(t, "site", "eu"); len(ids) != 1 || ids[0] != 1 {
+			t.Fatalf("expected user 1 got: %v", ids)
+		}
+		if ids := findIDs(t, "team", "ui"); len(ids) != 0 {
+			t.Fatalf("expected no users got: %v", ids)
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			u, err := store.UpdateUser(ctx, tx, 2, influxdb.UserUpdate{Labels: map[string]string{"team": "ui"}})
+			if err != nil {
+				return err
+			}
+			if u.Labels["team"] != "ui" {
+				t.Fatalf("expected updated labels got: %v", u.Labels)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids := findIDs(t, "team", "storage"); len(ids) != 1 || ids[0] != 1 {
+			t.Fatalf("expected old label to be unindexed got: %v", ids)
+		}
+		if ids := findIDs(t, "team", "ui"); len(ids) != 1 || ids[0] != 2 {
+			t.Fatalf("expected new label to be indexed got: %v", ids)
+		}
+	})
+
+	t.Run("untouched", func(t *testing.T) {
+		name := "user10"
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			_, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids := findIDs(t, "site", "eu"); len(ids) != 1 || ids[0] != 1 {
+			t.Fatalf("expected labels to survive a rename got: %v", ids)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			u, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Labels: map[string]string{}})
+			if err != nil {
+				return err
+			}
+			if u.Labels != nil {
+				t.Fatalf("expected labels to be cleared got: %v", u.Labels)
+			}
+			return store.DeleteUser(ctx, tx, 3)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids := findIDs(t, "site", "eu"); len(ids) != 0 {
+			t.Fatalf("expected cleared label to be unindexed got: %v", ids)
+		}
+		if ids := findIDs(t, "team", "storagex"); len(ids) != 0 {
+			t.Fatalf("expected deleted user to be unindexed got: %v", ids)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUser(ctx, tx, &influxdb.User{ID: 5, Name: "user5", Labels: map[string]string{"a=b": "c"}})
+		})
+		if influxdb.ErrorCode(err) != influxdb.EInvalid {
+			t.Fatalf("expected invalid label error got: %v", err)
+		}
+	})
+}
+
+func TestFindUsersWithLabelKey(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		users := []*influxdb.User{
+			{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "ui"}},
+			{ID: 2, Name: "user2", Status: "active", Labels: map[string]string{"team": "storage", "site": "eu"}},
+			{ID: 3, Name: "user3", Status: "active", Labels: map[string]string{"teams": "storage"}},
+			{ID: 4, Name: "user4", Status: "active", Labels: map[string]string{"team": "storage"}},
+			{ID: 5, Name: "user5", Status: "active"},
+		}
+		for _, u := range users {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		key      string
+		opt      []influxdb.FindOptions
+		expected []influxdb.ID
+	}{
+		{name: "any value", key: "team", expected: []influxdb.ID{2, 4, 1}},
+		{name: "paged", key: "team", opt: []influxdb.FindOptions{{Limit: 2, Offset: 1}}, expected: []influxdb.ID{4, 1}},
+		{name: "single holder", key: "site", expected: []influxdb.ID{2}},
+		{name: "no holder", key: "region", expected: []influxdb.ID{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.View(ctx, func(tx kv.Tx) error {
+				us, err := store.FindUsersWithLabelKey(ctx, tx, tt.key, tt.opt...)
+				if err != nil {
+					return err
+				}
+
+				ids := []influxdb.ID{}
+				for _, u := range us {
+					ids = append(ids, u.ID)
+				}
+				if !reflect.DeepEqual(ids, tt.expected) {
+					t.Fatalf("expected users with the label key: \n%+v\n%+v", ids, tt.expected)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.FindUsersWithLabelKey(ctx, tx, "team=ui"); influxdb.ErrorCode(err) != influxdb.EInvalid {
+			t.Fatalf("expected a key holding '=' to be rejected, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetUserWithLabels(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "storage", "region": "eu"}}); err != nil {
+			return err
+		}
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		for _, id := range []influxdb.ID{1, 2} {
+			want, err := store.GetUser(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+
+			u, labels, err := store.GetUserWithLabels(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(u, want) {
+				t.Fatalf("expected the user GetUser returns: \n%+v\n%+v", u, want)
+			}
+
+			expected := map[string]string{}
+			for k, v := range want.Labels {
+				expected[k] = v
+			}
+			if !reflect.DeepEqual(labels, expected) {
+				t.Fatalf("expected the user's labels: \n%+v\n%+v", labels, expected)
+			}
+		}
+
+		if _, _, err := store.GetUserWithLabels(ctx, tx, 3); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected user not found, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
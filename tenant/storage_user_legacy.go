@@ -0,0 +1,85 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/influxdata/influxdb/kv"
+)
+
+// isIndexEntry reports whether a value of the legacy layout is a name index
+// entry, which holds an encoded id, rather than a user blob.
+func (s *Store) isIndexEntry(v []byte) bool {
+	_, err := s.decodeID(v)
+	return err == nil
+}
+
+// MigrateLegacyLayout moves the users stored in the legacy single bucket
+// layout into the store's user bucket and name index. Migrated entries are
+// removed from the legacy bucket so running it again is a no op. It returns
+// the number of users moved.
+func (s *Store) MigrateLegacyLayout(ctx context.Context, tx kv.Tx, legacy []byte) (int, error) {
+	from, err := tx.Bucket(legacy)
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return 0, err
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := from.ForwardCursor(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// collect the entries first so deletes can't invalidate the cursor
+	type entry struct{ k, v []byte }
+	var entries []entry
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		entries = append(entries, entry{
+			k: append([]byte(nil), k...),
+			v: append([]byte(nil), v...),
+		})
+	}
+
+	if err := cursor.Err(); err != nil {
+		cursor.Close()
+		return 0, err
+	}
+	if err := cursor.Close(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		if s.isIndexEntry(e.v) {
+			if err := idx.Put(s.userIndexKey(string(e.k)), e.v); err != nil {
+				return count, ErrWriteFailed(err)
+			}
+		} else {
+			if _, err := s.unmarshalUser(e.v); err != nil {
+				return count, err
+			}
+			if err := b.Put(e.k, e.v); err != nil {
+				return count, ErrWriteFailed(err)
+			}
+			count++
+		}
+
+		if err := from.Delete(e.k); err != nil {
+			return count, ErrWriteFailed(err)
+		}
+	}
+
+	return count, nil
+}
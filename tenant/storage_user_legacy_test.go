@@ -0,0 +1,193 @@
+package tenant_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+var legacyUserBucket = []byte("users")
+
+func seedLegacyUsers(t *testing.T, kvStore kv.Store, users []*influxdb.User) {
+	t.Helper()
+	err := kvStore.Update(context.Background(), func(tx kv.Tx) error {
+		b, err := tx.Bucket(legacyUserBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, u := range users {
+			id, err := u.ID.Encode()
+			if err != nil {
+				return err
+			}
+
+			v, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put(id, v); err != nil {
+				return err
+			}
+			if err := b.Put([]byte(u.Name), id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserLegacyLayout(t *testing.T) {
+	ctx := context.Background()
+	users := []*influxdb.User{
+		{ID: 1, Name: "zed", Status: "active"},
+		{ID: 2, Name: "amy", Status: "active"},
+		{ID: 3, Name: "bob", Status: "inactive"},
+	}
+
+	kvStore := inmem.NewKVStore()
+	seedLegacyUsers(t, kvStore, users)
+
+	assertReads := func(t *testing.T, store *tenant.Store) {
+		t.Helper()
+		err := store.View(ctx, func(tx kv.Tx) error {
+			u, err := store.GetUser(ctx, tx, 3)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(u, users[2]) {
+				t.Fatalf("expected identical user by id: \n%+v\n%+v", u, users[2])
+			}
+
+			u, err = store.GetUserByName(ctx, tx, "amy")
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(u, users[1]) {
+				t.Fatalf("expected identical user by name: \n%+v\n%+v", u, users[1])
+			}
+
+			list, err := store.ListUsers(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(list, users) {
+				t.Fatalf("expected users in id order: \n%+v\n%+v", list, users)
+			}
+
+			list, err = store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name"})
+			if err != nil {
+				return err
+			}
+			expected := []*influxdb.User{users[1], users[2], users[0]}
+			if !reflect.DeepEqual(list, expected) {
+				t.Fatalf("expected users in name order: \n%+v\n%+v", list, expected)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("read compat", func(t *testing.T) {
+		store, err := tenant.NewStore(kvStore, tenant.WithLegacyLayout(legacyUserBucket))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertReads(t, store)
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		store, err := tenant.NewStore(kvStore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			n, err := store.MigrateLegacyLayout(ctx, tx, legacyUserBucket)
+			if err != nil {
+				return err
+			}
+			if n != len(users) {
+				t.Fatalf("expected %d users migrated got: %d", len(users), n)
+			}
+
+			n, err = store.MigrateLegacyLayout(ctx, tx, legacyUserBucket)
+			if err != nil {
+				return err
+			}
+			if n != 0 {
+				t.Fatalf("expected a second migration to be a no op got: %d", n)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertReads(t, store)
+	})
+}
+
+func TestUserLegacyLayoutScans(t *testing.T) {
+	ctx := context.Background()
+	users := []*influxdb.User{
+		{ID: 1, Name: "zed", Status: "active"},
+		{ID: 2, Name: "amy", Status: "active"},
+	}
+
+	kvStore := inmem.NewKVStore()
+	seedLegacyUsers(t, kvStore, users)
+
+	store, err := tenant.NewStore(kvStore, tenant.WithLegacyLayout(legacyUserBucket))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		var export bytes.Buffer
+		n, err := store.ExportUsers(ctx, tx, &export, tenant.UserFilter{})
+		if err != nil {
+			return err
+		}
+		if n != 2 {
+			t.Fatalf("expected only the users exported, got %d: %s", n, export.String())
+		}
+
+		var points bytes.Buffer
+		if n, err = store.WriteUsersLineProtocol(ctx, tx, &points, "users"); err != nil {
+			return err
+		}
+		if n != 2 {
+			t.Fatalf("expected a point per user, got %d: %s", n, points.String())
+		}
+
+		var walked []influxdb.ID
+		_, err = store.WalkUsers(ctx, tx, 0, func(u *influxdb.User) error {
+			walked = append(walked, u.ID)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if expected := []influxdb.ID{1, 2}; !reflect.DeepEqual(walked, expected) {
+			t.Fatalf("expected only the users walked: \n%+v\n%+v", walked, expected)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,156 @@
+package tenant
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+var (
+	userLoginBucket = []byte("userloginv1")
+)
+
+// RecordLogin stores at as the last time the user logged in.
+func (s *Store) RecordLogin(ctx context.Context, tx kv.Tx, id influxdb.ID, at time.Time) error {
+	if _, err := s.getUserBlob(tx, id); err != nil {
+		return err
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.loginBucket)
+	if err != nil {
+		return err
+	}
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(at.UnixNano()))
+	if err := b.Put(encodedID, v); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	return nil
+}
+
+// GetLastLogin returns the last time the user logged in, the zero time if it
+// never has.
+func (s *Store) GetLastLogin(ctx context.Context, tx kv.Tx, id influxdb.ID) (time.Time, error) {
+	if _, err := s.getUserBlob(tx, id); err != nil {
+		return time.Time{}, err
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return time.Time{}, InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.loginBucket)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return s.lastLogin(b, encodedID)
+}
+
+// FindUsersInactiveSince lists in id order the users whose last login is
+// before cutoff, including those who never logged in.
+func (s *Store) FindUsersInactiveSince(ctx context.Context, tx kv.Tx, cutoff time.Time, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	logins, err := tx.Bucket(s.loginBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	page := newPaginator(o)
+	us := []*influxdb.User{}
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		at, err := s.lastLogin(logins, k)
+		if err != nil {
+			return nil, err
+		}
+		if !at.IsZero() && !at.Before(cutoff) {
+			continue
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return nil, err
+		}
+		if u.DeletedAt != nil || !s.inScope(u.Name) {
+			continue
+		}
+
+		if !page.take() {
+			continue
+		}
+
+		us = append(us, u)
+
+		if page.full() {
+			break
+		}
+	}
+
+	return us, cursor.Err()
+}
+
+func (s *Store) lastLogin(b kv.Bucket, encodedID []byte) (time.Time, error) {
+	v, err := b.Get(encodedID)
+	if kv.IsNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, ErrInternalServiceError(err)
+	}
+
+	if len(v) != 8 {
+		return time.Time{}, ErrCorruptUserLogin(fmt.Errorf("expected 8 bytes got %d", len(v)))
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))).UTC(), nil
+}
+
+func (s *Store) deleteLastLogin(ctx context.Context, tx kv.Tx, id influxdb.ID) error {
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.loginBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Delete(encodedID); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestUserLastLogin(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 12, 0, 0, 0, time.UTC) }
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 4; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+
+		// user4 never logs in
+		for id, at := range map[influxdb.ID]time.Time{1: day(1), 2: day(10), 3: day(3)} {
+			if err := store.RecordLogin(ctx, tx, id, at); err != nil {
+				return err
+			}
+		}
+		if err := store.RecordLogin(ctx, tx, 3, day(20)); err != nil {
+			return err
+		}
+
+		if err := store.RecordLogin(ctx, tx, 5, day(1)); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected recording a login for a missing user to fail, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		at, err := store.GetLastLogin(ctx, tx, 3)
+		if err != nil {
+			return err
+		}
+		if !at.Equal(day(20)) {
+			t.Fatalf("expected the latest login to be kept, got: %v", at)
+		}
+
+		at, err = store.GetLastLogin(ctx, tx, 4)
+		if err != nil {
+			return err
+		}
+		if !at.IsZero() {
+			t.Fatalf("expected no login for user4, got: %v", at)
+		}
+
+		us, err := store.FindUsersInactiveSince(ctx, tx, day(5))
+		if err != nil {
+			return err
+		}
+
+		var ids []influxdb.ID
+		for _, u := range us {
+			ids = append(ids, u.ID)
+		}
+		expected := []influxdb.ID{1, 4}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Fatalf("expected inactive users to match: \n%+v\n%+v", expected, ids)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.DeleteUser(ctx, tx, 2); err != nil {
+			return err
+		}
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		at, err := store.GetLastLogin(ctx, tx, 2)
+		if err != nil {
+			return err
+		}
+		if !at.IsZero() {
+			t.Fatalf("expected deleting the user to clear its login, got: %v", at)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,100 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+var (
+	userMetaBucket = []byte("usermetav1")
+)
+
+// UserMeta is optional profile data kept apart from the user so large values
+// such as avatars don't slow down reading users.
+type UserMeta map[string]string
+
+// GetUserMeta returns the metadata of the user, which is empty if none has
+// been set.
+func (s *Store) GetUserMeta(ctx context.Context, tx kv.Tx, id influxdb.ID) (UserMeta, error) {
+	if _, err := s.getUserBlob(tx, id); err != nil {
+		return nil, err
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return nil, InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.metaBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := b.Get(encodedID)
+	if kv.IsNotFound(err) {
+		return UserMeta{}, nil
+	}
+	if err != nil {
+		return nil, ErrInternalServiceError(err)
+	}
+
+	meta := UserMeta{}
+	if err := json.Unmarshal(v, &meta); err != nil {
+		return nil, ErrCorruptUser(err)
+	}
+
+	return meta, nil
+}
+
+// SetUserMeta replaces the metadata of the user, an empty meta removes it.
+func (s *Store) SetUserMeta(ctx context.Context, tx kv.Tx, id influxdb.ID, meta UserMeta) error {
+	if _, err := s.getUserBlob(tx, id); err != nil {
+		return err
+	}
+
+	if len(meta) == 0 {
+		return s.deleteUserMeta(ctx, tx, id)
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	v, err := json.Marshal(meta)
+	if err != nil {
+		return ErrUnprocessableUser(err)
+	}
+
+	b, err := tx.Bucket(s.metaBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Put(encodedID, v); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	return nil
+}
+
+func (s *Store) deleteUserMeta(ctx context.Context, tx kv.Tx, id influxdb.ID) error {
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.metaBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Delete(encodedID); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package tenant_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+// countingTx counts the times each bucket is opened.
+type countingTx struct {
+	kv.Tx
+	opened map[string]int
+}
+
+func (tx *countingTx) Bucket(b []byte) (kv.Bucket, error) {
+	tx.opened[string(b)]++
+	return tx.Tx.Bucket(b)
+}
+
+func TestUserMeta(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := tenant.UserMeta{"avatar": "aGVsbG8=", "theme": "dark"}
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+
+		got, err := store.GetUserMeta(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if len(got) != 0 {
+			t.Fatalf("expected no meta before it is set got: %v", got)
+		}
+
+		if err := store.SetUserMeta(ctx, tx, 2, meta); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected meta of a missing user to be rejected, got: %v", err)
+		}
+
+		return store.SetUserMeta(ctx, tx, 1, meta)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		got, err := store.GetUserMeta(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(got, meta) {
+			t.Fatalf("expected identical meta: \n%v\n%v", got, meta)
+		}
+
+		counting := &countingTx{Tx: tx, opened: map[string]int{}}
+		users, err := store.ListUsers(ctx, counting)
+		if err != nil {
+			return err
+		}
+		if len(users) != 1 {
+			t.Fatalf("expected 1 user got: %d", len(users))
+		}
+		if n := counting.opened["usermetav1"]; n != 0 {
+			t.Fatalf("expected listing users to leave meta unread, opened %d times", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.DeleteUser(ctx, tx, 1); err != nil {
+			return err
+		}
+
+		// a user recreated with the same id starts without meta
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+
+		got, err := store.GetUserMeta(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if len(got) != 0 {
+			t.Fatalf("expected meta to be deleted with the user got: %v", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// MoveUser moves a user from the buckets of s to those of dst, e.g. to promote
+// it from a staging namespace to production. Both stores must share the kv
+// store tx belongs to. The id and name must be free in dst, checked before
+// anything is written. The password, metadata and last login move along with
+// the user. A store preventing the deletion of its last user won't give it up.
+func (s *Store) MoveUser(ctx context.Context, tx kv.Tx, dst *Store, id influxdb.ID) error {
+	u, err := s.GetUser(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if u.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	// the blob itself, dst's scope would hide a user outside it
+	if _, err := dst.getUserBlob(tx, id); err == nil {
+		return UserFieldConflictError("id", id.String())
+	} else if err != ErrUserNotFound {
+		return err
+	}
+
+	if err := s.checkLastUser(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := dst.uniqueUserName(ctx, tx, u.Name); err != nil {
+		return err
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return err
+	}
+
+	if err := idx.Delete(s.userIndexKey(u.Name)); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Delete(encodedID); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	// free the field index entries before dst claims them again
+	if err := s.indexUserFields(ctx, tx, encodedID, u, nil); err != nil {
+		return err
+	}
+
+	if err := s.userMutated(ctx, tx, UserAuditDelete, nil, u); err != nil {
+		return err
+	}
+
+	if err := s.moveUserRecords(tx, dst, encodedID); err != nil {
+		return err
+	}
+
+	return dst.createUser(ctx, tx, u, false)
+}
+
+// moveUserRecords moves the password, metadata and last login stored under
+// encodedID from the buckets of s to those of dst.
+func (s *Store) moveUserRecords(tx kv.Tx, dst *Store, encodedID []byte) error {
+	for _, m := range []struct {
+		from, to []byte
+	}{
+		{from: s.passwordBucket, to: dst.passwordBucket},
+		{from: s.metaBucket, to: dst.metaBucket},
+		{from: s.loginBucket, to: dst.loginBucket},
+	} {
+		if bytes.Equal(m.from, m.to) {
+			continue
+		}
+
+		from, err := tx.Bucket(m.from)
+		if err != nil {
+			return err
+		}
+
+		v, err := from.Get(encodedID)
+		if kv.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return ErrInternalServiceError(err)
+		}
+
+		to, err := tx.Bucket(m.to)
+		if err != nil {
+			return err
+		}
+
+		if err := to.Put(encodedID, v); err != nil {
+			return ErrWriteFailed(err)
+		}
+		if err := from.Delete(encodedID); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package tenant_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestMoveUser(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	staging, err := tenant.NewStore(kvStore, tenant.WithUserBuckets([]byte("stagingusersv1"), []byte("stagingindexv1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &influxdb.User{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "storage"}}
+	err = staging.Update(ctx, func(tx kv.Tx) error {
+		if err := staging.CreateUser(ctx, tx, user); err != nil {
+			return err
+		}
+		if err := staging.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "taken", Status: "active"}); err != nil {
+			return err
+		}
+		if err := staging.SetPassword(ctx, tx, 1, "password1"); err != nil {
+			return err
+		}
+		return prod.CreateUser(ctx, tx, &influxdb.User{ID: 3, Name: "taken", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = staging.Update(ctx, func(tx kv.Tx) error {
+		return staging.MoveUser(ctx, tx, prod, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = staging.View(ctx, func(tx kv.Tx) error {
+		if _, err := staging.GetUser(ctx, tx, 1); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected user to be gone from the source by id, got: %v", err)
+		}
+		if _, err := staging.GetUserByName(ctx, tx, "user1"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected user to be gone from the source by name, got: %v", err)
+		}
+
+		u, err := prod.GetUserByName(ctx, tx, "user1")
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(u, user) {
+			t.Fatalf("expected moved user to be indexed in the destination: \n%+v\n%+v", u, user)
+		}
+
+		us, err := prod.FindUsersByLabel(ctx, tx, "team", "storage")
+		if err != nil {
+			return err
+		}
+		if len(us) != 1 || us[0].ID != 1 {
+			t.Fatalf("expected moved user to be found by label in the destination, got: %+v", us)
+		}
+
+		if _, err := prod.GetPassword(ctx, tx, 1); err != nil {
+			t.Fatalf("expected the password to move with the user: %v", err)
+		}
+		if _, err := staging.GetPassword(ctx, tx, 1); err != tenant.ErrPasswordNotFound {
+			t.Fatalf("expected the password to be gone from the source, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = staging.Update(ctx, func(tx kv.Tx) error {
+		return staging.MoveUser(ctx, tx, prod, 2)
+	})
+	if influxdb.ErrorCode(err) != influxdb.EConflict {
+		t.Fatalf("expected moving onto a taken name to conflict, got: %v", err)
+	}
+
+	err = staging.View(ctx, func(tx kv.Tx) error {
+		_, err := staging.GetUserByName(ctx, tx, "taken")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected the conflicting user to stay in the source: %v", err)
+	}
+}
+
+func TestMoveUserGuards(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	staging, err := tenant.NewStore(kvStore, tenant.WithUserBuckets([]byte("stagingusersv1"), []byte("stagingindexv1")), tenant.WithPreventLastUserDeletion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopedProd := prod.ScopedByPrefix("team-a/")
+
+	err = staging.Update(ctx, func(tx kv.Tx) error {
+		for _, u := range []*influxdb.User{
+			{ID: 1, Name: "team-a/alice", Status: "active"},
+			{ID: 2, Name: "team-a/carol", Status: "active"},
+		} {
+			if err := staging.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		// the same id outside the scope of the destination
+		return prod.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "team-b/bob", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = staging.Update(ctx, func(tx kv.Tx) error {
+		return staging.MoveUser(ctx, tx, scopedProd, 1)
+	})
+	if influxdb.ErrorCode(err) != influxdb.EConflict {
+		t.Fatalf("expected an id hidden by the destination's scope to conflict, got: %v", err)
+	}
+
+	err = staging.Update(ctx, func(tx kv.Tx) error {
+		return staging.MoveUser(ctx, tx, prod, 2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := tenant.NewStore(kvStore, tenant.WithUserBuckets([]byte("archiveusersv1"), []byte("archiveindexv1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1 is all staging has left
+	err = staging.Update(ctx, func(tx kv.Tx) error {
+		return staging.MoveUser(ctx, tx, archive, 1)
+	})
+	if err != tenant.ErrCannotDeleteLastUser {
+		t.Fatalf("expected moving the last user out to be blocked, got: %v", err)
+	}
+
+	err = staging.View(ctx, func(tx kv.Tx) error {
+		if _, err := staging.GetUser(ctx, tx, 1); err != nil {
+			t.Fatalf("expected the last user to stay in the source: %v", err)
+		}
+		if _, err := archive.GetUser(ctx, tx, 1); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected the last user not to be moved, got: %v", err)
+		}
+		u, err := prod.GetUser(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if u.Name != "team-b/bob" {
+			t.Fatalf("expected the destination's user to be left alone, got: %+v", u)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// OrphanReport lists the ids of auxiliary user records whose user no longer
+// exists, per bucket.
+type OrphanReport struct {
+	Passwords []influxdb.ID
+	Meta      []influxdb.ID
+	Logins    []influxdb.ID
+}
+
+// Empty reports whether no orphans were found.
+func (r OrphanReport) Empty() bool {
+	return len(r.Passwords) == 0 && len(r.Meta) == 0 && len(r.Logins) == 0
+}
+
+// FindOrphanedUserData scans the password, metadata and login buckets for
+// entries left behind by users that no longer exist, as a crash between
+// writes can leave them. Only the buckets kept alongside the store's own user
+// table are scanned, stores given WithUserBuckets never see each other's.
+func (s *Store) FindOrphanedUserData(ctx context.Context, tx kv.Tx) (OrphanReport, error) {
+	var r OrphanReport
+	var err error
+
+	if r.Passwords, err = s.findOrphans(ctx, tx, s.passwordBucket); err != nil {
+		return OrphanReport{}, err
+	}
+	if r.Meta, err = s.findOrphans(ctx, tx, s.metaBucket); err != nil {
+		return OrphanReport{}, err
+	}
+	if r.Logins, err = s.findOrphans(ctx, tx, s.loginBucket); err != nil {
+		return OrphanReport{}, err
+	}
+
+	return r, nil
+}
+
+// PurgeOrphanedUserData deletes the entries FindOrphanedUserData finds and
+// returns what it deleted.
+func (s *Store) PurgeOrphanedUserData(ctx context.Context, tx kv.Tx) (OrphanReport, error) {
+	r, err := s.FindOrphanedUserData(ctx, tx)
+	if err != nil {
+		return OrphanReport{}, err
+	}
+
+	for _, o := range []struct {
+		bucket []byte
+		ids    []influxdb.ID
+	}{
+		{bucket: s.passwordBucket, ids: r.Passwords},
+		{bucket: s.metaBucket, ids: r.Meta},
+		{bucket: s.loginBucket, ids: r.Logins},
+	} {
+		b, err := tx.Bucket(o.bucket)
+		if err != nil {
+			return OrphanReport{}, err
+		}
+
+		for _, id := range o.ids {
+			encodedID, err := s.encodeID(id)
+			if err != nil {
+				return OrphanReport{}, InvalidUserIDError(err)
+			}
+			if err := b.Delete(encodedID); err != nil {
+				return OrphanReport{}, ErrWriteFailed(err)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// findOrphans returns the ids keying entries of bucket that have no user.
+func (s *Store) findOrphans(ctx context.Context, tx kv.Tx, bucket []byte) ([]influxdb.ID, error) {
+	users, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := tx.Bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var ids []influxdb.ID
+	for k, _ := cursor.Next(); k != nil; k, _ = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		_, err := users.Get(k)
+		if err == nil {
+			continue
+		}
+		if !kv.IsNotFound(err) {
+			return nil, ErrInternalServiceError(err)
+		}
+
+		id, err := s.decodeID(k)
+		if err != nil {
+			return nil, ErrCorruptID(err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, cursor.Err()
+}
@@ -0,0 +1,193 @@
+package tenant_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestOrphanedUserData(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for _, u := range []*influxdb.User{
+			{ID: 1, Name: "user1", Status: "active"},
+			{ID: 2, Name: "user2", Status: "active"},
+			{ID: 3, Name: "user3", Status: "active"},
+		} {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+			if err := store.SetUserMeta(ctx, tx, u.ID, tenant.UserMeta{"avatar": "a.png"}); err != nil {
+				return err
+			}
+			if err := store.RecordLogin(ctx, tx, u.ID, time.Now()); err != nil {
+				return err
+			}
+		}
+		return store.SetPassword(ctx, tx, 2, "password1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lose users 2 and 3 the way a crash between writes would, by dropping
+	// only their blobs
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		b, err := tx.Bucket([]byte("usersv1"))
+		if err != nil {
+			return err
+		}
+		for _, id := range []influxdb.ID{2, 3} {
+			k, _ := id.Encode()
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := tenant.OrphanReport{
+		Passwords: []influxdb.ID{2},
+		Meta:      []influxdb.ID{2, 3},
+		Logins:    []influxdb.ID{2, 3},
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		r, err := store.FindOrphanedUserData(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(r, expected) {
+			t.Fatalf("expected orphans to be found: \n%+v\n%+v", expected, r)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		r, err := store.PurgeOrphanedUserData(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(r, expected) {
+			t.Fatalf("expected orphans to be purged: \n%+v\n%+v", expected, r)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		r, err := store.FindOrphanedUserData(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if !r.Empty() {
+			t.Fatalf("expected no orphans after a purge, got: %+v", r)
+		}
+
+		meta, err := store.GetUserMeta(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if meta["avatar"] != "a.png" {
+			t.Fatalf("expected the live user's meta to survive the purge, got: %v", meta)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrphanedUserDataKeepsToItsNamespace(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	prod, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging, err := tenant.NewStore(kvStore, tenant.WithUserBuckets([]byte("stagingusersv1"), []byte("staginguserindexv1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a user prod has never heard of
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		if err := staging.CreateUser(ctx, tx, &influxdb.User{ID: 5, Name: "user5", Status: "active"}); err != nil {
+			return err
+		}
+		if err := staging.SetPassword(ctx, tx, 5, "password1"); err != nil {
+			return err
+		}
+		if err := staging.SetUserMeta(ctx, tx, 5, tenant.UserMeta{"avatar": "a.png"}); err != nil {
+			return err
+		}
+		return staging.RecordLogin(ctx, tx, 5, time.Now())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		r, err := prod.PurgeOrphanedUserData(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if !r.Empty() {
+			t.Fatalf("expected prod to find no orphans in staging's buckets, got: %+v", r)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvStore.View(ctx, func(tx kv.Tx) error {
+		if _, err := staging.GetPassword(ctx, tx, 5); err != nil {
+			t.Fatalf("expected the staging password to survive a prod purge: %v", err)
+		}
+		meta, err := staging.GetUserMeta(ctx, tx, 5)
+		if err != nil {
+			return err
+		}
+		if meta["avatar"] != "a.png" {
+			t.Fatalf("expected the staging metadata to survive a prod purge, got: %+v", meta)
+		}
+		if at, err := staging.GetLastLogin(ctx, tx, 5); err != nil || at.IsZero() {
+			t.Fatalf("expected the staging login to survive a prod purge, got: %v %v", at, err)
+		}
+
+		r, err := staging.FindOrphanedUserData(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if !r.Empty() {
+			t.Fatalf("expected staging to have no orphans, got: %+v", r)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,134 @@
+package tenant
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// FindUsersByPrefixes lists in name order the users whose name starts with any
+// of prefixes, e.g. the department prefixes "eng-" and "sales-". A user
+// matching several overlapping prefixes is listed once.
+func (s *Store) FindUsersByPrefixes(ctx context.Context, tx kv.Tx, prefixes []string, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	seeks := s.disjointNamePrefixes(prefixes)
+	matches := func(string) bool { return true }
+	if s.collator != nil && len(seeks) > 0 {
+		// collation keys don't start with the name, the whole index is
+		// scanned in collation order instead
+		ps := seeks
+		matches = func(name string) bool {
+			for _, p := range ps {
+				if strings.HasPrefix(name, p) {
+					return true
+				}
+			}
+			return false
+		}
+		seeks = []string{""}
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	page, scanned := newPaginator(o), 0
+	us := []*influxdb.User{}
+	for _, p := range seeks {
+		var (
+			seek []byte
+			opts []kv.CursorOption
+		)
+		if s.collator == nil {
+			seek = []byte(p)
+			opts = append(opts, kv.WithCursorPrefix(seek))
+		}
+
+		cursor, err := idx.ForwardCursor(seek, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+			scanned++
+			if s.scanExceeded(scanned) {
+				cursor.Close()
+				return nil, ErrScanLimitExceeded
+			}
+
+			if s.legacyLayout && !s.isIndexEntry(v) {
+				continue
+			}
+
+			name := s.userIndexName(k)
+			if !strings.HasPrefix(name, p) || !matches(name) {
+				continue
+			}
+
+			id, err := s.decodeID(v)
+			if err != nil {
+				cursor.Close()
+				return nil, ErrCorruptID(err)
+			}
+
+			u, ok, err := s.getScopedUser(tx, id)
+			if err != nil {
+				cursor.Close()
+				return nil, err
+			}
+
+			if !ok || !page.take() {
+				continue
+			}
+
+			us = append(us, u)
+
+			if page.full() {
+				break
+			}
+		}
+
+		if err := cursor.Err(); err != nil {
+			cursor.Close()
+			return nil, err
+		}
+		if err := cursor.Close(); err != nil {
+			return nil, err
+		}
+
+		if page.full() {
+			break
+		}
+	}
+
+	return us, nil
+}
+
+// disjointNamePrefixes folds prefixes like the name index does, sorts them and
+// drops those covered by a shorter one. Seeking the rest one after the other
+// visits each name once and in order.
+func (s *Store) disjointNamePrefixes(prefixes []string) []string {
+	ps := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		if s.foldNames {
+			p = strings.ToLower(p)
+		}
+		ps = append(ps, p)
+	}
+	sort.Strings(ps)
+
+	// a prefix sorts right after any shorter prefix of its own
+	out := ps[:0]
+	for _, p := range ps {
+		if len(out) > 0 && strings.HasPrefix(p, out[len(out)-1]) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+package tenant_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestFindUsersByPrefixes(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i, n := range []string{"sales-dan", "eng-bob", "hr-eve", "eng-ops-frank", "sales-carol", "eng-alice"} {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 1), Name: n, Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		prefixes []string
+		opt      []influxdb.FindOptions
+		expected []string
+	}{
+		{
+			name:     "non overlapping",
+			prefixes: []string{"sales-", "eng-"},
+			expected: []string{"eng-alice", "eng-bob", "eng-ops-frank", "sales-carol", "sales-dan"},
+		},
+		{
+			name:     "overlapping",
+			prefixes: []string{"eng-ops-", "eng-"},
+			expected: []string{"eng-alice", "eng-bob", "eng-ops-frank"},
+		},
+		{
+			name:     "paged",
+			prefixes: []string{"sales-", "eng-"},
+			opt:      []influxdb.FindOptions{{Limit: 2, Offset: 2}},
+			expected: []string{"eng-ops-frank", "sales-carol"},
+		},
+		{
+			name:     "no match",
+			prefixes: []string{"ops-"},
+			expected: []string{},
+		},
+		{
+			name:     "no prefixes",
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.View(ctx, func(tx kv.Tx) error {
+				us, err := store.FindUsersByPrefixes(ctx, tx, tt.prefixes, tt.opt...)
+				if err != nil {
+					return err
+				}
+
+				names := []string{}
+				for _, u := range us {
+					names = append(names, u.Name)
+				}
+				if !reflect.DeepEqual(names, tt.expected) {
+					t.Fatalf("expected users matching the prefixes: \n%+v\n%+v", tt.expected, names)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package tenant_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/bolt"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+	"go.uber.org/zap/zaptest"
+)
+
+var (
+	userCountBucket = []byte("usercountv1")
+	userCountKey    = []byte("count")
+)
+
+// newBoltStore opens a bolt backed kv store, unlike inmem it discards the
+// writes of a failed transaction.
+func newBoltStore(t *testing.T) (kv.Store, func()) {
+	f, err := ioutil.TempFile("", "influxdata-tenant-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s := bolt.NewKVStore(zaptest.NewLogger(t), f.Name())
+	if err := s.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		s.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func userCount(tx kv.Tx) (uint64, error) {
+	b, err := tx.Bucket(userCountBucket)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := b.Get(userCountKey)
+	if kv.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// countUsers is a projection keeping the number of users in its own bucket.
+func countUsers(tx kv.Tx, e tenant.UserEvent) error {
+	n, err := userCount(tx)
+	if err != nil {
+		return err
+	}
+
+	switch e.Type {
+	case tenant.UserAuditCreate:
+		n++
+	case tenant.UserAuditDelete:
+		n--
+	default:
+		return nil
+	}
+
+	b, err := tx.Bucket(userCountBucket)
+	if err != nil {
+		return err
+	}
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, n)
+	return b.Put(userCountKey, v)
+}
+
+func TestUserProjection(t *testing.T) {
+	ctx := context.Background()
+	kvStore, closeStore := newBoltStore(t)
+	defer closeStore()
+
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.RegisterProjection(countUsers)
+
+	fail := errors.New("projection failed")
+	store.RegisterProjection(func(tx kv.Tx, e tenant.UserEvent) error {
+		if e.Name == "rejected" {
+			return fail
+		}
+		return nil
+	})
+
+	checkConsistent := func(expected uint64) {
+		t.Helper()
+		err := store.View(ctx, func(tx kv.Tx) error {
+			n, err := userCount(tx)
+			if err != nil {
+				return err
+			}
+
+			us, err := store.ListUsers(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			if n != expected || uint64(len(us)) != expected {
+				t.Fatalf("expected projection to match users: \n%d\n%d\n%d", n, len(us), expected)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 1; i <= 3; i++ {
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: "user" + string(rune('0'+i)), Status: "active"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkConsistent(3)
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.DeleteUser(ctx, tx, 2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConsistent(2)
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 4, Name: "rejected", Status: "active"})
+	})
+	if err != fail {
+		t.Fatalf("expected projection error to fail the mutation, got: %v", err)
+	}
+	// the user and the count are rolled back together
+	checkConsistent(2)
+}
@@ -0,0 +1,156 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// GetUserRaw returns the stored bytes of a user as they are, so replicators can
+// copy them without a decode and re-encode losing unknown fields.
+func (s *Store) GetUserRaw(ctx context.Context, tx kv.Tx, id influxdb.ID) ([]byte, error) {
+	v, err := s.getUserBlob(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), v...), nil
+}
+
+// GetUserSchemaVersion returns the schema version the user id was written at,
+// so migrations can find the blobs still to be rewritten.
+func (s *Store) GetUserSchemaVersion(ctx context.Context, tx kv.Tx, id influxdb.ID) (int, error) {
+	v, err := s.getUserBlob(tx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, version, err := s.unmarshalUserVersion(v)
+	return version, err
+}
+
+// PutUserRaw stores raw as the user id verbatim, creating or replacing it. The
+// bytes must decode to a user with that id, its name is used to keep the name
+// index and label index in step. A tombstone is stored without index entries,
+// as SoftDeleteUser leaves it.
+func (s *Store) PutUserRaw(ctx context.Context, tx kv.Tx, id influxdb.ID, raw []byte) error {
+	encodedID, err := s.encodeNewID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	plain, err := s.decryptUser(raw)
+	if err != nil {
+		return ErrUnprocessableUser(err)
+	}
+
+	u, err := s.unmarshalUser(raw)
+	if err != nil {
+		return ErrUnprocessableUser(err)
+	}
+	if u.ID != id {
+		return ErrUnprocessableUser(fmt.Errorf("user blob holds id %s not %s", u.ID, id))
+	}
+
+	// the user the indexes are kept for, none for a tombstone
+	live := u
+	if u.DeletedAt != nil {
+		live = nil
+	}
+
+	if err := s.validateUserName(u.Name); err != nil {
+		return err
+	}
+
+	if err := s.validateUserFields(u); err != nil {
+		return err
+	}
+
+	if live != nil {
+		if err := s.checkUserFields(ctx, tx, encodedID, u); err != nil {
+			return err
+		}
+	}
+
+	if s.schema != nil {
+		if err := s.schema(plain); err != nil {
+			return ErrUnprocessableUser(err)
+		}
+	}
+
+	action := UserAuditUpdate
+	old, err := s.GetUser(ctx, tx, id)
+	if err == nil && old.DeletedAt != nil {
+		// a tombstone holds no index entries, it is replaced like a missing
+		// user
+		err = ErrUserNotFound
+	}
+	if err == ErrUserNotFound {
+		action = UserAuditCreate
+		old = nil
+	} else if err != nil {
+		return err
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return err
+	}
+
+	// the name index entry is left alone when a live user keeps its name
+	reindex := old == nil || live == nil || old.Name != u.Name
+	if live != nil && reindex {
+		if err := s.uniqueUserName(ctx, tx, u.Name); err != nil {
+			return err
+		}
+	}
+
+	if old != nil && reindex {
+		if err := idx.Delete(s.userIndexKey(old.Name)); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	if live != nil && reindex {
+		if err := idx.Put(s.userIndexKey(u.Name), encodedID); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Put(encodedID, raw); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	if err := s.indexUserFields(ctx, tx, encodedID, old, live); err != nil {
+		return err
+	}
+
+	if live == nil {
+		return s.userMutated(ctx, tx, UserAuditDelete, nil, u)
+	}
+	return s.userMutated(ctx, tx, action, old, u)
+}
+
+// UserKeys returns the keys a user is stored under, the key of its blob in the
+// user bucket and of its entry in the name index, for diagnostic tooling that
+// inspects the raw storage.
+func (s *Store) UserKeys(ctx context.Context, tx kv.Tx, id influxdb.ID) (blobKey, indexKey []byte, err error) {
+	u, err := s.GetUser(ctx, tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blobKey, err = s.encodeID(id)
+	if err != nil {
+		return nil, nil, InvalidUserIDError(err)
+	}
+
+	return blobKey, s.userIndexKey(u.Name), nil
+}
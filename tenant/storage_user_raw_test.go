@@ -0,0 +1,356 @@
+package tenant_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestUserRaw(t *testing.T) {
+	ctx := context.Background()
+	source, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = source.Update(ctx, func(tx kv.Tx) error {
+		return source.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw []byte
+	err = source.View(ctx, func(tx kv.Tx) error {
+		raw, err = source.GetUserRaw(ctx, tx, 1)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a field this version doesn't know about must survive the copy
+	raw = append(raw[:len(raw)-1], []byte(`,"futureField":"kept"}`)...)
+
+	err = target.Update(ctx, func(tx kv.Tx) error {
+		return target.PutUserRaw(ctx, tx, 1, raw)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = target.View(ctx, func(tx kv.Tx) error {
+		got, err := target.GetUserRaw(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, raw) {
+			t.Fatalf("expected verbatim bytes: \n%s\n%s", got, raw)
+		}
+
+		u, err := target.GetUserByName(ctx, tx, "user1")
+		if err != nil {
+			t.Fatalf("expected name index to be maintained: %v", err)
+		}
+		if u.ID != 1 {
+			t.Fatalf("expected user 1 got: %v", u.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := []byte(`{"id":"0000000000000001","name":"user10","status":"active"}`)
+	err = target.Update(ctx, func(tx kv.Tx) error {
+		return target.PutUserRaw(ctx, tx, 1, renamed)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = target.View(ctx, func(tx kv.Tx) error {
+		if _, err := target.GetUserByName(ctx, tx, "user1"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected the old name to be unindexed, got: %v", err)
+		}
+		if _, err := target.GetUserByName(ctx, tx, "user10"); err != nil {
+			t.Fatalf("expected the new name to be indexed: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, blob := range map[string][]byte{
+		"not json":    []byte(`{"id":`),
+		"id mismatch": []byte(`{"id":"0000000000000002","name":"user2","status":"active"}`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := target.Update(ctx, func(tx kv.Tx) error {
+				return target.PutUserRaw(ctx, tx, 1, blob)
+			})
+			if influxdb.ErrorCode(err) != influxdb.EUnprocessableEntity {
+				t.Fatalf("expected invalid blob to be rejected, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestUserCanonicalJSON(t *testing.T) {
+	ctx := context.Background()
+
+	// equal users whose maps were built in different orders
+	users := []*influxdb.User{
+		{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "storage", "region": "eu"}, Flags: map[string]bool{"beta": true, "admin": false}},
+		{ID: 1, Flags: map[string]bool{"admin": false, "beta": true}, Labels: map[string]string{"region": "eu", "team": "storage"}, Status: "active", Name: "user1"},
+	}
+
+	expected := `{"flags":{"admin":false,"beta":true},"id":"0000000000000001","labels":{"region":"eu","team":"storage"},"name":"user1","schemaVersion":2,"status":"active"}`
+
+	for i := 0; i < 10; i++ {
+		for _, u := range users {
+			store, err := tenant.NewStore(inmem.NewKVStore())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				if err := store.CreateUser(ctx, tx, u); err != nil {
+					return err
+				}
+
+				raw, err := store.GetUserRaw(ctx, tx, u.ID)
+				if err != nil {
+					return err
+				}
+				if string(raw) != expected {
+					t.Fatalf("expected canonical user bytes: \n%s\n%s", raw, expected)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestUserKeys(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithCaseInsensitiveNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "User1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		blobKey, indexKey, err := store.UserKeys(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+
+		b, err := tx.Bucket([]byte("usersv1"))
+		if err != nil {
+			return err
+		}
+		raw, err := b.Get(blobKey)
+		if err != nil {
+			t.Fatalf("expected the blob to be stored under %q: %v", blobKey, err)
+		}
+		if stored, _ := store.GetUserRaw(ctx, tx, 1); !bytes.Equal(raw, stored) {
+			t.Fatalf("expected the blob key to hold the user: \n%q\n%q", raw, stored)
+		}
+
+		idx, err := tx.Bucket([]byte("userindexv1"))
+		if err != nil {
+			return err
+		}
+		v, err := idx.Get(indexKey)
+		if err != nil {
+			t.Fatalf("expected the index entry to be stored under %q: %v", indexKey, err)
+		}
+		if !bytes.Equal(v, blobKey) {
+			t.Fatalf("expected the index entry to point at the blob key: \n%q\n%q", v, blobKey)
+		}
+
+		if _, _, err := store.UserKeys(ctx, tx, 2); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected keys of a missing user to fail, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+
+	for name, codec := range map[string]tenant.UserCodec{
+		"json":   nil,
+		"binary": tenant.BinaryUserCodec{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var opts []tenant.StoreOption
+			if codec != nil {
+				opts = append(opts, tenant.WithCodec(codec))
+			}
+			store, err := tenant.NewStore(inmem.NewKVStore(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+					return err
+				}
+				// written before versions were stamped
+				return store.PutUserRaw(ctx, tx, 2, []byte(`{"id":"0000000000000002","name":"user2","status":"active"}`))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.View(ctx, func(tx kv.Tx) error {
+				for id, expected := range map[influxdb.ID]int{1: tenant.UserSchemaVersion, 2: 1} {
+					version, err := store.GetUserSchemaVersion(ctx, tx, id)
+					if err != nil {
+						return err
+					}
+					if version != expected {
+						t.Fatalf("expected user %s schema version: \n%d\n%d", id, version, expected)
+					}
+
+					u, err := store.GetUser(ctx, tx, id)
+					if err != nil {
+						return err
+					}
+					if u.ID != id {
+						t.Fatalf("expected stamped blob to decode: \n%+v", u)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		b, err := tx.Bucket([]byte("usersv1"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("0000000000000003"), []byte(`{"id":"0000000000000003","name":"user3","schemaVersion":99,"status":"active"}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.GetUser(ctx, tx, 3); influxdb.ErrorCode(err) != influxdb.EInternal {
+			t.Fatalf("expected blob from a newer schema to be rejected, got: %v", err)
+		}
+		if err := store.GetUserInto(ctx, tx, 3, &influxdb.User{}); influxdb.ErrorCode(err) != influxdb.EInternal {
+			t.Fatalf("expected blob from a newer schema to be rejected in place, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserRawTombstone(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active", Labels: map[string]string{"team": "storage"}}); err != nil {
+			return err
+		}
+		// a replicated soft delete of 1 and a tombstone never seen live
+		if err := store.PutUserRaw(ctx, tx, 1, []byte(`{"id":"0000000000000001","name":"user1","status":"active","labels":{"team":"storage"},"deletedAt":"2020-01-01T00:00:00Z"}`)); err != nil {
+			return err
+		}
+		return store.PutUserRaw(ctx, tx, 2, []byte(`{"id":"0000000000000002","name":"user2","status":"active","labels":{"team":"storage"},"deletedAt":"2020-01-01T00:00:00Z"}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		for _, n := range []string{"user1", "user2"} {
+			if _, err := store.GetUserByName(ctx, tx, n); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected the tombstone %s to stay out of the name index, got: %v", n, err)
+			}
+		}
+
+		us, err := store.FindUsersByLabel(ctx, tx, "team", "storage")
+		if err != nil {
+			return err
+		}
+		if len(us) != 0 {
+			t.Fatalf("expected tombstones to stay out of the label index, got: %+v", us)
+		}
+
+		u, err := store.GetUser(ctx, tx, 2)
+		if err != nil {
+			return err
+		}
+		if u.DeletedAt == nil {
+			t.Fatalf("expected the tombstone to be stored, got: %+v", u)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the names are free for live users
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 3, Name: "user2", Status: "active"})
+	})
+	if err != nil {
+		t.Fatalf("expected the tombstone's name to be free: %v", err)
+	}
+}
+
+func TestUserRawStrictStatus(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithStrictStatus())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.PutUserRaw(ctx, tx, 1, []byte(`{"id":"0000000000000001","name":"user1","status":"suspended"}`))
+	})
+	if influxdb.ErrorCode(err) != influxdb.EUnprocessableEntity {
+		t.Fatalf("expected a blob with an invalid status to be rejected, got: %v", err)
+	}
+}
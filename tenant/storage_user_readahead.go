@@ -0,0 +1,107 @@
+package tenant
+
+import (
+	"sync"
+
+	"github.com/influxdata/influxdb/kv"
+)
+
+// BatchCursor is implemented by the cursors of backends that can return several
+// records per round trip.
+type BatchCursor interface {
+	kv.ForwardCursor
+	// NextBatch returns up to n of the next keys and their values, none once
+	// the cursor is exhausted.
+	NextBatch(n int) (keys, values [][]byte)
+}
+
+// readAhead wraps c to read n records ahead, in batches when c supports them.
+func readAhead(c kv.ForwardCursor, n int) kv.ForwardCursor {
+	if bc, ok := c.(BatchCursor); ok {
+		return &batchingCursor{BatchCursor: bc, n: n}
+	}
+	return newReadAheadCursor(c, n)
+}
+
+// batchingCursor serves Next from batches of n records.
+type batchingCursor struct {
+	BatchCursor
+	n      int
+	keys   [][]byte
+	values [][]byte
+}
+
+func (c *batchingCursor) Next() (k, v []byte) {
+	if len(c.keys) == 0 {
+		c.keys, c.values = c.NextBatch(c.n)
+		if len(c.keys) == 0 {
+			return nil, nil
+		}
+	}
+
+	k, v = c.keys[0], c.values[0]
+	c.keys, c.values = c.keys[1:], c.values[1:]
+	return k, v
+}
+
+// readAheadCursor advances a cursor in its own goroutine, buffering up to the
+// read ahead of records for the caller of Next. The wrapped cursor is only
+// ever used by one goroutine at a time, Err and Close stop the read ahead
+// before they touch it.
+type readAheadCursor struct {
+	cursor kv.ForwardCursor
+	pairs  chan [2][]byte
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newReadAheadCursor(c kv.ForwardCursor, n int) *readAheadCursor {
+	r := &readAheadCursor{
+		cursor: c,
+		pairs:  make(chan [2][]byte, n),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go r.fill()
+	return r
+}
+
+func (r *readAheadCursor) fill() {
+	defer close(r.done)
+	defer close(r.pairs)
+
+	for k, v := r.cursor.Next(); k != nil; k, v = r.cursor.Next() {
+		// the backend may reuse its buffers once the cursor moves on
+		p := [2][]byte{append([]byte(nil), k...), append([]byte(nil), v...)}
+		select {
+		case r.pairs <- p:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *readAheadCursor) Next() (k, v []byte) {
+	p, ok := <-r.pairs
+	if !ok {
+		return nil, nil
+	}
+	return p[0], p[1]
+}
+
+// halt stops the read ahead and waits for it to let go of the cursor.
+func (r *readAheadCursor) halt() {
+	r.once.Do(func() { close(r.stop) })
+	<-r.done
+}
+
+func (r *readAheadCursor) Err() error {
+	r.halt()
+	return r.cursor.Err()
+}
+
+func (r *readAheadCursor) Close() error {
+	r.halt()
+	return r.cursor.Close()
+}
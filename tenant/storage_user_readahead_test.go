@@ -0,0 +1,198 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+// latencyTx makes every cursor over the named bucket pay delay per round trip
+// to the backend, like a networked kv store. With batches the cursors can
+// return several records per round trip.
+type latencyTx struct {
+	kv.Tx
+	bucket  string
+	delay   time.Duration
+	batches bool
+}
+
+func (tx latencyTx) Bucket(b []byte) (kv.Bucket, error) {
+	bkt, err := tx.Tx.Bucket(b)
+	if err != nil || string(b) != tx.bucket {
+		return bkt, err
+	}
+	return latencyBucket{Bucket: bkt, delay: tx.delay, batches: tx.batches}, nil
+}
+
+type latencyBucket struct {
+	kv.Bucket
+	delay   time.Duration
+	batches bool
+}
+
+func (b latencyBucket) ForwardCursor(seek []byte, opts ...kv.CursorOption) (kv.ForwardCursor, error) {
+	c, err := b.Bucket.ForwardCursor(seek, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if b.batches {
+		return latencyBatchCursor{latencyCursor{ForwardCursor: c, delay: b.delay}}, nil
+	}
+	return latencyCursor{ForwardCursor: c, delay: b.delay}, nil
+}
+
+type latencyCursor struct {
+	kv.ForwardCursor
+	delay time.Duration
+}
+
+func (c latencyCursor) Next() ([]byte, []byte) {
+	time.Sleep(c.delay)
+	return c.ForwardCursor.Next()
+}
+
+type latencyBatchCursor struct {
+	latencyCursor
+}
+
+func (c latencyBatchCursor) NextBatch(n int) (keys, values [][]byte) {
+	time.Sleep(c.delay)
+	for k, v := c.ForwardCursor.Next(); k != nil; k, v = c.ForwardCursor.Next() {
+		keys, values = append(keys, k), append(values, v)
+		if len(keys) == n {
+			break
+		}
+	}
+	return keys, values
+}
+
+func newReadAheadStores(tb testing.TB, n int) (kv.Store, []*tenant.Store) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	plain, err := tenant.NewStore(kvStore)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	readAhead, err := tenant.NewStore(kvStore, tenant.WithReadAhead(16))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	err = plain.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= n; i++ {
+			u := &influxdb.User{
+				ID:     influxdb.ID(i),
+				Name:   fmt.Sprintf("user%d", i),
+				Status: "active",
+				Labels: map[string]string{"team": "storage", "n": fmt.Sprint(i)},
+			}
+			if err := plain.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return kvStore, []*tenant.Store{plain, readAhead}
+}
+
+func TestListUsersReadAhead(t *testing.T) {
+	ctx := context.Background()
+	kvStore, stores := newReadAheadStores(t, 50)
+	plain, readAhead := stores[0], stores[1]
+
+	inactive := influxdb.Status("inactive")
+	err := plain.Update(ctx, func(tx kv.Tx) error {
+		_, err := plain.UpdateUser(ctx, tx, 7, influxdb.UserUpdate{Status: &inactive})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter tenant.UserFilter
+		opt    []influxdb.FindOptions
+	}{
+		{name: "default"},
+		{name: "paged", opt: []influxdb.FindOptions{{Limit: 5, Offset: 10}}},
+		{name: "descending", opt: []influxdb.FindOptions{{Limit: 5, Offset: 3, Descending: true}}},
+		{name: "filtered", filter: tenant.UserFilter{Status: &inactive}},
+		{name: "past the end", opt: []influxdb.FindOptions{{Limit: 5, Offset: 100}}},
+	}
+
+	for _, batches := range []bool{false, true} {
+		for _, tt := range tests {
+			batches, tt := batches, tt
+			t.Run(fmt.Sprintf("%s/batches=%v", tt.name, batches), func(t *testing.T) {
+				err := kvStore.View(ctx, func(tx kv.Tx) error {
+					expected, err := plain.FindUsers(ctx, tx, tt.filter, tt.opt...)
+					if err != nil {
+						return err
+					}
+
+					slow := latencyTx{Tx: tx, bucket: "usersv1", batches: batches}
+					got, err := readAhead.FindUsers(ctx, slow, tt.filter, tt.opt...)
+					if err != nil {
+						return err
+					}
+
+					if !reflect.DeepEqual(got, expected) {
+						t.Fatalf("expected read ahead to list the same users: \n%+v\n%+v", got, expected)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkListUsersReadAhead(b *testing.B) {
+	ctx := context.Background()
+	kvStore, stores := newReadAheadStores(b, influxdb.MaxPageSize)
+	plain, readAhead := stores[0], stores[1]
+
+	for _, bb := range []struct {
+		name    string
+		store   *tenant.Store
+		batches bool
+	}{
+		{name: "no read ahead", store: plain},
+		{name: "read ahead", store: readAhead},
+		{name: "no read ahead batching cursor", store: plain, batches: true},
+		{name: "read ahead batching cursor", store: readAhead, batches: true},
+	} {
+		bb := bb
+		b.Run(bb.name, func(b *testing.B) {
+			err := kvStore.View(ctx, func(tx kv.Tx) error {
+				slow := latencyTx{Tx: tx, bucket: "usersv1", delay: 20 * time.Microsecond, batches: bb.batches}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := bb.store.ListUsers(ctx, slow, influxdb.FindOptions{Limit: influxdb.MaxPageSize}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
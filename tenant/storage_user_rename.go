@@ -0,0 +1,138 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// RenameUsers renames many users at once. The whole set is checked before
+// anything is written: the new names must be valid, distinct from each other
+// and not held by a user outside the set, though they may be taken from
+// users of the set that are being renamed away. Any collision rejects the
+// whole batch.
+func (s *Store) RenameUsers(ctx context.Context, tx kv.Tx, renames map[influxdb.ID]string) error {
+	type rename struct {
+		old, u    *influxdb.User
+		encodedID []byte
+		v         []byte
+	}
+
+	ids := make([]influxdb.ID, 0, len(renames))
+	for id := range renames {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return err
+	}
+
+	targets := make(map[string]influxdb.ID, len(renames))
+	for _, id := range ids {
+		name := renames[id]
+		if err := s.validateUserName(name); err != nil {
+			return err
+		}
+
+		key := string(s.userIndexKey(name))
+		if _, ok := targets[key]; ok {
+			return UserAlreadyExistsError(name)
+		}
+		targets[key] = id
+	}
+
+	batch := make([]rename, 0, len(ids))
+	now := s.now().UTC()
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		u, err := s.GetUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		// a tombstone can't be brought back into the name index
+		if u.DeletedAt != nil {
+			return ErrUserNotFound
+		}
+
+		encodedID, err := s.encodeID(id)
+		if err != nil {
+			return InvalidUserIDError(err)
+		}
+
+		name := renames[id]
+		holder, err := idx.Get(s.userIndexKey(name))
+		switch {
+		case kv.IsNotFound(err):
+			if err := s.uniqueExternalUserName(ctx, name); err != nil {
+				return err
+			}
+		case err != nil:
+			return ErrInternalServiceError(err)
+		case !bytes.Equal(holder, encodedID):
+			// the holder must be renamed away in the same batch
+			holderID, err := s.decodeID(holder)
+			if err != nil {
+				return ErrCorruptID(err)
+			}
+			if _, ok := renames[holderID]; !ok {
+				return UserAlreadyExistsError(name)
+			}
+		}
+
+		old := *u
+		u.Name = name
+		u.UpdatedAt = &now
+
+		v, err := s.marshalUser(u)
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, rename{old: &old, u: u, encodedID: encodedID, v: v})
+	}
+
+	// drop every old name before writing the new ones so names can move
+	// between users of the batch
+	for _, r := range batch {
+		if err := idx.Delete(s.userIndexKey(r.old.Name)); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	for _, r := range batch {
+		if err := idx.Put(s.userIndexKey(r.u.Name), r.encodedID); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range batch {
+		if err := b.Put(r.encodedID, r.v); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	for _, r := range batch {
+		if err := s.verifyUserWrite(ctx, tx, r.u); err != nil {
+			return err
+		}
+
+		if err := s.userMutated(ctx, tx, UserAuditUpdate, r.old, r.u); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestRenameUsers(t *testing.T) {
+	setup := func(t *testing.T) *tenant.Store {
+		t.Helper()
+		ctx := context.Background()
+		store, err := tenant.NewStore(inmem.NewKVStore())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			for i := 1; i <= 4; i++ {
+				if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	expectNames := func(t *testing.T, store *tenant.Store, expected map[influxdb.ID]string) {
+		t.Helper()
+		ctx := context.Background()
+		err := store.View(ctx, func(tx kv.Tx) error {
+			for id, name := range expected {
+				u, err := store.GetUserByName(ctx, tx, name)
+				if err != nil {
+					return fmt.Errorf("looking up %s: %v", name, err)
+				}
+				if u.ID != id {
+					t.Fatalf("expected %s to resolve to %v got: %v", name, id, u.ID)
+				}
+			}
+
+			names, err := store.ListUserNamesOnly(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if len(names) != len(expected) {
+				t.Fatalf("expected exactly %d index entries got: %v", len(expected), names)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		ctx := context.Background()
+		store := setup(t)
+
+		// user1 and user2 swap names on the way
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			return store.RenameUsers(ctx, tx, map[influxdb.ID]string{
+				1: "user2",
+				2: "user1",
+				3: "acme-user3",
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectNames(t, store, map[influxdb.ID]string{1: "user2", 2: "user1", 3: "acme-user3", 4: "user4"})
+	})
+
+	t.Run("collisions", func(t *testing.T) {
+		ctx := context.Background()
+		store := setup(t)
+
+		for name, renames := range map[string]map[influxdb.ID]string{
+			"within the batch": {1: "acme", 2: "acme"},
+			"with the store":   {1: "acme-user1", 2: "user4"},
+		} {
+			err := store.Update(ctx, func(tx kv.Tx) error {
+				return store.RenameUsers(ctx, tx, renames)
+			})
+			if influxdb.ErrorCode(err) != influxdb.EConflict {
+				t.Fatalf("expected a collision %s to reject the batch, got: %v", name, err)
+			}
+		}
+
+		expectNames(t, store, map[influxdb.ID]string{1: "user1", 2: "user2", 3: "user3", 4: "user4"})
+	})
+}
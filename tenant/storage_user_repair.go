@@ -0,0 +1,183 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+	"go.uber.org/zap"
+)
+
+// IndexConsistency is what reading a user by name does when the name index
+// points at a user stored under another name, as a partly applied rename can
+// leave behind.
+type IndexConsistency int
+
+const (
+	// IndexRepairOnRead rewrites the user's index entry from its blob and
+	// reports the name as not found. The repair is written in the read's
+	// transaction, so it only sticks once that commits.
+	IndexRepairOnRead IndexConsistency = iota
+	// IndexFailOnRead fails the read with ErrUserIndexInconsistent.
+	IndexFailOnRead
+)
+
+// userIndexMismatch handles the name index entry n pointing at u, which is
+// stored under another name.
+func (s *Store) userIndexMismatch(ctx context.Context, tx kv.Tx, n string, u *influxdb.User) error {
+	if s.indexCheck == IndexFailOnRead {
+		return ErrUserIndexInconsistent
+	}
+
+	if err := s.repairUserIndexKey(tx, s.userIndexKey(n), u); err != nil {
+		if err != ErrReadOnlyTransaction {
+			return err
+		}
+		// the blob is still the truth, the entry is repaired by the next
+		// read in a writable transaction
+		s.log.Warn("Unable to repair user index entry in read only transaction",
+			zap.String("name", n),
+			zap.String("id", u.ID.String()))
+	}
+
+	return ErrUserNotFound
+}
+
+// repairUserIndexKey moves the stale name index entry key of u to the name u
+// is stored under. Only the two keys are touched, other stale entries are left
+// to RepairUserIndexEntry. A tombstone keeps no entry.
+func (s *Store) repairUserIndexKey(tx kv.Tx, key []byte, u *influxdb.User) error {
+	encodedID, err := s.encodeID(u.ID)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return err
+	}
+
+	if err := idx.Delete(key); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	if u.DeletedAt != nil {
+		return nil
+	}
+
+	return s.putRepairedIndexEntry(idx, u, encodedID)
+}
+
+// putRepairedIndexEntry points the name index entry of u at it, taking it over
+// from any other user.
+func (s *Store) putRepairedIndexEntry(idx kv.Bucket, u *influxdb.User, encodedID []byte) error {
+	key := s.userIndexKey(u.Name)
+	if v, err := idx.Get(key); err == nil && !bytes.Equal(v, encodedID) {
+		s.log.Warn("Repaired user index entry pointed at another user",
+			zap.String("name", u.Name),
+			zap.String("id", u.ID.String()))
+	}
+
+	if err := idx.Put(key, encodedID); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	return nil
+}
+
+// RepairUserIndexEntry rewrites the name index entry of a single user from its
+// stored blob and removes any other entry pointing at the user. It fails with
+// ErrUserNotFound if the blob itself is missing or is a tombstone.
+func (s *Store) RepairUserIndexEntry(ctx context.Context, tx kv.Tx, id influxdb.ID) error {
+	u, err := s.GetUser(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	// tombstones are only found by id
+	if u.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return err
+	}
+
+	key := s.userIndexKey(u.Name)
+
+	cursor, err := idx.ForwardCursor(nil)
+	if err != nil {
+		return err
+	}
+
+	// collect the stale keys first so deletes can't invalidate the cursor
+	var stale [][]byte
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if bytes.Equal(v, encodedID) && !bytes.Equal(k, key) {
+			stale = append(stale, append([]byte(nil), k...))
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		cursor.Close()
+		return err
+	}
+	if err := cursor.Close(); err != nil {
+		return err
+	}
+
+	for _, k := range stale {
+		if err := idx.Delete(k); err != nil {
+			return ErrWriteFailed(err)
+		}
+	}
+
+	return s.putRepairedIndexEntry(idx, u, encodedID)
+}
+
+// EnsureUserIndexEntry makes sure the name index entry of a single user points
+// at it, creating the entry if it is missing, and reports whether it did.
+// Unlike RepairUserIndexEntry it leaves stale entries alone and never takes
+// over an entry held by another user, so it is safe to call repeatedly.
+func (s *Store) EnsureUserIndexEntry(ctx context.Context, tx kv.Tx, id influxdb.ID) (bool, error) {
+	u, err := s.GetUser(ctx, tx, id)
+	if err != nil {
+		return false, err
+	}
+	// tombstones are only found by id
+	if u.DeletedAt != nil {
+		return false, ErrUserNotFound
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return false, InvalidUserIDError(err)
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return false, err
+	}
+
+	key := s.userIndexKey(u.Name)
+	v, err := idx.Get(key)
+	switch {
+	case err == nil && bytes.Equal(v, encodedID):
+		return false, nil
+	case err == nil:
+		return false, UserAlreadyExistsError(u.Name)
+	case !kv.IsNotFound(err):
+		return false, ErrInternalServiceError(err)
+	}
+
+	if err := idx.Put(key, encodedID); err != nil {
+		return false, ErrWriteFailed(err)
+	}
+	return true, nil
+}
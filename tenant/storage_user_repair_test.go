@@ -0,0 +1,303 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestRepairUserIndexEntry(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 3; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt user 2's entry by moving it under a stale name
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		idx, err := tx.Bucket([]byte("userindexv1"))
+		if err != nil {
+			return err
+		}
+		id, err := idx.Get([]byte("user2"))
+		if err != nil {
+			return err
+		}
+		if err := idx.Delete([]byte("user2")); err != nil {
+			return err
+		}
+		return idx.Put([]byte("stale"), id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.RepairUserIndexEntry(ctx, tx, 2); err != nil {
+			return err
+		}
+
+		if err := store.RepairUserIndexEntry(ctx, tx, 4); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected repairing a missing user to fail, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.GetUserByName(ctx, tx, "stale"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected the stale entry to be removed, got: %v", err)
+		}
+
+		for i := 1; i <= 3; i++ {
+			u, err := store.GetUserByName(ctx, tx, fmt.Sprintf("user%d", i))
+			if err != nil {
+				return err
+			}
+			if u.ID != influxdb.ID(i) {
+				t.Fatalf("expected user%d to resolve to %d got: %v", i, i, u.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tombstones are only found by id and stay out of the index
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.SoftDeleteUser(ctx, tx, 3); err != nil {
+			return err
+		}
+		if err := store.RepairUserIndexEntry(ctx, tx, 3); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected repairing a tombstone to fail, got: %v", err)
+		}
+		if _, err := store.GetUserByName(ctx, tx, "user3"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected the tombstone to stay unindexed, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserIndexConsistency(t *testing.T) {
+	// a rename that only reached the index, the blob still says user1
+	setup := func(t *testing.T, opts ...tenant.StoreOption) (kv.Store, *tenant.Store) {
+		ctx := context.Background()
+		kvStore := inmem.NewKVStore()
+		store, err := tenant.NewStore(kvStore, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kvStore.Update(ctx, func(tx kv.Tx) error {
+			idx, err := tx.Bucket([]byte("userindexv1"))
+			if err != nil {
+				return err
+			}
+			id, err := idx.Get([]byte("user1"))
+			if err != nil {
+				return err
+			}
+			if err := idx.Delete([]byte("user1")); err != nil {
+				return err
+			}
+			return idx.Put([]byte("renamed"), id)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kvStore, store
+	}
+
+	indexed := func(t *testing.T, kvStore kv.Store, name string) bool {
+		var found bool
+		err := kvStore.View(context.Background(), func(tx kv.Tx) error {
+			idx, err := tx.Bucket([]byte("userindexv1"))
+			if err != nil {
+				return err
+			}
+			_, err = idx.Get([]byte(name))
+			found = err == nil
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+
+	t.Run("repair on read", func(t *testing.T) {
+		ctx := context.Background()
+		kvStore, store := setup(t)
+
+		// a read only transaction can't repair, but still answers from the blob
+		err := store.View(ctx, func(tx kv.Tx) error {
+			_, err := store.GetUserByName(ctx, tx, "renamed")
+			return err
+		})
+		if err != tenant.ErrUserNotFound {
+			t.Fatalf("expected the mismatched name not to be found, got: %v", err)
+		}
+		if !indexed(t, kvStore, "renamed") {
+			t.Fatal("expected a read only transaction to leave the index alone")
+		}
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			_, err := store.GetUserByName(ctx, tx, "renamed")
+			return err
+		})
+		if err != tenant.ErrUserNotFound {
+			t.Fatalf("expected the mismatched name not to be found, got: %v", err)
+		}
+
+		if indexed(t, kvStore, "renamed") || !indexed(t, kvStore, "user1") {
+			t.Fatal("expected the index to be rederived from the blob")
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			u, err := store.GetUserByName(ctx, tx, "user1")
+			if err != nil {
+				return err
+			}
+			if u.ID != 1 {
+				t.Fatalf("expected user1 to resolve to 1 got: %v", u.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("fail on read", func(t *testing.T) {
+		ctx := context.Background()
+		kvStore, store := setup(t, tenant.WithIndexConsistency(tenant.IndexFailOnRead))
+
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			_, err := store.GetUserByName(ctx, tx, "renamed")
+			return err
+		})
+		if err != tenant.ErrUserIndexInconsistent {
+			t.Fatalf("expected the read to fail as inconsistent, got: %v", err)
+		}
+
+		if !indexed(t, kvStore, "renamed") || indexed(t, kvStore, "user1") {
+			t.Fatal("expected the index to be left alone")
+		}
+	})
+}
+
+func TestEnsureUserIndexEntry(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 2; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lose user 2's entry, leaving a stale one behind
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		idx, err := tx.Bucket([]byte("userindexv1"))
+		if err != nil {
+			return err
+		}
+		id, err := idx.Get([]byte("user2"))
+		if err != nil {
+			return err
+		}
+		if err := idx.Delete([]byte("user2")); err != nil {
+			return err
+		}
+		return idx.Put([]byte("stale"), id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for _, tt := range []struct {
+			id      influxdb.ID
+			created bool
+		}{
+			{id: 1, created: false},
+			{id: 2, created: true},
+			// calling it again finds the entry it created
+			{id: 2, created: false},
+		} {
+			created, err := store.EnsureUserIndexEntry(ctx, tx, tt.id)
+			if err != nil {
+				return err
+			}
+			if created != tt.created {
+				t.Fatalf("expected entry of user %s to be created only when missing: \n%v\n%v", tt.id, created, tt.created)
+			}
+		}
+
+		if _, err := store.EnsureUserIndexEntry(ctx, tx, 3); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected ensuring a missing user to fail, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvStore.View(ctx, func(tx kv.Tx) error {
+		idx, err := tx.Bucket([]byte("userindexv1"))
+		if err != nil {
+			return err
+		}
+		if _, err := idx.Get([]byte("user2")); err != nil {
+			t.Fatalf("expected the missing entry to be created: %v", err)
+		}
+		if _, err := idx.Get([]byte("stale")); err != nil {
+			t.Fatalf("expected the stale entry to be left alone: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
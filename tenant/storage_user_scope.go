@@ -0,0 +1,52 @@
+package tenant
+
+import (
+	"strings"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// ScopedByPrefix returns a view of the store limited to the users whose names
+// start with prefix. Reads through it only see those users and writes through
+// it reject names outside the prefix. Scoping a scoped store appends to its
+// prefix. The view shares the store's buckets, options and hooks.
+func (s *Store) ScopedByPrefix(prefix string) *Store {
+	scoped := *s
+	scoped.scope = s.scope + prefix
+	return &scoped
+}
+
+// inScope reports whether name is visible through the store.
+func (s *Store) inScope(name string) bool {
+	return strings.HasPrefix(name, s.scope)
+}
+
+// scopeFilter narrows f to the store's scope. It reports false when the
+// filter's own prefix lies outside the scope, so nothing can match.
+func (s *Store) scopeFilter(f UserFilter) (UserFilter, bool) {
+	switch {
+	case strings.HasPrefix(f.NamePrefix, s.scope):
+	case strings.HasPrefix(s.scope, f.NamePrefix):
+		f.NamePrefix = s.scope
+	default:
+		return f, false
+	}
+	return f, true
+}
+
+// getScopedUser reads the user with id for a listing. It reports false for a
+// user outside the store's scope, which listings skip rather than fail on.
+func (s *Store) getScopedUser(tx kv.Tx, id influxdb.ID) (*influxdb.User, bool, error) {
+	v, err := s.getUserBlob(tx, id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	u, err := s.unmarshalUser(v)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return u, s.inScope(u.Name), nil
+}
@@ -0,0 +1,498 @@
+package tenant_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestScopedByPrefix(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped := store.ScopedByPrefix("team-a/")
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i, n := range []string{"team-a/alice", "team-b/bob", "team-a/carol", "dave"} {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 1), Name: n, Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = scoped.View(ctx, func(tx kv.Tx) error {
+		us, err := scoped.ListUsers(ctx, tx)
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		for _, u := range us {
+			names = append(names, u.Name)
+		}
+		if expected := []string{"team-a/alice", "team-a/carol"}; !reflect.DeepEqual(names, expected) {
+			t.Fatalf("expected only scoped users listed: \n%+v\n%+v", names, expected)
+		}
+
+		n, err := scoped.CountUsersWhere(ctx, tx, tenant.UserFilter{})
+		if err != nil {
+			return err
+		}
+		if n != 2 {
+			t.Fatalf("expected only scoped users counted: \n%d\n%d", n, 2)
+		}
+
+		n, err = scoped.CountUsersWhere(ctx, tx, tenant.UserFilter{NamePrefix: "team-b/"})
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			t.Fatalf("expected a prefix outside the scope to match nothing, got: %d", n)
+		}
+
+		if _, err := scoped.GetUserByName(ctx, tx, "team-a/alice"); err != nil {
+			return err
+		}
+		if _, err := scoped.GetUserByName(ctx, tx, "team-b/bob"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected users outside the scope to be hidden by name, got: %v", err)
+		}
+		if _, err := scoped.GetUser(ctx, tx, 2); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected users outside the scope to be hidden by id, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = scoped.Update(ctx, func(tx kv.Tx) error {
+		if err := scoped.CreateUser(ctx, tx, &influxdb.User{ID: 5, Name: "eve", Status: "active"}); err != tenant.ErrUserNameOutOfScope {
+			t.Fatalf("expected create outside the scope to be rejected, got: %v", err)
+		}
+
+		name := "team-b/alice"
+		if _, err := scoped.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name}); err != tenant.ErrUserNameOutOfScope {
+			t.Fatalf("expected rename out of the scope to be rejected, got: %v", err)
+		}
+
+		if err := scoped.DeleteUser(ctx, tx, 2); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected delete outside the scope to be rejected, got: %v", err)
+		}
+
+		return scoped.CreateUser(ctx, tx, &influxdb.User{ID: 6, Name: "team-a/frank", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		us, err := store.ListUsers(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if len(us) != 5 {
+			t.Fatalf("expected the unscoped store to see every user: \n%+v", us)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScopedFinders(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped := store.ScopedByPrefix("team-a/")
+
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = store.Update(tenant.WithActor(ctx, 9), func(tx kv.Tx) error {
+		names := []string{"team-a/alice", "team-b/bob", "team-a/carol", "dave", "team-a/erin", "team-b/fred"}
+		for i, n := range names {
+			at := created.Add(time.Duration(i) * time.Hour)
+			u := &influxdb.User{
+				ID:        influxdb.ID(i + 1),
+				Name:      n,
+				Status:    "active",
+				CreatedAt: &at,
+				Labels:    map[string]string{"team": "storage"},
+				Flags:     map[string]bool{"beta": true},
+			}
+			if err := store.CreateUser(tenant.WithActor(ctx, 9), tx, u); err != nil {
+				return err
+			}
+		}
+		// tombstones on both sides of the scope
+		for _, id := range []influxdb.ID{5, 6} {
+			if err := store.SoftDeleteUser(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userNames := func(us []*influxdb.User, err error) ([]string, error) {
+		if err != nil {
+			return nil, err
+		}
+		ns := []string{}
+		for _, u := range us {
+			ns = append(ns, u.Name)
+		}
+		return ns, nil
+	}
+
+	ids := []influxdb.ID{1, 2, 3, 4}
+	live := []string{"team-a/alice", "team-a/carol"}
+
+	finders := []struct {
+		name     string
+		find     func(tx kv.Tx) ([]string, error)
+		expected []string
+	}{
+		{
+			name: "ListUsers",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.ListUsers(ctx, tx))
+			},
+			expected: live,
+		},
+		{
+			name: "ListUsers by name",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name"}))
+			},
+			expected: live,
+		},
+		{
+			name: "GetUsersByIDs",
+			find: func(tx kv.Tx) ([]string, error) {
+				byID, err := scoped.GetUsersByIDs(ctx, tx, ids)
+				ns := []string{}
+				for _, id := range ids {
+					if u, ok := byID[id]; ok {
+						ns = append(ns, u.Name)
+					}
+				}
+				return ns, err
+			},
+			expected: live,
+		},
+		{
+			name: "GetUsers",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.GetUsers(ctx, tx, ids, tenant.BatchOpts{OnMissing: tenant.MissingSkip}))
+			},
+			expected: live,
+		},
+		{
+			name: "GetUsersByNames",
+			find: func(tx kv.Tx) ([]string, error) {
+				byName, err := scoped.GetUsersByNames(ctx, tx, []string{"team-a/alice", "team-b/bob", "team-a/carol", "dave"})
+				ns := []string{}
+				for n := range byName {
+					ns = append(ns, n)
+				}
+				sort.Strings(ns)
+				return ns, err
+			},
+			expected: live,
+		},
+		{
+			name: "FindUsersInIDRange",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.FindUsersInIDRange(ctx, tx, 1, 100))
+			},
+			expected: live,
+		},
+		{
+			name: "WalkUsers",
+			find: func(tx kv.Tx) ([]string, error) {
+				ns := []string{}
+				_, err := scoped.WalkUsers(ctx, tx, 0, func(u *influxdb.User) error {
+					if u.DeletedAt == nil {
+						ns = append(ns, u.Name)
+					}
+					return nil
+				})
+				return ns, err
+			},
+			expected: live,
+		},
+		{
+			name: "ListUserNamesOnly",
+			find: func(tx kv.Tx) ([]string, error) {
+				return scoped.ListUserNamesOnly(ctx, tx)
+			},
+			expected: live,
+		},
+		{
+			name: "ListUsersByName",
+			find: func(tx kv.Tx) ([]string, error) {
+				byName, err := scoped.ListUsersByName(ctx, tx)
+				ns := []string{}
+				for n := range byName {
+					ns = append(ns, n)
+				}
+				sort.Strings(ns)
+				return ns, err
+			},
+			expected: live,
+		},
+		{
+			name: "FindUsersByLabel",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.FindUsersByLabel(ctx, tx, "team", "storage"))
+			},
+			expected: live,
+		},
+		{
+			name: "FindUsersWithLabelKey",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.FindUsersWithLabelKey(ctx, tx, "team"))
+			},
+			expected: live,
+		},
+		{
+			name: "FindUsersWithFlag",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.FindUsersWithFlag(ctx, tx, "beta"))
+			},
+			expected: live,
+		},
+		{
+			name: "FindUsersWithFlag paged",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.FindUsersWithFlag(ctx, tx, "beta", influxdb.FindOptions{Limit: 1, Offset: 1}))
+			},
+			expected: []string{"team-a/carol"},
+		},
+		{
+			name: "FindUsersByPrefixes",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.FindUsersByPrefixes(ctx, tx, []string{"team-", "dave"}))
+			},
+			expected: live,
+		},
+		{
+			name: "FindUsersInactiveSince",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.FindUsersInactiveSince(ctx, tx, time.Now()))
+			},
+			expected: live,
+		},
+		{
+			name: "FindUsersCreatedBy",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.FindUsersCreatedBy(ctx, tx, 9))
+			},
+			expected: live,
+		},
+		{
+			name: "RecentUsers",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.RecentUsers(ctx, tx, 10))
+			},
+			expected: []string{"team-a/carol", "team-a/alice"},
+		},
+		{
+			name: "ListPurgeableUsers",
+			find: func(tx kv.Tx) ([]string, error) {
+				return userNames(scoped.ListPurgeableUsers(ctx, tx, time.Now().Add(time.Hour)))
+			},
+			expected: []string{"team-a/erin"},
+		},
+		{
+			name: "ExportUsers",
+			find: func(tx kv.Tx) ([]string, error) {
+				var buf bytes.Buffer
+				if _, err := scoped.ExportUsers(ctx, tx, &buf, tenant.UserFilter{}); err != nil {
+					return nil, err
+				}
+				return exportedNames(&buf)
+			},
+			expected: live,
+		},
+		{
+			name: "ExportUsersParallel",
+			find: func(tx kv.Tx) ([]string, error) {
+				var buf bytes.Buffer
+				if _, err := scoped.ExportUsersParallel(ctx, kvStore, &buf, tenant.UserFilter{}, 2); err != nil {
+					return nil, err
+				}
+				return exportedNames(&buf)
+			},
+			expected: live,
+		},
+		{
+			name: "StreamUsersJSON",
+			find: func(tx kv.Tx) ([]string, error) {
+				var buf bytes.Buffer
+				if err := scoped.StreamUsersJSON(ctx, tx, &buf); err != nil {
+					return nil, err
+				}
+				var us []*influxdb.User
+				if err := json.Unmarshal(buf.Bytes(), &us); err != nil {
+					return nil, err
+				}
+				return userNames(us, nil)
+			},
+			expected: live,
+		},
+		{
+			name: "WriteUsersLineProtocol",
+			find: func(tx kv.Tx) ([]string, error) {
+				var buf bytes.Buffer
+				if _, err := scoped.WriteUsersLineProtocol(ctx, tx, &buf, "users"); err != nil {
+					return nil, err
+				}
+				ns := []string{}
+				for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+					i := strings.Index(line, `name="`)
+					if i < 0 {
+						continue
+					}
+					n := line[i+len(`name="`):]
+					ns = append(ns, n[:strings.IndexByte(n, '"')])
+				}
+				return ns, nil
+			},
+			expected: live,
+		},
+	}
+
+	for _, f := range finders {
+		f := f
+		t.Run(f.name, func(t *testing.T) {
+			err := scoped.View(ctx, func(tx kv.Tx) error {
+				got, err := f.find(tx)
+				if err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(got, f.expected) {
+					t.Fatalf("expected only scoped users found: \n%+v\n%+v", got, f.expected)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	err = scoped.View(ctx, func(tx kv.Tx) error {
+		stats, err := scoped.GetUserStats(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if stats.Total != 2 || stats.SoftDeleted != 1 {
+			t.Fatalf("expected only scoped users tallied, got: %+v", stats)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exportedNames returns the names of the users in newline delimited JSON.
+func exportedNames(buf *bytes.Buffer) ([]string, error) {
+	ns := []string{}
+	s := bufio.NewScanner(buf)
+	for s.Scan() {
+		var u influxdb.User
+		if err := json.Unmarshal(s.Bytes(), &u); err != nil {
+			return nil, err
+		}
+		ns = append(ns, u.Name)
+	}
+	return ns, s.Err()
+}
+
+func TestScopedPurges(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped := store.ScopedByPrefix("team-a/")
+
+	expired := time.Now().Add(-time.Hour)
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i, n := range []string{"team-a/alice", "team-b/bob", "team-a/carol", "team-b/dave"} {
+			u := &influxdb.User{ID: influxdb.ID(i + 1), Name: n, Status: "active"}
+			if i < 2 {
+				u.ExpiresAt = &expired
+			}
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		for _, id := range []influxdb.ID{3, 4} {
+			if err := store.SoftDeleteUser(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := scoped.ReapExpiredUsers(ctx, kvStore, time.Now())
+	if err != nil {
+		t.Fatalf("expected an expired user outside the scope not to abort the reap: %v", err)
+	}
+	if r.Reaped != 1 || len(r.Skipped) != 0 {
+		t.Fatalf("expected only the scoped expired user reaped, got: %+v", r)
+	}
+
+	purged, err := scoped.CompactUsers(ctx, kvStore, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("expected only the scoped tombstone purged, got: %d", purged)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		for id, expected := range map[influxdb.ID]error{
+			1: tenant.ErrUserNotFound,
+			2: nil,
+			3: tenant.ErrUserNotFound,
+			4: nil,
+		} {
+			if _, err := store.GetUser(ctx, tx, id); err != expected {
+				t.Fatalf("expected user %s lookup to return %v, got: %v", id, expected, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,239 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// compactUsersChunk is how many user records CompactUsers scans per
+// transaction.
+const compactUsersChunk = 100
+
+// SoftDeleteUser marks the user deleted and frees its name and field index
+// entries, leaving a tombstone that is still found by id until CompactUsers
+// purges it.
+func (s *Store) SoftDeleteUser(ctx context.Context, tx kv.Tx, id influxdb.ID) error {
+	u, err := s.GetUser(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if u.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	if err := s.checkLastUser(ctx, tx); err != nil {
+		return err
+	}
+
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	old := *u
+	now := s.now().UTC()
+	u.DeletedAt = &now
+
+	v, err := s.marshalUser(u)
+	if err != nil {
+		return err
+	}
+
+	idx, err := tx.Bucket(s.userIndex)
+	if err != nil {
+		return err
+	}
+
+	if err := idx.Delete(s.userIndexKey(u.Name)); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	if err := s.indexUserFields(ctx, tx, encodedID, &old, nil); err != nil {
+		return err
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Put(encodedID, v); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	return s.userMutated(ctx, tx, UserAuditDelete, nil, u)
+}
+
+// CompactUsers purges the tombstones of users soft deleted more than retain
+// ago and returns how many were purged. The user bucket is scanned in chunks,
+// each in its own write transaction, so a large bucket doesn't end up in one
+// giant transaction.
+func (s *Store) CompactUsers(ctx context.Context, store kv.Store, retain time.Duration) (int, error) {
+	cutoff := s.now().Add(-retain)
+
+	purged := 0
+	var seek []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+
+		var done bool
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			last, expired, err := s.expiredTombstones(tx, seek, cutoff)
+			if err != nil {
+				return err
+			}
+
+			for _, id := range expired {
+				if err := s.purgeUser(ctx, tx, id); err != nil {
+					return err
+				}
+			}
+
+			purged += len(expired)
+			done = last == nil
+			seek = last
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+
+		if done {
+			return purged, nil
+		}
+	}
+}
+
+// ListPurgeableUsers lists the soft deleted users whose tombstones are older
+// than olderThan, in id order, so they can be reviewed before CompactUsers
+// purges them.
+func (s *Store) ListPurgeableUsers(ctx context.Context, tx kv.Tx, olderThan time.Time, opt ...influxdb.FindOptions) ([]*influxdb.User, error) {
+	o := applyFindOptions(opt, s.defaultLimit)
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(nil, cursorDirection(o))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	page := newPaginator(o)
+	us := []*influxdb.User{}
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return nil, err
+		}
+		if u.DeletedAt == nil || !u.DeletedAt.Before(olderThan) || !s.inScope(u.Name) {
+			continue
+		}
+
+		if !page.take() {
+			continue
+		}
+
+		us = append(us, u)
+
+		if page.full() {
+			break
+		}
+	}
+
+	return us, cursor.Err()
+}
+
+// expiredTombstones scans up to compactUsersChunk users after seek and returns
+// the ids of those soft deleted before cutoff along with the last key scanned,
+// nil once the bucket is exhausted.
+func (s *Store) expiredTombstones(tx kv.Tx, seek []byte, cutoff time.Time) ([]byte, []influxdb.ID, error) {
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cursor, err := b.ForwardCursor(seek)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cursor.Close()
+
+	var (
+		last    []byte
+		expired []influxdb.ID
+		scanned int
+	)
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		// the cursor starts at the last key of the previous chunk
+		if seek != nil && bytes.Equal(k, seek) {
+			continue
+		}
+
+		if scanned == compactUsersChunk {
+			return last, expired, cursor.Err()
+		}
+		scanned++
+		last = append([]byte(nil), k...)
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if u.DeletedAt != nil && u.DeletedAt.Before(cutoff) && s.inScope(u.Name) {
+			expired = append(expired, u.ID)
+		}
+	}
+
+	return nil, expired, cursor.Err()
+}
+
+// purgeUser removes a tombstone and the records kept alongside it. Its name
+// and field index entries were already freed when it was soft deleted and may
+// belong to another user by now, so they are left alone.
+func (s *Store) purgeUser(ctx context.Context, tx kv.Tx, id influxdb.ID) error {
+	encodedID, err := s.encodeID(id)
+	if err != nil {
+		return InvalidUserIDError(err)
+	}
+
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Delete(encodedID); err != nil {
+		return ErrWriteFailed(err)
+	}
+
+	if err := s.DeletePassword(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if err := s.deleteUserMeta(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return s.deleteLastLogin(ctx, tx, id)
+}
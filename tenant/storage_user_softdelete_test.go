@@ -0,0 +1,588 @@
+package tenant_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestCompactUsers(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore, tenant.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := old.Add(10 * 24 * time.Hour)
+
+	// enough users to span several compaction chunks, every third one is
+	// soft deleted long ago and every fifth one recently
+	const n = 250
+	var expectPurged int
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		clock.Set(old)
+		for i := 1; i <= n; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+
+		for i := 1; i <= n; i++ {
+			switch {
+			case i%3 == 0:
+				clock.Set(old)
+				expectPurged++
+			case i%5 == 0:
+				clock.Set(recent)
+			default:
+				continue
+			}
+			if err := store.SoftDeleteUser(ctx, tx, influxdb.ID(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.GetUserByName(ctx, tx, "user3"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected a soft deleted user to be gone by name, got: %v", err)
+		}
+		u, err := store.GetUser(ctx, tx, 3)
+		if err != nil {
+			return err
+		}
+		if u.DeletedAt == nil {
+			t.Fatalf("expected the tombstone to carry its deletion time")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Set(recent.Add(24 * time.Hour))
+	purged, err := store.CompactUsers(ctx, kvStore, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != expectPurged {
+		t.Fatalf("expected %d tombstones purged got: %d", expectPurged, purged)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= n; i++ {
+			u, err := store.GetUser(ctx, tx, influxdb.ID(i))
+			switch {
+			case i%3 == 0:
+				if err != tenant.ErrUserNotFound {
+					t.Fatalf("expected old tombstone %d to be purged, got: %v", i, err)
+				}
+			case i%5 == 0:
+				if err != nil || u.DeletedAt == nil {
+					t.Fatalf("expected recent tombstone %d to be kept, got: %+v %v", i, u, err)
+				}
+			default:
+				if err != nil || u.DeletedAt != nil {
+					t.Fatalf("expected live user %d to be kept, got: %+v %v", i, u, err)
+				}
+			}
+		}
+
+		us, err := store.ListUsers(ctx, tx, influxdb.FindOptions{Limit: n})
+		if err != nil {
+			return err
+		}
+		for _, u := range us {
+			if u.DeletedAt != nil {
+				t.Fatalf("expected listings to skip tombstones, got: %+v", u)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListPurgeableUsers(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		clock.Set(start)
+		for i := 1; i <= 6; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+
+		// user i is soft deleted i days in, user 6 stays live
+		for i := 1; i <= 5; i++ {
+			clock.Set(start.Add(time.Duration(i) * 24 * time.Hour))
+			if err := store.SoftDeleteUser(ctx, tx, influxdb.ID(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cutoff := start.Add(4 * 24 * time.Hour)
+	for _, tt := range []struct {
+		name     string
+		opt      []influxdb.FindOptions
+		expected []influxdb.ID
+	}{
+		{name: "all", expected: []influxdb.ID{1, 2, 3}},
+		{name: "limit", opt: []influxdb.FindOptions{{Limit: 2}}, expected: []influxdb.ID{1, 2}},
+		{name: "offset", opt: []influxdb.FindOptions{{Limit: 2, Offset: 2}}, expected: []influxdb.ID{3}},
+		{name: "descending", opt: []influxdb.FindOptions{{Limit: 2, Descending: true}}, expected: []influxdb.ID{3, 2}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.View(ctx, func(tx kv.Tx) error {
+				us, err := store.ListPurgeableUsers(ctx, tx, cutoff, tt.opt...)
+				if err != nil {
+					return err
+				}
+
+				ids := []influxdb.ID{}
+				for _, u := range us {
+					ids = append(ids, u.ID)
+				}
+				if !reflect.DeepEqual(ids, tt.expected) {
+					t.Fatalf("expected only tombstones older than the cutoff: \n%+v\n%+v", ids, tt.expected)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFindUsersIncludeDeleted(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 4; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		if err := store.SoftDeleteUser(ctx, tx, 2); err != nil {
+			return err
+		}
+		return store.SoftDeleteUser(ctx, tx, 3)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		us, err := store.FindUsers(ctx, tx, tenant.UserFilter{IncludeDeleted: true})
+		if err != nil {
+			return err
+		}
+
+		var ids, deleted []influxdb.ID
+		for _, u := range us {
+			ids = append(ids, u.ID)
+			if u.DeletedAt != nil {
+				deleted = append(deleted, u.ID)
+			}
+		}
+		if expected := []influxdb.ID{1, 2, 3, 4}; !reflect.DeepEqual(ids, expected) {
+			t.Fatalf("expected live and deleted users interleaved in id order: \n%+v\n%+v", ids, expected)
+		}
+		if expected := []influxdb.ID{2, 3}; !reflect.DeepEqual(deleted, expected) {
+			t.Fatalf("expected deleted users to carry DeletedAt: \n%+v\n%+v", deleted, expected)
+		}
+
+		n, err := store.CountUsersWhere(ctx, tx, tenant.UserFilter{IncludeDeleted: true})
+		if err != nil {
+			return err
+		}
+		if n != 4 {
+			t.Fatalf("expected deleted users to be counted: \n%d\n%d", n, 4)
+		}
+
+		us, err = store.ListUsers(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if len(us) != 2 {
+			t.Fatalf("expected deleted users to stay out of the normal listing: \n%+v", us)
+		}
+
+		if _, err := store.FindUsers(ctx, tx, tenant.UserFilter{IncludeDeleted: true}, influxdb.FindOptions{SortBy: "name"}); err != tenant.ErrUnsupportedSort {
+			t.Fatalf("expected listing deleted users by name to be unsupported, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSoftDeletedUserMutations(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithIndexConfig(tenant.IndexConfig{
+		Unique: []string{"name", "email"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the name and email of the tombstone are taken again
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Email: "user1@example.com", Status: "active"}); err != nil {
+			return err
+		}
+		if err := store.SoftDeleteUser(ctx, tx, 1); err != nil {
+			return err
+		}
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user1", Email: "user1@example.com", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		name := "user10"
+		if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name}); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected updating a tombstone to fail, got: %v", err)
+		}
+		if _, err := store.GetUserByName(ctx, tx, "user10"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected the tombstone to stay out of the name index, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var export, points bytes.Buffer
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.ExportUsers(ctx, tx, &export, tenant.UserFilter{}); err != nil {
+			return err
+		}
+		_, err := store.WriteUsersLineProtocol(ctx, tx, &points, "users")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(export.String(), "\n"); n != 1 || strings.Contains(export.String(), "deletedAt") {
+		t.Fatalf("expected the export to skip the tombstone, got: %s", export.String())
+	}
+	if n := strings.Count(points.String(), "\n"); n != 1 || !strings.Contains(points.String(), "id=0000000000000002") {
+		t.Fatalf("expected line protocol to skip the tombstone, got: %s", points.String())
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.DeleteUser(ctx, tx, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.GetUser(ctx, tx, 1); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected deleting the tombstone to purge it, got: %v", err)
+		}
+
+		u, err := store.GetUserByName(ctx, tx, "user1")
+		if err != nil {
+			t.Fatalf("expected the live user to keep its name: %v", err)
+		}
+		if u.ID != 2 {
+			t.Fatalf("expected user1 to resolve to 2, got: %v", u.ID)
+		}
+
+		us, err := store.FindUsersByField(ctx, tx, "email", "user1@example.com")
+		if err != nil {
+			return err
+		}
+		if len(us) != 1 || us[0].ID != 2 {
+			t.Fatalf("expected the live user to keep its email, got: %+v", us)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSoftDeletedUserWrites(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	softDeleted := func(t *testing.T, u *influxdb.User) {
+		t.Helper()
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+			return store.SoftDeleteUser(ctx, tx, u.ID)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("rename", func(t *testing.T) {
+		softDeleted(t, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			if err := store.CanRenameUser(ctx, tx, 1, "user10"); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected a tombstone to be unrenameable, got: %v", err)
+			}
+			if err := store.RenameUsers(ctx, tx, map[influxdb.ID]string{1: "user10"}); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected renaming a tombstone to fail, got: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			if _, err := store.GetUserByName(ctx, tx, "user10"); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected the tombstone to stay out of the name index, got: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		softDeleted(t, &influxdb.User{ID: 2, Name: "user2", Status: "active"})
+
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			if err := store.TouchUser(ctx, tx, 2); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected touching a tombstone to fail, got: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			u, err := store.GetUser(ctx, tx, 2)
+			if err != nil {
+				return err
+			}
+			if u.UpdatedAt != nil {
+				t.Fatalf("expected the tombstone to be left as it was, got: %+v", u)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("raw put over a tombstone", func(t *testing.T) {
+		softDeleted(t, &influxdb.User{ID: 3, Name: "user3", Status: "active"})
+
+		err := store.Update(ctx, func(tx kv.Tx) error {
+			return store.PutUserRaw(ctx, tx, 3, []byte(`{"id":"0000000000000003","name":"user3","status":"active"}`))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			u, err := store.GetUserByName(ctx, tx, "user3")
+			if err != nil {
+				t.Fatalf("expected the replaced tombstone to be found by name: %v", err)
+			}
+			if u.ID != 3 || u.DeletedAt != nil {
+				t.Fatalf("expected the live user, got: %+v", u)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("move", func(t *testing.T) {
+		dst, err := tenant.NewStore(kvStore, tenant.WithUserBuckets([]byte("produsersv1"), []byte("produserindexv1")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		softDeleted(t, &influxdb.User{ID: 4, Name: "user4", Status: "active"})
+
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			if err := store.MoveUser(ctx, tx, dst, 4); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected moving a tombstone to fail, got: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = dst.View(ctx, func(tx kv.Tx) error {
+			if _, err := dst.GetUser(ctx, tx, 4); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected the tombstone to stay behind, got: %v", err)
+			}
+			if _, err := dst.GetUserByName(ctx, tx, "user4"); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected no index entry for the tombstone, got: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestSoftDeletedUserSync(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 3; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		for _, id := range []influxdb.ID{2, 3} {
+			if err := store.SoftDeleteUser(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 comes back, the tombstone of 3 is neither wanted nor pruned
+	desired := []*influxdb.User{
+		{ID: 1, Name: "user1", Status: "active"},
+		{ID: 2, Name: "user2", Status: "inactive"},
+	}
+
+	var res tenant.SyncResult
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		var err error
+		res, err = store.SyncUsers(ctx, tx, desired, tenant.SyncOpts{PruneMissing: true})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected a tombstoned desired id not to fail the sync: %v", err)
+	}
+
+	if expected := (tenant.SyncResult{Created: 1, Unchanged: 1}); res != expected {
+		t.Fatalf("expected sync results to match: \n%+v\n%+v", expected, res)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		u, err := store.GetUserByName(ctx, tx, "user2")
+		if err != nil {
+			return err
+		}
+		if u.ID != 2 || u.Status != "inactive" || u.DeletedAt != nil {
+			t.Fatalf("expected the desired user to replace the tombstone, got: %+v", u)
+		}
+
+		u, err = store.GetUser(ctx, tx, 3)
+		if err != nil {
+			t.Fatalf("expected the prune to leave the tombstone for CompactUsers: %v", err)
+		}
+		if u.DeletedAt == nil {
+			t.Fatalf("expected user 3 to still be a tombstone, got: %+v", u)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSoftDeleteLastUser(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithPreventLastUserDeletion())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for _, u := range []*influxdb.User{
+			{ID: 1, Name: "user1", Status: "active"},
+			{ID: 2, Name: "user2", Status: "active"},
+		} {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return store.SoftDeleteUser(ctx, tx, 1)
+	})
+	if err != nil {
+		t.Fatalf("expected soft deleting the penultimate user to succeed: %v", err)
+	}
+
+	// the tombstone of 1 doesn't count as a live user
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.SoftDeleteUser(ctx, tx, 2)
+	})
+	if err != tenant.ErrCannotDeleteLastUser {
+		t.Fatalf("expected soft deleting the last live user to be blocked, got: %v", err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		u, err := store.GetUserByName(ctx, tx, "user2")
+		if err != nil {
+			return err
+		}
+		if u.DeletedAt != nil {
+			t.Fatalf("expected the last user to stay live, got: %+v", u)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+package tenant
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// SyncOpts configures SyncUsers.
+type SyncOpts struct {
+	// PruneMissing deletes the stored users that aren't in the desired set.
+	PruneMissing bool
+}
+
+// SyncResult counts the changes SyncUsers made.
+type SyncResult struct {
+	Created   int
+	Updated   int
+	Deleted   int
+	Unchanged int
+}
+
+// SyncUsers converges the stored users on desired, matched by id. Missing users
+// are created, replacing any tombstone left under their id, and users whose
+// name, status or labels differ are updated to the desired values. Stored users
+// absent from desired are left alone unless opts.PruneMissing is set, which
+// leaves tombstones to CompactUsers.
+func (s *Store) SyncUsers(ctx context.Context, tx kv.Tx, desired []*influxdb.User, opts SyncOpts) (SyncResult, error) {
+	var res SyncResult
+
+	want := make(map[influxdb.ID]struct{}, len(desired))
+	for _, d := range desired {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		want[d.ID] = struct{}{}
+
+		u, err := s.GetUser(ctx, tx, d.ID)
+		if err == nil && u.DeletedAt != nil {
+			// the desired user replaces the tombstone and what it left
+			if err := s.purgeUser(ctx, tx, d.ID); err != nil {
+				return res, err
+			}
+			err = ErrUserNotFound
+		}
+		if err == ErrUserNotFound {
+			if err := s.CreateUser(ctx, tx, d); err != nil {
+				return res, err
+			}
+			res.Created++
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+
+		upd, changed := syncUpdate(u, d)
+		if !changed {
+			res.Unchanged++
+			continue
+		}
+
+		if _, err := s.UpdateUser(ctx, tx, d.ID, upd); err != nil {
+			return res, err
+		}
+		res.Updated++
+	}
+
+	if !opts.PruneMissing {
+		return res, nil
+	}
+
+	// collect the ids first so deletes can't invalidate the walk
+	var prune []influxdb.ID
+	_, err := s.WalkUsers(ctx, tx, 0, func(u *influxdb.User) error {
+		// tombstones are left for CompactUsers
+		if u.DeletedAt != nil {
+			return nil
+		}
+		if _, ok := want[u.ID]; !ok {
+			prune = append(prune, u.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	for _, id := range prune {
+		if err := s.DeleteUser(ctx, tx, id); err != nil {
+			return res, err
+		}
+		res.Deleted++
+	}
+
+	return res, nil
+}
+
+// syncUpdate builds the update moving u to the desired user d.
+func syncUpdate(u, d *influxdb.User) (influxdb.UserUpdate, bool) {
+	var upd influxdb.UserUpdate
+	changed := false
+
+	if u.Name != d.Name {
+		upd.Name = &d.Name
+		changed = true
+	}
+
+	if u.Status != d.Status {
+		upd.Status = &d.Status
+		changed = true
+	}
+
+	if len(u.Labels) != len(d.Labels) || (len(d.Labels) > 0 && !reflect.DeepEqual(u.Labels, d.Labels)) {
+		upd.Labels = d.Labels
+		if upd.Labels == nil {
+			upd.Labels = map[string]string{}
+		}
+		changed = true
+	}
+
+	if len(u.Flags) != len(d.Flags) || (len(d.Flags) > 0 && !reflect.DeepEqual(u.Flags, d.Flags)) {
+		upd.Flags = d.Flags
+		if upd.Flags == nil {
+			upd.Flags = map[string]bool{}
+		}
+		changed = true
+	}
+
+	return upd, changed
+}
@@ -0,0 +1,122 @@
+package tenant_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/mock"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestSyncUsers(t *testing.T) {
+	stored := []*influxdb.User{
+		{ID: 1, Name: "user1", Status: "active"},
+		{ID: 2, Name: "user2", Status: "active"},
+		{ID: 3, Name: "user3", Status: "active"},
+	}
+	desired := []*influxdb.User{
+		{ID: 1, Name: "user1", Status: "active"},
+		{ID: 2, Name: "renamed", Status: "inactive", Labels: map[string]string{"team": "ui"}},
+		{ID: 4, Name: "user4", Status: "active"},
+	}
+
+	tests := []struct {
+		name     string
+		opts     tenant.SyncOpts
+		result   tenant.SyncResult
+		expected []*influxdb.User
+	}{
+		{
+			name:   "prune disabled",
+			opts:   tenant.SyncOpts{},
+			result: tenant.SyncResult{Created: 1, Updated: 1, Unchanged: 1},
+			expected: []*influxdb.User{
+				{ID: 1, Name: "user1", Status: "active"},
+				{ID: 2, Name: "renamed", Status: "inactive", Labels: map[string]string{"team": "ui"}, UpdatedAt: &testUpdatedAt},
+				{ID: 3, Name: "user3", Status: "active"},
+				{ID: 4, Name: "user4", Status: "active"},
+			},
+		},
+		{
+			name:   "prune missing",
+			opts:   tenant.SyncOpts{PruneMissing: true},
+			result: tenant.SyncResult{Created: 1, Updated: 1, Deleted: 1, Unchanged: 1},
+			expected: []*influxdb.User{
+				{ID: 1, Name: "user1", Status: "active"},
+				{ID: 2, Name: "renamed", Status: "inactive", Labels: map[string]string{"team": "ui"}, UpdatedAt: &testUpdatedAt},
+				{ID: 4, Name: "user4", Status: "active"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(mock.TimeGenerator{FakeValue: testUpdatedAt}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				for _, u := range stored {
+					u := *u
+					if err := store.CreateUser(ctx, tx, &u); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				res, err := store.SyncUsers(ctx, tx, desired, tt.opts)
+				if err != nil {
+					return err
+				}
+				if res != tt.result {
+					t.Fatalf("expected sync result %+v got: %+v", tt.result, res)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.View(ctx, func(tx kv.Tx) error {
+				users, err := store.ListUsers(ctx, tx)
+				if err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(users, tt.expected) {
+					t.Fatalf("expected converged users: \n%+v\n%+v", users, tt.expected)
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a second sync with the same input has nothing left to do
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				res, err := store.SyncUsers(ctx, tx, desired, tt.opts)
+				if err != nil {
+					return err
+				}
+				if expected := (tenant.SyncResult{Unchanged: len(desired)}); res != expected {
+					t.Fatalf("expected repeated sync result %+v got: %+v", expected, res)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -2,16 +2,48 @@ package tenant_test
 
 import (
 	"context"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/influxdata/influxdb"
 	"github.com/influxdata/influxdb/inmem"
 	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/mock"
 	"github.com/influxdata/influxdb/tenant"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/text/language"
 )
 
+var testUpdatedAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testClock is a TimeGenerator that tests can move.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
 func TestUser(t *testing.T) {
 	driver := func() kv.Store {
 		return inmem.NewKVStore()
@@ -100,6 +132,71 @@ func TestUser(t *testing.T) {
 
 			},
 		},
+		{
+			name:  "get by names",
+			setup: simpleSetup,
+			results: func(t *testing.T, store *tenant.Store, tx kv.Tx) {
+				users, err := store.GetUsersByNames(context.Background(), tx, []string{"user2", "notauser", "user7", "user2"})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected := map[string]*influxdb.User{
+					"user2": {ID: 2, Name: "user2", Status: "active"},
+					"user7": {ID: 7, Name: "user7", Status: "active"},
+				}
+				if !reflect.DeepEqual(users, expected) {
+					t.Fatalf("expected identical users: \n%+v\n%+v", users, expected)
+				}
+
+				users, err = store.GetUsersByNames(context.Background(), tx, []string{"notauser"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(users) != 0 {
+					t.Fatalf("expected no users for absent names got: %+v", users)
+				}
+			},
+		},
+		{
+			name:  "get by ids",
+			setup: simpleSetup,
+			results: func(t *testing.T, store *tenant.Store, tx kv.Tx) {
+				users, err := store.GetUsersByIDs(context.Background(), tx, []influxdb.ID{3, 42, 9, 3})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected := map[influxdb.ID]*influxdb.User{
+					3: {ID: 3, Name: "user3", Status: "active"},
+					9: {ID: 9, Name: "user9", Status: "active"},
+				}
+				if !reflect.DeepEqual(users, expected) {
+					t.Fatalf("expected identical users: \n%+v\n%+v", users, expected)
+				}
+			},
+		},
+		{
+			name:  "get ordered",
+			setup: simpleSetup,
+			results: func(t *testing.T, store *tenant.Store, tx kv.Tx) {
+				users, err := store.GetUsersOrdered(context.Background(), tx, []influxdb.ID{42, 3, 43, 9, 44})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected := []*influxdb.User{
+					nil,
+					{ID: 3, Name: "user3", Status: "active"},
+					nil,
+					{ID: 9, Name: "user9", Status: "active"},
+					nil,
+				}
+				if !reflect.DeepEqual(users, expected) {
+					t.Fatalf("expected identical users: \n%+v\n%+v", users, expected)
+				}
+			},
+		},
 		{
 			name:  "list",
 			setup: simpleSetup,
@@ -150,6 +247,78 @@ func TestUser(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:  "list sorted",
+			setup: simpleSetup,
+			results: func(t *testing.T, store *tenant.Store, tx kv.Tx) {
+				users, err := store.ListUsers(context.Background(), tx, influxdb.FindOptions{SortBy: "name", Limit: 3, Offset: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected := []*influxdb.User{
+					{ID: 10, Name: "user10", Status: "active"},
+					{ID: 2, Name: "user2", Status: "active"},
+					{ID: 3, Name: "user3", Status: "active"},
+				}
+				if !reflect.DeepEqual(users, expected) {
+					t.Fatalf("expected identical users sorted by name: \n%+v\n%+v", users, expected)
+				}
+
+				if _, err := store.ListUsers(context.Background(), tx, influxdb.FindOptions{SortBy: "email"}); err != tenant.ErrUnsupportedSort {
+					t.Fatalf("expected unsupported sort error, got: %v", err)
+				}
+			},
+		},
+		{
+			name:  "find excluding ids",
+			setup: simpleSetup,
+			results: func(t *testing.T, store *tenant.Store, tx kv.Tx) {
+				filter := tenant.UserFilter{ExcludeIDs: []influxdb.ID{1, 3, 4, 9}}
+
+				users, err := store.FindUsers(context.Background(), tx, filter, influxdb.FindOptions{Limit: 4})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected := []*influxdb.User{
+					{ID: 2, Name: "user2", Status: "active"},
+					{ID: 5, Name: "user5", Status: "active"},
+					{ID: 6, Name: "user6", Status: "active"},
+					{ID: 7, Name: "user7", Status: "active"},
+				}
+				if !reflect.DeepEqual(users, expected) {
+					t.Fatalf("expected identical users: \n%+v\n%+v", users, expected)
+				}
+
+				users, err = store.FindUsers(context.Background(), tx, filter, influxdb.FindOptions{Offset: 4})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected = []*influxdb.User{
+					{ID: 8, Name: "user8", Status: "active"},
+					{ID: 10, Name: "user10", Status: "active"},
+				}
+				if !reflect.DeepEqual(users, expected) {
+					t.Fatalf("expected offset to apply after exclusion: \n%+v\n%+v", users, expected)
+				}
+
+				users, err = store.FindUsers(context.Background(), tx, filter, influxdb.FindOptions{SortBy: "name", Limit: 3})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected = []*influxdb.User{
+					{ID: 10, Name: "user10", Status: "active"},
+					{ID: 2, Name: "user2", Status: "active"},
+					{ID: 5, Name: "user5", Status: "active"},
+				}
+				if !reflect.DeepEqual(users, expected) {
+					t.Fatalf("expected exclusion when sorting by name: \n%+v\n%+v", users, expected)
+				}
+			},
+		},
 		{
 			name:  "update",
 			setup: simpleSetup,
@@ -192,6 +361,7 @@ func TestUser(t *testing.T) {
 				}
 				expected[2].Name = "user30"
 				expected[2].Status = "inactive"
+				expected[2].UpdatedAt = &testUpdatedAt
 				if !reflect.DeepEqual(users, expected) {
 					t.Fatalf("expected identical users: \n%+v\n%+v", users, expected)
 				}
@@ -246,7 +416,7 @@ func TestUser(t *testing.T) {
 	}
 	for _, testScenario := range st {
 		t.Run(testScenario.name, func(t *testing.T) {
-			ts, err := tenant.NewStore(driver())
+			ts, err := tenant.NewStore(driver(), tenant.WithClock(mock.TimeGenerator{FakeValue: testUpdatedAt}))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -289,3 +459,2506 @@ func TestUser(t *testing.T) {
 		})
 	}
 }
+
+func TestUserBucketNames(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	prod, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging, err := tenant.NewStore(kvStore, tenant.WithUserBuckets([]byte("stagingusersv1"), []byte("staginguserindexv1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		if err := prod.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+
+		// the same name is free in the other namespace
+		if err := staging.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+		return staging.CreateUser(ctx, tx, &influxdb.User{ID: 3, Name: "user3", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvStore.View(ctx, func(tx kv.Tx) error {
+		users, err := prod.ListUsers(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		expected := []*influxdb.User{{ID: 1, Name: "user1", Status: "active"}}
+		if !reflect.DeepEqual(users, expected) {
+			t.Fatalf("expected identical prod users: \n%+v\n%+v", users, expected)
+		}
+
+		users, err = staging.ListUsers(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		expected = []*influxdb.User{
+			{ID: 2, Name: "user1", Status: "active"},
+			{ID: 3, Name: "user3", Status: "active"},
+		}
+		if !reflect.DeepEqual(users, expected) {
+			t.Fatalf("expected identical staging users: \n%+v\n%+v", users, expected)
+		}
+
+		u, err := staging.GetUserByName(ctx, tx, "user1")
+		if err != nil {
+			return err
+		}
+		if u.ID != 2 {
+			t.Fatalf("expected staging user1 to have id 2, got: %v", u.ID)
+		}
+
+		if _, err := prod.GetUser(ctx, tx, 3); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected staging user to be invisible to prod, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserBucketsKeepRecordsApart(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	prod, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging, err := tenant.NewStore(kvStore, tenant.WithUserBuckets([]byte("stagingusersv1"), []byte("staginguserindexv1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same id in both namespaces
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		for _, s := range []*tenant.Store{prod, staging} {
+			if err := s.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+				return err
+			}
+		}
+		if err := staging.SetPassword(ctx, tx, 1, "password1"); err != nil {
+			return err
+		}
+		if err := staging.SetUserMeta(ctx, tx, 1, tenant.UserMeta{"avatar": "a.png"}); err != nil {
+			return err
+		}
+		return staging.RecordLogin(ctx, tx, 1, time.Now())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvStore.View(ctx, func(tx kv.Tx) error {
+		if _, err := prod.GetPassword(ctx, tx, 1); err != tenant.ErrPasswordNotFound {
+			t.Fatalf("expected the staging password to be invisible to prod, got: %v", err)
+		}
+		meta, err := prod.GetUserMeta(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if len(meta) != 0 {
+			t.Fatalf("expected the staging metadata to be invisible to prod, got: %+v", meta)
+		}
+		if at, err := prod.GetLastLogin(ctx, tx, 1); err != nil || !at.IsZero() {
+			t.Fatalf("expected the staging login to be invisible to prod, got: %v %v", at, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		if err := prod.DeleteUser(ctx, tx, 1); err != nil {
+			return err
+		}
+		_, err := prod.PurgeOrphanedUserData(ctx, tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvStore.View(ctx, func(tx kv.Tx) error {
+		if _, err := staging.GetPassword(ctx, tx, 1); err != nil {
+			t.Fatalf("expected the staging password to survive prod deletes: %v", err)
+		}
+		meta, err := staging.GetUserMeta(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if meta["avatar"] != "a.png" {
+			t.Fatalf("expected the staging metadata to survive prod deletes, got: %+v", meta)
+		}
+		if at, err := staging.GetLastLogin(ctx, tx, 1); err != nil || at.IsZero() {
+			t.Fatalf("expected the staging login to survive prod deletes, got: %v %v", at, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserBucketsKeepIndexesApart(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	opts := []tenant.StoreOption{
+		tenant.WithCreationTimes(),
+		tenant.WithIndexConfig(tenant.IndexConfig{
+			Unique:    []string{"name", "email"},
+			NonUnique: []string{"labels"},
+		}),
+	}
+	prod, err := tenant.NewStore(kvStore, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging, err := tenant.NewStore(kvStore, append(opts, tenant.WithUserBuckets([]byte("stagingusersv1"), []byte("staginguserindexv1")))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().Add(-time.Hour)
+	err = kvStore.Update(ctx, func(tx kv.Tx) error {
+		if err := prod.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Email: "user@example.com", Status: "active"}); err != nil {
+			return err
+		}
+
+		// the same email is free in the other namespace
+		return staging.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Email: "user@example.com", Status: "active", Labels: map[string]string{"team": "storage"}, ExpiresAt: &expires})
+	})
+	if err != nil {
+		t.Fatalf("expected unique fields to be unique per namespace: %v", err)
+	}
+
+	err = kvStore.View(ctx, func(tx kv.Tx) error {
+		us, err := prod.FindUsersByLabel(ctx, tx, "team", "storage")
+		if err != nil {
+			return err
+		}
+		if len(us) != 0 {
+			t.Fatalf("expected staging labels to be invisible to prod, got: %+v", us)
+		}
+
+		es, err := prod.ListUserAudit(ctx, tx, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+		if err != nil {
+			return err
+		}
+		if len(es) != 1 || es[0].UserID != 1 {
+			t.Fatalf("expected only the prod audit entry, got: %+v", es)
+		}
+
+		us, err = prod.RecentUsers(ctx, tx, 10)
+		if err != nil {
+			return err
+		}
+		if len(us) != 1 || us[0].ID != 1 {
+			t.Fatalf("expected only the prod user to be recent, got: %+v", us)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := prod.ReapExpiredUsers(ctx, kvStore, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Reaped != 0 {
+		t.Fatalf("expected prod not to reap staging users, reaped: %d", r.Reaped)
+	}
+
+	r, err = staging.ReapExpiredUsers(ctx, kvStore, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Reaped != 1 {
+		t.Fatalf("expected staging to reap its expired user, reaped: %d", r.Reaped)
+	}
+}
+
+func TestUserSelfTest(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SelfTestUsers(ctx, kvStore); err != nil {
+		t.Fatalf("expected self test to pass on a healthy store: %v", err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		users, err := store.ListUsers(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		expected := []*influxdb.User{{ID: 1, Name: "user1", Status: "active"}}
+		if !reflect.DeepEqual(users, expected) {
+			t.Fatalf("expected self test to leave no users behind: \n%+v\n%+v", users, expected)
+		}
+
+		if _, err := store.GetUserByName(ctx, tx, "__tenant_selftest__"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected self test to leave no index entry behind, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserSelfTestGuardedStores(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	guarded, err := tenant.NewStore(kvStore, tenant.WithPreventLastUserDeletion())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty store would otherwise refuse to delete its only user
+	if err := guarded.SelfTestUsers(ctx, kvStore); err != nil {
+		t.Fatalf("expected self test to pass on an empty guarded store: %v", err)
+	}
+
+	// the throwaway user's name is outside the scope
+	if err := guarded.ScopedByPrefix("team-").SelfTestUsers(ctx, kvStore); err != nil {
+		t.Fatalf("expected self test to pass on a scoped store: %v", err)
+	}
+}
+
+func TestUserCustomIDEncoder(t *testing.T) {
+	ctx := context.Background()
+	encode := func(id influxdb.ID) ([]byte, error) {
+		if !id.Valid() {
+			return nil, influxdb.ErrInvalidID
+		}
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(id))
+		return []byte(base32.StdEncoding.EncodeToString(b)), nil
+	}
+	decode := func(v []byte) (influxdb.ID, error) {
+		b, err := base32.StdEncoding.DecodeString(string(v))
+		if err != nil {
+			return 0, err
+		}
+		return influxdb.ID(binary.BigEndian.Uint64(b)), nil
+	}
+
+	store, err := tenant.NewStore(inmem.NewKVStore(),
+		tenant.WithClock(mock.TimeGenerator{FakeValue: testUpdatedAt}),
+		tenant.WithIDEncoding(encode, decode),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 3; i++ {
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"})
+			if err != nil {
+				return err
+			}
+		}
+
+		name := "user20"
+		if _, err := store.UpdateUser(ctx, tx, 2, influxdb.UserUpdate{Name: &name}); err != nil {
+			return err
+		}
+		return store.DeleteUser(ctx, tx, 3)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		expected := &influxdb.User{ID: 2, Name: "user20", Status: "active", UpdatedAt: &testUpdatedAt}
+
+		u, err := store.GetUser(ctx, tx, 2)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(u, expected) {
+			t.Fatalf("expected identical user: \n%+v\n%+v", u, expected)
+		}
+
+		u, err = store.GetUserByName(ctx, tx, "user20")
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(u, expected) {
+			t.Fatalf("expected identical user: \n%+v\n%+v", u, expected)
+		}
+
+		if _, err := store.GetUser(ctx, tx, 3); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected deleted user to be gone, got: %v", err)
+		}
+
+		b, err := tx.Bucket([]byte("usersv1"))
+		if err != nil {
+			return err
+		}
+		key, _ := encode(2)
+		if _, err := b.Get(key); err != nil {
+			t.Fatalf("expected user to be stored under the base32 key %s: %v", key, err)
+		}
+
+		idx, err := tx.Bucket([]byte("userindexv1"))
+		if err != nil {
+			return err
+		}
+		v, err := idx.Get([]byte("user20"))
+		if err != nil {
+			return err
+		}
+		if string(v) != string(key) {
+			t.Fatalf("expected index to reference %s got: %s", key, v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserDuplicateIndexEntries(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zap.WarnLevel)
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithLogger(zap.New(core)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 3; i++ {
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"})
+			if err != nil {
+				return err
+			}
+		}
+
+		// a second name pointing at user2
+		idx, err := tx.Bucket([]byte("userindexv1"))
+		if err != nil {
+			return err
+		}
+		id, _ := influxdb.ID(2).Encode()
+		return idx.Put([]byte("user2b"), id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		users, err := store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name"})
+		if err != nil {
+			return err
+		}
+
+		expected := []*influxdb.User{
+			{ID: 1, Name: "user1", Status: "active"},
+			{ID: 2, Name: "user2", Status: "active"},
+			{ID: 3, Name: "user3", Status: "active"},
+		}
+		if !reflect.DeepEqual(users, expected) {
+			t.Fatalf("expected each user once: \n%+v\n%+v", users, expected)
+		}
+
+		users, err = store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name", Offset: 2})
+		if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(users, expected[2:]) {
+			t.Fatalf("expected duplicates not to count towards the offset: \n%+v\n%+v", users, expected[2:])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := logs.FilterMessage("Duplicate user index entry").Len(); n != 2 {
+		t.Fatalf("expected a warning per listing with the duplicate, got: %d", n)
+	}
+}
+
+func TestTouchUser(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{}
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", OAuthID: "oauth1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, at := range []time.Time{testUpdatedAt, testUpdatedAt.Add(time.Hour)} {
+		clock.Set(at)
+		err = store.Update(ctx, func(tx kv.Tx) error {
+			return store.TouchUser(ctx, tx, 1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			at := at
+			expected := &influxdb.User{ID: 1, Name: "user1", OAuthID: "oauth1", Status: "active", UpdatedAt: &at}
+
+			u, err := store.GetUser(ctx, tx, 1)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(u, expected) {
+				t.Fatalf("expected only UpdatedAt to change: \n%+v\n%+v", u, expected)
+			}
+
+			u, err = store.GetUserByName(ctx, tx, "user1")
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(u, expected) {
+				t.Fatalf("expected index to be untouched: \n%+v\n%+v", u, expected)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.TouchUser(ctx, tx, 2); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected user not found touching a missing user, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserStrictStatus(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "actve"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("lenient", func(t *testing.T) {
+		err := store.View(ctx, func(tx kv.Tx) error {
+			u, err := store.GetUser(ctx, tx, 2)
+			if err != nil {
+				t.Fatalf("expected lenient read to succeed: %v", err)
+			}
+			if u.Status != "actve" {
+				t.Fatalf("expected stored status to pass through got: %q", u.Status)
+			}
+
+			users, err := store.ListUsers(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if len(users) != 2 {
+				t.Fatalf("expected 2 users got: %d", len(users))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		store, err := tenant.NewStore(kvStore, tenant.WithStrictStatus())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			if _, err := store.GetUser(ctx, tx, 2); influxdb.ErrorOp(err) != "kv/UnmarshalUser" {
+				t.Fatalf("expected corrupt user error, got: %v", err)
+			}
+
+			if _, err := store.GetUserByName(ctx, tx, "user2"); influxdb.ErrorOp(err) != "kv/UnmarshalUser" {
+				t.Fatalf("expected corrupt user error by name, got: %v", err)
+			}
+
+			if _, err := store.GetUser(ctx, tx, 1); err != nil {
+				t.Fatalf("expected valid user to be readable: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestListUsersDescending(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		users, err := store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name", Descending: true})
+		if err != nil {
+			return err
+		}
+		if len(users) != 0 {
+			t.Fatalf("expected no users on an empty store got: %d", len(users))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 25; i++ {
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%02d", 26-i), Status: "active"})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		all, err := store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name"})
+		if err != nil {
+			return err
+		}
+
+		last, err := store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name", Descending: true, Limit: 10})
+		if err != nil {
+			return err
+		}
+
+		// the reversed last page is the tail of the forward listing
+		tail := all[len(all)-10:]
+		for i := range last {
+			if !reflect.DeepEqual(last[i], tail[len(tail)-1-i]) {
+				t.Fatalf("expected reversed last page to match the tail: \n%+v\n%+v", last, tail)
+			}
+		}
+
+		next, err := store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name", Descending: true, Limit: 10, Offset: 10})
+		if err != nil {
+			return err
+		}
+		if next[0].Name != "user15" || next[9].Name != "user06" {
+			t.Fatalf("expected second reversed page user15..user06 got: %s..%s", next[0].Name, next[9].Name)
+		}
+
+		byID, err := store.ListUsers(ctx, tx, influxdb.FindOptions{Descending: true, Limit: 2})
+		if err != nil {
+			return err
+		}
+		if byID[0].ID != 25 || byID[1].ID != 24 {
+			t.Fatalf("expected descending id listing to start at the highest id got: %v, %v", byID[0].ID, byID[1].ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewStoreOptions(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zap.WarnLevel)
+	store, err := tenant.NewStore(inmem.NewKVStore(),
+		tenant.WithDefaultLimit(3),
+		tenant.WithLogger(zap.New(core)),
+		tenant.WithClock(mock.TimeGenerator{FakeValue: testUpdatedAt}),
+		tenant.WithNameValidator(func(name string) error {
+			if name == "root" {
+				return errors.New("reserved name")
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 5; i++ {
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"})
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 6, Name: "root", Status: "active"}); influxdb.ErrorCode(err) != influxdb.EInvalid {
+			t.Fatalf("expected validator to reject create, got: %v", err)
+		}
+
+		root := "root"
+		if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &root}); influxdb.ErrorCode(err) != influxdb.EInvalid {
+			t.Fatalf("expected validator to reject rename, got: %v", err)
+		}
+
+		u, err := store.UpdateUser(ctx, tx, 2, influxdb.UserUpdate{})
+		if err != nil {
+			return err
+		}
+		if u.UpdatedAt == nil || !u.UpdatedAt.Equal(testUpdatedAt) {
+			t.Fatalf("expected the configured clock to stamp UpdatedAt got: %v", u.UpdatedAt)
+		}
+
+		// leave a duplicate index entry so the logger has something to say
+		idx, err := tx.Bucket([]byte("userindexv1"))
+		if err != nil {
+			return err
+		}
+		id, _ := influxdb.ID(1).Encode()
+		return idx.Put([]byte("user1b"), id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		users, err := store.ListUsers(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if len(users) != 3 {
+			t.Fatalf("expected the default limit of 3 got: %d", len(users))
+		}
+
+		if _, err := store.GetUserByName(ctx, tx, "root"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected rejected user not to exist, got: %v", err)
+		}
+
+		_, err = store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if logs.Len() == 0 {
+		t.Fatal("expected the configured logger to be used")
+	}
+}
+
+type upperCodec struct{}
+
+func (upperCodec) Marshal(u *influxdb.User) ([]byte, error) {
+	return []byte(fmt.Sprintf("%d|%s|%s", u.ID, strings.ToUpper(u.Name), u.Status)), nil
+}
+
+func (upperCodec) Unmarshal(v []byte) (*influxdb.User, error) {
+	parts := strings.Split(string(v), "|")
+	if len(parts) != 3 {
+		return nil, errors.New("bad user")
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &influxdb.User{ID: influxdb.ID(id), Name: parts[1], Status: influxdb.Status(parts[2])}, nil
+}
+
+func TestNewStoreWithCodec(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithCodec(upperCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		u, err := store.GetUser(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+
+		expected := &influxdb.User{ID: 1, Name: "USER1", Status: "active"}
+		if !reflect.DeepEqual(u, expected) {
+			t.Fatalf("expected user decoded by the codec: \n%+v\n%+v", u, expected)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkUsersResume(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 10; i++ {
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var walked []influxdb.ID
+	errInterrupted := errors.New("interrupted")
+
+	var checkpoint influxdb.ID
+	err = store.View(ctx, func(tx kv.Tx) error {
+		checkpoint, err = store.WalkUsers(ctx, tx, 0, func(u *influxdb.User) error {
+			if u.ID == 5 {
+				return errInterrupted
+			}
+			walked = append(walked, u.ID)
+			return nil
+		})
+		return err
+	})
+	if err != errInterrupted {
+		t.Fatalf("expected interrupted walk, got: %v", err)
+	}
+	if checkpoint != 4 {
+		t.Fatalf("expected checkpoint at last processed user 4, got: %v", checkpoint)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		checkpoint, err = store.WalkUsers(ctx, tx, checkpoint, func(u *influxdb.User) error {
+			walked = append(walked, u.ID)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint != 10 {
+		t.Fatalf("expected checkpoint at final user 10, got: %v", checkpoint)
+	}
+
+	expected := []influxdb.ID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("expected every user exactly once: \n%v\n%v", walked, expected)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	err = store.View(ctx, func(tx kv.Tx) error {
+		checkpoint, err = store.WalkUsers(cctx, tx, 0, func(u *influxdb.User) error {
+			if u.ID == 3 {
+				cancel()
+			}
+			return nil
+		})
+		return err
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected canceled walk, got: %v", err)
+	}
+	if checkpoint != 3 {
+		t.Fatalf("expected checkpoint at user 3 after cancel, got: %v", checkpoint)
+	}
+}
+
+func TestUserSchemaValidator(t *testing.T) {
+	ctx := context.Background()
+	requireOAuthID := func(raw []byte) error {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		if _, ok := doc["oauthID"]; !ok {
+			return errors.New("missing required field oauthID")
+		}
+		return nil
+	}
+
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithSchemaValidator(requireOAuthID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+		if influxdb.ErrorCode(err) != influxdb.EUnprocessableEntity {
+			t.Fatalf("expected unprocessable entity for missing oauthID, got: %v", err)
+		}
+
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", OAuthID: "oauth2", Status: "active"}); err != nil {
+			return err
+		}
+
+		name := "user20"
+		_, err = store.UpdateUser(ctx, tx, 2, influxdb.UserUpdate{Name: &name})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.GetUser(ctx, tx, 1); influxdb.ErrorCode(err) != influxdb.ENotFound {
+			t.Fatalf("expected rejected user to not be stored, got: %v", err)
+		}
+		if _, err := store.GetUserByName(ctx, tx, "user20"); err != nil {
+			t.Fatalf("expected valid user to be renamed: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetUserInto(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", OAuthID: "oauth1", Status: "active"}); err != nil {
+			return err
+		}
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "inactive"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		var u influxdb.User
+		if err := store.GetUserInto(ctx, tx, 1, &u); err != nil {
+			return err
+		}
+		if expected := (influxdb.User{ID: 1, Name: "user1", OAuthID: "oauth1", Status: "active"}); !reflect.DeepEqual(u, expected) {
+			t.Fatalf("expected identical user: \n%+v\n%+v", u, expected)
+		}
+
+		// fields missing from the second user must not leak from the first
+		if err := store.GetUserInto(ctx, tx, 2, &u); err != nil {
+			return err
+		}
+		if expected := (influxdb.User{ID: 2, Name: "user2", Status: "inactive"}); !reflect.DeepEqual(u, expected) {
+			t.Fatalf("expected reused user to be reset: \n%+v\n%+v", u, expected)
+		}
+
+		if err := store.GetUserInto(ctx, tx, 3, &u); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected user not found, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func benchmarkGetUser(b *testing.B, get func(ctx context.Context, store *tenant.Store, tx kv.Tx) error) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", OAuthID: "oauth1", Status: "active"})
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := get(ctx, store, tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkGetUser(b *testing.B) {
+	benchmarkGetUser(b, func(ctx context.Context, store *tenant.Store, tx kv.Tx) error {
+		_, err := store.GetUser(ctx, tx, 1)
+		return err
+	})
+}
+
+func BenchmarkGetUserInto(b *testing.B) {
+	var u influxdb.User
+	benchmarkGetUser(b, func(ctx context.Context, store *tenant.Store, tx kv.Tx) error {
+		return store.GetUserInto(ctx, tx, 1, &u)
+	})
+}
+
+func TestCanRenameUser(t *testing.T) {
+	ctx := context.Background()
+	reserved := func(name string) error {
+		if name == "admin" {
+			return errors.New("name is reserved")
+		}
+		return nil
+	}
+
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithNameValidator(reserved))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if err := store.CanRenameUser(ctx, tx, 1, "free"); err != nil {
+			t.Fatalf("expected free name to be allowed: %v", err)
+		}
+
+		if err := store.CanRenameUser(ctx, tx, 1, "user2"); err != kv.NotUniqueError {
+			t.Fatalf("expected taken name to be rejected, got: %v", err)
+		}
+
+		if err := store.CanRenameUser(ctx, tx, 1, "admin"); influxdb.ErrorCode(err) != influxdb.EInvalid {
+			t.Fatalf("expected reserved name to be invalid, got: %v", err)
+		}
+
+		if err := store.CanRenameUser(ctx, tx, 3, "free"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected unknown user to be not found, got: %v", err)
+		}
+
+		u, err := store.GetUser(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if u.Name != "user1" {
+			t.Fatalf("expected preview to leave the user untouched got: %q", u.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserReadOnlyTransaction(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "user2", Status: "active"}); err != tenant.ErrReadOnlyTransaction {
+			t.Fatalf("expected read only error on create, got: %v", err)
+		}
+
+		name := "user10"
+		if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name}); err != tenant.ErrReadOnlyTransaction {
+			t.Fatalf("expected read only error on update, got: %v", err)
+		}
+
+		if err := store.DeleteUser(ctx, tx, 1); err != tenant.ErrReadOnlyTransaction {
+			t.Fatalf("expected read only error on delete, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lossyTx drops every write made to the named bucket.
+type lossyTx struct {
+	kv.Tx
+	bucket string
+}
+
+func (tx lossyTx) Bucket(b []byte) (kv.Bucket, error) {
+	bkt, err := tx.Tx.Bucket(b)
+	if err != nil || string(b) != tx.bucket {
+		return bkt, err
+	}
+	return lossyBucket{bkt}, nil
+}
+
+type lossyBucket struct {
+	kv.Bucket
+}
+
+func (lossyBucket) Put(_, _ []byte) error { return nil }
+
+func TestUserVerifyWrites(t *testing.T) {
+	for _, bucket := range []string{"usersv1", "userindexv1"} {
+		t.Run(bucket, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithVerifyWrites())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+					t.Fatalf("expected a faithful write to verify: %v", err)
+				}
+
+				err := store.CreateUser(ctx, lossyTx{Tx: tx, bucket: bucket}, &influxdb.User{ID: 2, Name: "user2", Status: "active"})
+				if influxdb.ErrorCode(err) != influxdb.EInternal {
+					t.Fatalf("expected lost create to fail verification, got: %v", err)
+				}
+
+				name := "user10"
+				_, err = store.UpdateUser(ctx, lossyTx{Tx: tx, bucket: bucket}, 1, influxdb.UserUpdate{Name: &name})
+				if influxdb.ErrorCode(err) != influxdb.EInternal {
+					t.Fatalf("expected lost update to fail verification, got: %v", err)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUserVerifyWritesRealClock(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithVerifyWrites())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+
+		inactive := influxdb.Status("inactive")
+		u, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Status: &inactive})
+		if err != nil {
+			t.Fatalf("expected an update stamped by the real clock to verify: %v", err)
+		}
+
+		got, err := store.GetUser(ctx, tx, 1)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(got, u) {
+			t.Fatalf("expected the updated user to read back unchanged: \n%+v\n%+v", got, u)
+		}
+
+		if err := store.RenameUsers(ctx, tx, map[influxdb.ID]string{1: "user10"}); err != nil {
+			t.Fatalf("expected a rename stamped by the real clock to verify: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetUsersBatchCanceled(t *testing.T) {
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]influxdb.ID, 10000)
+	names := make([]string, len(ids))
+	for i := range ids {
+		ids[i] = influxdb.ID(i + 1)
+		names[i] = fmt.Sprintf("user%d", i+1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = store.View(context.Background(), func(tx kv.Tx) error {
+		if _, err := store.GetUsersByIDs(ctx, tx, ids); err != context.Canceled {
+			t.Fatalf("expected canceled lookup by ids, got: %v", err)
+		}
+
+		if _, err := store.GetUsersByNames(ctx, tx, names); err != context.Canceled {
+			t.Fatalf("expected canceled lookup by names, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserReadBeforeWrite(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+
+	// NewStore creates the buckets, building a second store on the same kv
+	// store must find them already there
+	for i := 0; i < 2; i++ {
+		store, err := tenant.NewStore(kvStore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.View(ctx, func(tx kv.Tx) error {
+			if _, err := store.GetUserByName(ctx, tx, "user1"); err != tenant.ErrUserNotFound {
+				t.Fatalf("expected user not found on a fresh store, got: %v", err)
+			}
+
+			users, err := store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name"})
+			if err != nil {
+				t.Fatalf("expected listing a fresh store to succeed: %v", err)
+			}
+			if len(users) != 0 {
+				t.Fatalf("expected no users got: %d", len(users))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUserCaseInsensitiveNames(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithCaseInsensitiveNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "Alice", Status: "active"}); err != nil {
+			return err
+		}
+
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "alice", Status: "active"}); err != kv.NotUniqueError {
+			t.Fatalf("expected names differing in case to collide, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		u, err := store.GetUserByName(ctx, tx, "ALICE")
+		if err != nil {
+			return err
+		}
+		if expected := (&influxdb.User{ID: 1, Name: "Alice", Status: "active"}); !reflect.DeepEqual(u, expected) {
+			t.Fatalf("expected the stored name to be kept: \n%+v\n%+v", u, expected)
+		}
+
+		users, err := store.GetUsersByNames(ctx, tx, []string{"aLiCe"})
+		if err != nil {
+			return err
+		}
+		if users["aLiCe"] == nil || users["aLiCe"].ID != 1 {
+			t.Fatalf("expected batch lookup to fold names got: %+v", users)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListUsersWithMeta(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts    []influxdb.FindOptions
+		clamped bool
+	}{
+		{opts: nil},
+		{opts: []influxdb.FindOptions{{Limit: influxdb.MaxPageSize}}},
+		{opts: []influxdb.FindOptions{{Limit: 10000}}, clamped: true},
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		for _, tt := range tests {
+			users, meta, err := store.ListUsersWithMeta(ctx, tx, tt.opts...)
+			if err != nil {
+				return err
+			}
+			if len(users) != 1 {
+				t.Fatalf("expected 1 user got: %d", len(users))
+			}
+			if meta.LimitClamped != tt.clamped {
+				t.Errorf("expected clamped %v for %+v got: %v", tt.clamped, tt.opts, meta.LimitClamped)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserNameValidationErrors(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithReservedUserNames("admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected error
+	}{
+		{name: "", expected: tenant.ErrUserNameRequired},
+		{name: strings.Repeat("a", tenant.MaxUserNameLength+1), expected: tenant.ErrUserNameTooLong},
+		{name: "admin", expected: tenant.ErrUserNameReserved},
+		{name: "bad\nname", expected: tenant.ErrUserNameInvalidChars},
+		{name: "bad\xffname", expected: tenant.ErrUserNameInvalidChars},
+		{name: "bad" + string(tenant.UserIndexKeyDelimiter) + "name", expected: tenant.ErrUserNameInvalidChars},
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: strings.Repeat("a", tenant.MaxUserNameLength), Status: "active"}); err != nil {
+			t.Fatalf("expected a name of the maximum length to be accepted: %v", err)
+		}
+
+		for i, tt := range tests {
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 2), Name: tt.name, Status: "active"})
+			if err != tt.expected {
+				t.Errorf("expected %v creating %q got: %v", tt.expected, tt.name, err)
+			}
+			if !errors.Is(err, tenant.ErrUnprocessableUserName) {
+				t.Errorf("expected %v to match the unprocessable user name base", err)
+			}
+			if influxdb.ErrorCode(err) != influxdb.EUnprocessableEntity {
+				t.Errorf("expected unprocessable entity code got: %s", influxdb.ErrorCode(err))
+			}
+
+			name := tt.name
+			if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name}); err != tt.expected {
+				t.Errorf("expected %v renaming to %q got: %v", tt.expected, tt.name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserNameValidatorErrors(t *testing.T) {
+	ctx := context.Background()
+	errReserved := errors.New("name is reserved")
+	errForbidden := &influxdb.Error{Code: influxdb.EForbidden, Msg: "name is forbidden"}
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithNameValidator(func(name string) error {
+		switch name {
+		case "root":
+			return errReserved
+		case "admin":
+			return errForbidden
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected error
+		code     string
+	}{
+		{name: "root", expected: errReserved, code: influxdb.EInvalid},
+		{name: "admin", expected: errForbidden, code: influxdb.EForbidden},
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i, tt := range tests {
+			err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 1), Name: tt.name, Status: "active"})
+			if !errors.Is(err, tenant.ErrUnprocessableUserName) {
+				t.Errorf("expected %v to match the unprocessable user name base", err)
+			}
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v to match the validator's error %v", err, tt.expected)
+			}
+			if influxdb.ErrorCode(err) != tt.code {
+				t.Errorf("expected code %s creating %q got: %s", tt.code, tt.name, influxdb.ErrorCode(err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestViewUsers(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	store, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 3; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(chan error, 1)
+	err = store.ViewUsers(ctx, kvStore, func(tx kv.Tx) error {
+		users, err := store.ListUsers(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		// a concurrent rename must not show up part way through the reads
+		go func() {
+			written <- store.Update(ctx, func(tx kv.Tx) error {
+				name := "renamed"
+				_, err := store.UpdateUser(ctx, tx, 2, influxdb.UserUpdate{Name: &name})
+				return err
+			})
+		}()
+
+		for _, listed := range users {
+			u, err := store.GetUser(ctx, tx, listed.ID)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(u, listed) {
+				t.Fatalf("expected reads to share a snapshot: \n%+v\n%+v", u, listed)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.ViewUsers(ctx, kvStore, func(tx kv.Tx) error {
+		u, err := store.GetUser(ctx, tx, 2)
+		if err != nil {
+			return err
+		}
+		if u.Name != "renamed" {
+			t.Fatalf("expected the rename to be visible to a later snapshot got: %q", u.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCountUsersWhere(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		users := []*influxdb.User{
+			{ID: 1, Name: "ops-amy", Status: "active"},
+			{ID: 2, Name: "ops-bob", Status: "inactive"},
+			{ID: 3, Name: "ops-cat", Status: "active"},
+			{ID: 4, Name: "dev-dan", Status: "active"},
+			{ID: 5, Name: "opsx", Status: "inactive"},
+		}
+		for _, u := range users {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active := influxdb.Active
+	tests := []struct {
+		name       string
+		filter     tenant.UserFilter
+		count      int
+		readsUsers bool
+	}{
+		{name: "all", filter: tenant.UserFilter{}, count: 5},
+		{name: "prefix", filter: tenant.UserFilter{NamePrefix: "ops-"}, count: 3},
+		{name: "prefix excluding", filter: tenant.UserFilter{NamePrefix: "ops-", ExcludeIDs: []influxdb.ID{3}}, count: 2},
+		{name: "status", filter: tenant.UserFilter{Status: &active}, count: 3, readsUsers: true},
+		{name: "prefix and status", filter: tenant.UserFilter{NamePrefix: "ops", Status: &active}, count: 2, readsUsers: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.View(ctx, func(tx kv.Tx) error {
+				counting := &countingTx{Tx: tx, opened: map[string]int{}}
+				n, err := store.CountUsersWhere(ctx, counting, tt.filter)
+				if err != nil {
+					return err
+				}
+				if n != tt.count {
+					t.Fatalf("expected %d users got: %d", tt.count, n)
+				}
+				if read := counting.opened["usersv1"] > 0; read != tt.readsUsers {
+					t.Fatalf("expected users read %v got: %v", tt.readsUsers, read)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// repeatingTx returns cursors over the named bucket that yield the key
+// following the first one twice.
+type repeatingTx struct {
+	kv.Tx
+	bucket string
+}
+
+func (tx repeatingTx) Bucket(b []byte) (kv.Bucket, error) {
+	bkt, err := tx.Tx.Bucket(b)
+	if err != nil || string(b) != tx.bucket {
+		return bkt, err
+	}
+	return repeatingBucket{bkt}, nil
+}
+
+type repeatingBucket struct {
+	kv.Bucket
+}
+
+func (b repeatingBucket) ForwardCursor(seek []byte, opts ...kv.CursorOption) (kv.ForwardCursor, error) {
+	c, err := b.Bucket.ForwardCursor(seek, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &repeatingCursor{ForwardCursor: c}, nil
+}
+
+type repeatingCursor struct {
+	kv.ForwardCursor
+	n    int
+	k, v []byte
+}
+
+func (c *repeatingCursor) Next() ([]byte, []byte) {
+	c.n++
+	if c.n == 3 {
+		return c.k, c.v
+	}
+	c.k, c.v = c.ForwardCursor.Next()
+	return c.k, c.v
+}
+
+func TestListUsersDeduplication(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dedup=%v", dedup), func(t *testing.T) {
+			ctx := context.Background()
+
+			var opts []tenant.StoreOption
+			if dedup {
+				opts = append(opts, tenant.WithListDeduplication())
+			}
+			store, err := tenant.NewStore(inmem.NewKVStore(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				for i := 1; i <= 3; i++ {
+					if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.View(ctx, func(tx kv.Tx) error {
+				us, err := store.ListUsers(ctx, repeatingTx{Tx: tx, bucket: "usersv1"})
+				if err != nil {
+					return err
+				}
+
+				var ids []influxdb.ID
+				for _, u := range us {
+					ids = append(ids, u.ID)
+				}
+
+				expected := []influxdb.ID{1, 2, 2, 3}
+				if dedup {
+					expected = []influxdb.ID{1, 2, 3}
+				}
+				if !reflect.DeepEqual(ids, expected) {
+					t.Fatalf("expected listed ids to match: \n%+v\n%+v", expected, ids)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNameCollation(t *testing.T) {
+	names := []string{"zebra", "äpple", "apple", "Banana"}
+
+	tests := []struct {
+		name     string
+		opts     []tenant.StoreOption
+		expected []string
+	}{
+		{
+			name:     "byte order",
+			expected: []string{"Banana", "apple", "zebra", "äpple"},
+		},
+		{
+			name:     "english",
+			opts:     []tenant.StoreOption{tenant.WithNameCollation(language.English)},
+			expected: []string{"apple", "äpple", "Banana", "zebra"},
+		},
+		{
+			name:     "swedish",
+			opts:     []tenant.StoreOption{tenant.WithNameCollation(language.Swedish)},
+			expected: []string{"apple", "Banana", "zebra", "äpple"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := tenant.NewStore(inmem.NewKVStore(), tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				for i, n := range names {
+					if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 1), Name: n, Status: "active"}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.View(ctx, func(tx kv.Tx) error {
+				us, err := store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name"})
+				if err != nil {
+					return err
+				}
+
+				var listed []string
+				for _, u := range us {
+					listed = append(listed, u.Name)
+				}
+				if !reflect.DeepEqual(listed, tt.expected) {
+					t.Fatalf("expected names in collation order: \n%+v\n%+v", tt.expected, listed)
+				}
+
+				u, err := store.GetUserByName(ctx, tx, "äpple")
+				if err != nil {
+					return err
+				}
+				if u.ID != 2 {
+					t.Fatalf("expected lookup by name to find user 2, got: %v", u.ID)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestListUsersMaxScan(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithMaxScan(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 10; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%02d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		us, err := store.ListUsers(ctx, tx, influxdb.FindOptions{Limit: 5})
+		if err != nil {
+			t.Fatalf("expected a listing within the scan limit to succeed: %v", err)
+		}
+		if len(us) != 5 {
+			t.Fatalf("expected 5 users got: %d", len(us))
+		}
+
+		for _, sortBy := range []string{"id", "name"} {
+			_, err := store.ListUsers(ctx, tx, influxdb.FindOptions{Limit: 2, Offset: 6, SortBy: sortBy})
+			if err != tenant.ErrScanLimitExceeded {
+				t.Fatalf("expected an offset past the scan limit to fail sorted by %s, got: %v", sortBy, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateUserMissing(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		name := "user1"
+		upd := influxdb.UserUpdate{Name: &name}
+
+		_, err := store.UpdateUser(ctx, tx, influxdb.InvalidID(), upd)
+		if influxdb.ErrorCode(err) != influxdb.EInvalid {
+			t.Fatalf("expected updating an invalid id to fail as invalid, got: %v", err)
+		}
+		if derr := store.DeleteUser(ctx, tx, influxdb.InvalidID()); influxdb.ErrorCode(derr) != influxdb.ErrorCode(err) {
+			t.Fatalf("expected update and delete to agree on an invalid id: \n%v\n%v", err, derr)
+		}
+
+		if _, err := store.UpdateUser(ctx, tx, 42, upd); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected updating a missing user to fail with not found, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetUserStats(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		statuses := []influxdb.Status{influxdb.Active, influxdb.Active, influxdb.Inactive, influxdb.Active, influxdb.Inactive, influxdb.Active}
+		for i, st := range statuses {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 1), Name: fmt.Sprintf("user%d", i+1), Status: st}); err != nil {
+				return err
+			}
+		}
+		// one active and one inactive user are soft deleted
+		if err := store.SoftDeleteUser(ctx, tx, 1); err != nil {
+			return err
+		}
+		return store.SoftDeleteUser(ctx, tx, 3)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		stats, err := store.GetUserStats(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		expected := tenant.UserStats{
+			Total:       4,
+			ByStatus:    map[influxdb.Status]int{influxdb.Active: 3, influxdb.Inactive: 1},
+			SoftDeleted: 2,
+		}
+		if !reflect.DeepEqual(stats, expected) {
+			t.Fatalf("expected user stats to match: \n%+v\n%+v", expected, stats)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalUniquenessChecker(t *testing.T) {
+	ctx := context.Background()
+
+	var asked []string
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithExternalUniquenessChecker(func(ctx context.Context, name string) (bool, error) {
+		asked = append(asked, name)
+		return name == "remote", nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"}); err != nil {
+			return err
+		}
+
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 2, Name: "remote", Status: "active"}); err != tenant.ErrUserNameTaken {
+			t.Fatalf("expected a name taken externally to be rejected on create, got: %v", err)
+		}
+
+		name := "remote"
+		if _, err := store.UpdateUser(ctx, tx, 1, influxdb.UserUpdate{Name: &name}); err != tenant.ErrUserNameTaken {
+			t.Fatalf("expected a name taken externally to be rejected on rename, got: %v", err)
+		}
+
+		// the local index answers first
+		if err := store.CreateUser(ctx, tx, &influxdb.User{ID: 3, Name: "user1", Status: "active"}); err == nil || err == tenant.ErrUserNameTaken {
+			t.Fatalf("expected a locally taken name to fail the local check, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"user1", "remote", "remote"}
+	if !reflect.DeepEqual(asked, expected) {
+		t.Fatalf("expected the external authority to be asked only about locally unique names: \n%+v\n%+v", expected, asked)
+	}
+}
+
+func TestListUserNamesOnly(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []tenant.StoreOption
+	}{
+		{name: "byte order"},
+		{name: "collated", opts: []tenant.StoreOption{tenant.WithNameCollation(language.English)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := tenant.NewStore(inmem.NewKVStore(), tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Update(ctx, func(tx kv.Tx) error {
+				for i, n := range []string{"carol", "alice", "erin", "bob", "dave"} {
+					if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 1), Name: n, Status: "active"}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.View(ctx, func(tx kv.Tx) error {
+				for _, c := range []struct {
+					opt      influxdb.FindOptions
+					expected []string
+				}{
+					{opt: influxdb.FindOptions{}, expected: []string{"alice", "bob", "carol", "dave", "erin"}},
+					{opt: influxdb.FindOptions{Limit: 2}, expected: []string{"alice", "bob"}},
+					{opt: influxdb.FindOptions{Limit: 2, Offset: 2}, expected: []string{"carol", "dave"}},
+					{opt: influxdb.FindOptions{Limit: 2, Offset: 4}, expected: []string{"erin"}},
+					{opt: influxdb.FindOptions{Offset: 5}, expected: []string{}},
+				} {
+					names, err := store.ListUserNamesOnly(ctx, tx, c.opt)
+					if err != nil {
+						return err
+					}
+					if !reflect.DeepEqual(names, c.expected) {
+						t.Fatalf("expected names for %+v: \n%+v\n%+v", c.opt, c.expected, names)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// slowTx delays every read from the named bucket.
+type slowTx struct {
+	kv.Tx
+	bucket string
+	delay  time.Duration
+}
+
+func (tx slowTx) Bucket(b []byte) (kv.Bucket, error) {
+	bkt, err := tx.Tx.Bucket(b)
+	if err != nil || string(b) != tx.bucket {
+		return bkt, err
+	}
+	return slowBucket{Bucket: bkt, delay: tx.delay}, nil
+}
+
+type slowBucket struct {
+	kv.Bucket
+	delay time.Duration
+}
+
+func (b slowBucket) Get(key []byte) ([]byte, error) {
+	time.Sleep(b.delay)
+	return b.Bucket.Get(key)
+}
+
+func TestUserSlowLog(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zap.WarnLevel)
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithLogger(zap.New(core)), tenant.WithSlowThreshold(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.GetUserByName(ctx, tx, "user1"); err != nil {
+			return err
+		}
+		if n := logs.FilterMessage("Slow user operation").Len(); n != 0 {
+			t.Fatalf("expected no slow log for a fast read, got %d", n)
+		}
+
+		_, err := store.GetUser(ctx, slowTx{Tx: tx, bucket: "usersv1", delay: 20 * time.Millisecond}, 1)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := logs.FilterMessage("Slow user operation").All()
+	if len(slow) != 1 {
+		t.Fatalf("expected one slow log got %d", len(slow))
+	}
+
+	fields := slow[0].ContextMap()
+	if fields["method"] != "GetUser" || fields["id"] != influxdb.ID(1).String() {
+		t.Fatalf("expected the slow log to name the method and id, got: %v", fields)
+	}
+	if d, ok := fields["duration"].(time.Duration); !ok || d < 20*time.Millisecond {
+		t.Fatalf("expected the slow log to carry the duration, got: %v", fields["duration"])
+	}
+}
+
+func TestListUsersByName(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i, n := range []string{"carol", "alice", "bob"} {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 1), Name: n, Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		us, err := store.ListUsersByName(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		expected := map[string]*influxdb.User{
+			"alice": {ID: 2, Name: "alice", Status: "active"},
+			"bob":   {ID: 3, Name: "bob", Status: "active"},
+			"carol": {ID: 1, Name: "carol", Status: "active"},
+		}
+		if !reflect.DeepEqual(us, expected) {
+			t.Fatalf("expected users keyed by name: \n%+v\n%+v", expected, us)
+		}
+
+		us, err = store.ListUsersByName(ctx, tx, influxdb.FindOptions{Limit: 2, Offset: 1})
+		if err != nil {
+			return err
+		}
+		if len(us) != 2 || us["bob"] == nil || us["carol"] == nil {
+			t.Fatalf("expected the page to hold bob and carol, got: %+v", us)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserCorruptRecordPolicy(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zap.WarnLevel)
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithLogger(zap.New(core)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 2; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+
+		// a name pointing at a user that isn't there
+		idx, err := tx.Bucket([]byte("userindexv1"))
+		if err != nil {
+			return err
+		}
+		id, _ := influxdb.ID(9).Encode()
+		return idx.Put([]byte("user9"), id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*influxdb.User{
+		{ID: 1, Name: "user1", Status: "active"},
+		{ID: 2, Name: "user2", Status: "active"},
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		byName, err := store.ListUsersByName(ctx, tx)
+		if err != nil {
+			t.Fatalf("expected the dangling entry to be skipped: %v", err)
+		}
+		if !reflect.DeepEqual(byName, map[string]*influxdb.User{"user1": expected[0], "user2": expected[1]}) {
+			t.Fatalf("expected only the stored users: %+v", byName)
+		}
+
+		users, err := store.ListUsers(ctx, tx, influxdb.FindOptions{SortBy: "name"})
+		if err != nil {
+			t.Fatalf("expected the dangling entry to be skipped: %v", err)
+		}
+		if !reflect.DeepEqual(users, expected) {
+			t.Fatalf("expected only the stored users: \n%+v\n%+v", users, expected)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := logs.FilterMessage("Dangling user index entry").Len(); n != 2 {
+		t.Fatalf("expected a warning from each listing, got %d", n)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		b, err := tx.Bucket([]byte("usersv1"))
+		if err != nil {
+			return err
+		}
+		id, _ := influxdb.ID(2).Encode()
+		return b.Put(id, []byte("{not json"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		if _, err := store.FindUsers(ctx, tx, tenant.UserFilter{}); influxdb.ErrorCode(err) != influxdb.EInternal {
+			t.Fatalf("expected the corrupt user to fail the scan, got: %v", err)
+		}
+		if _, err := store.ListUsersByName(ctx, tx); influxdb.ErrorCode(err) != influxdb.EInternal {
+			t.Fatalf("expected the corrupt user to fail the name listing, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindUsersInIDRange(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 10; i++ {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		lo, hi   influxdb.ID
+		opt      []influxdb.FindOptions
+		expected []influxdb.ID
+	}{
+		{name: "inclusive lo exclusive hi", lo: 3, hi: 6, expected: []influxdb.ID{3, 4, 5}},
+		{name: "hi past the last user", lo: 9, hi: 100, expected: []influxdb.ID{9, 10}},
+		{name: "single id", lo: 4, hi: 5, expected: []influxdb.ID{4}},
+		{name: "paged", lo: 2, hi: 9, opt: []influxdb.FindOptions{{Limit: 2, Offset: 3}}, expected: []influxdb.ID{5, 6}},
+		{name: "empty", lo: 5, hi: 5, expected: []influxdb.ID{}},
+		{name: "lo after hi", lo: 7, hi: 3, expected: []influxdb.ID{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.View(ctx, func(tx kv.Tx) error {
+				us, err := store.FindUsersInIDRange(ctx, tx, tt.lo, tt.hi, tt.opt...)
+				if err != nil {
+					return err
+				}
+
+				ids := []influxdb.ID{}
+				for _, u := range us {
+					ids = append(ids, u.ID)
+				}
+				if !reflect.DeepEqual(ids, tt.expected) {
+					t.Fatalf("expected ids in range: \n%+v\n%+v", tt.expected, ids)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPreventLastUserDeletion(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithPreventLastUserDeletion())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for _, u := range []*influxdb.User{
+			{ID: 1, Name: "user1", Status: "active"},
+			{ID: 2, Name: "user2", Status: "active"},
+		} {
+			if err := store.CreateUser(ctx, tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.DeleteUser(ctx, tx, 1)
+	})
+	if err != nil {
+		t.Fatalf("expected deleting the penultimate user to succeed: %v", err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.DeleteUser(ctx, tx, 2)
+	})
+	if err != tenant.ErrCannotDeleteLastUser {
+		t.Fatalf("expected deleting the last user to be blocked, got: %v", err)
+	}
+
+	err = store.View(ctx, func(tx kv.Tx) error {
+		_, err := store.GetUser(ctx, tx, 2)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected the last user to remain: %v", err)
+	}
+}
+
+func TestNextAvailableName(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() string {
+		t.Helper()
+		var n string
+		err := store.View(ctx, func(tx kv.Tx) error {
+			var err error
+			n, err = store.NextAvailableName(ctx, tx, "user-", 4)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if n := next(); n != "user-0001" {
+		t.Fatalf("expected the first name in an empty store, got: %s", n)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		for i, n := range []string{"user-0001", "user-0002", "user-0009", "user-admin", "user-", "other-0042"} {
+			if err := store.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i + 1), Name: n, Status: "active"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the gaps between 2 and 9 are not filled
+	if n := next(); n != "user-0010" {
+		t.Fatalf("expected the name after the highest number: \n%s\n%s", n, "user-0010")
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 100, Name: "user-12345", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// numbers wider than the padding still count by value
+	if n := next(); n != "user-12346" {
+		t.Fatalf("expected the name after the widest number: \n%s\n%s", n, "user-12346")
+	}
+}
+
+func TestCreateUserIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &influxdb.User{ID: 1, Name: "user1", Status: "active"}
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		created, got, err := store.CreateUserIfAbsent(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		if !created || !reflect.DeepEqual(got, user) {
+			t.Fatalf("expected a free name to create the user: \n%v %+v", created, got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		created, got, err := store.CreateUserIfAbsent(ctx, tx, &influxdb.User{ID: 2, Name: "user1", Status: "inactive"})
+		if err != nil {
+			t.Fatalf("expected a taken name not to fail: %v", err)
+		}
+		if created || !reflect.DeepEqual(got, user) {
+			t.Fatalf("expected a taken name to return the existing user: \n%v %+v\n%+v", created, got, user)
+		}
+
+		if _, err := store.GetUser(ctx, tx, 2); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected nothing to be created for a taken name, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareAndSwapStatus(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "inactive"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		expect   influxdb.Status
+		next     influxdb.Status
+		swapped  bool
+		expected influxdb.Status
+	}{
+		{name: "match", expect: "inactive", next: "active", swapped: true, expected: "active"},
+		{name: "stale expectation", expect: "inactive", next: "active", swapped: false, expected: "active"},
+		{name: "mismatch", expect: "inactive", next: "inactive", swapped: false, expected: "active"},
+		{name: "swap back", expect: "active", next: "inactive", swapped: true, expected: "inactive"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.Update(ctx, func(tx kv.Tx) error {
+				swapped, err := store.CompareAndSwapStatus(ctx, tx, 1, tt.expect, tt.next)
+				if err != nil {
+					return err
+				}
+				if swapped != tt.swapped {
+					t.Fatalf("expected swap to be reported: \n%v\n%v", swapped, tt.swapped)
+				}
+
+				u, err := store.GetUser(ctx, tx, 1)
+				if err != nil {
+					return err
+				}
+				if u.Status != tt.expected {
+					t.Fatalf("expected status after compare and swap: \n%s\n%s", u.Status, tt.expected)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		if _, err := store.CompareAndSwapStatus(ctx, tx, 2, "active", "inactive"); err != tenant.ErrUserNotFound {
+			t.Fatalf("expected missing user to fail the swap, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnyUserExists(t *testing.T) {
+	ctx := context.Background()
+	store, err := tenant.NewStore(inmem.NewKVStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(s *tenant.Store, expected bool) {
+		t.Helper()
+		err := s.View(ctx, func(tx kv.Tx) error {
+			ok, err := s.AnyUserExists(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if ok != expected {
+				t.Fatalf("expected user existence: \n%v\n%v", ok, expected)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exists(store, false)
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.CreateUser(ctx, tx, &influxdb.User{ID: 1, Name: "user1", Status: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists(store, true)
+	exists(store.ScopedByPrefix("user"), true)
+	exists(store.ScopedByPrefix("team-a/"), false)
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		return store.DeleteUser(ctx, tx, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists(store, false)
+}
+
+func TestIDValidator(t *testing.T) {
+	ctx := context.Background()
+
+	errZeroID := errors.New("zero id")
+	errReserved := errors.New("reserved id")
+	store, err := tenant.NewStore(inmem.NewKVStore(), tenant.WithIDValidator(func(id influxdb.ID) error {
+		switch {
+		case id == 0:
+			return errZeroID
+		case id >= 1000 && id < 2000:
+			return errReserved
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		id       influxdb.ID
+		expected error
+	}{
+		{name: "zero", id: 0, expected: errZeroID},
+		{name: "reserved start", id: 1000, expected: errReserved},
+		{name: "reserved end", id: 1999, expected: errReserved},
+		{name: "below reserved", id: 999},
+		{name: "above reserved", id: 2000},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.Update(ctx, func(tx kv.Tx) error {
+				return store.CreateUser(ctx, tx, &influxdb.User{ID: tt.id, Name: tt.name, Status: "active"})
+			})
+			if tt.expected == nil {
+				if err != nil {
+					t.Fatalf("expected id to be accepted, got: %v", err)
+				}
+				return
+			}
+
+			if influxdb.ErrorCode(err) != influxdb.EInvalid || !errors.Is(err, tt.expected) {
+				t.Fatalf("expected id to be rejected as invalid: \n%v\n%v", err, tt.expected)
+			}
+		})
+	}
+
+	err = store.Update(ctx, func(tx kv.Tx) error {
+		err := store.CreateUsers(ctx, tx, []*influxdb.User{{ID: 1500, Name: "batched", Status: "active"}})
+		if !errors.Is(err, errReserved) {
+			t.Fatalf("expected batch create to validate ids, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
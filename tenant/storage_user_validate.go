@@ -0,0 +1,90 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/kv"
+)
+
+// UserValidationError is a stored user that fails the store's current
+// validation rules.
+type UserValidationError struct {
+	ID  influxdb.ID
+	Err error
+}
+
+func (e UserValidationError) Error() string {
+	return fmt.Sprintf("user %s: %v", e.ID, e.Err)
+}
+
+// ValidateAllUsers runs every stored user through the checks a write would
+// make today, the status, the name rules and validator, the label and field
+// index rules and the schema validator, and reports the users that fail them.
+// Users stored before those rules were configured are caught this way. A user
+// with an invalid status is reported whether or not the store checks status
+// strictly. Nothing is written.
+func (s *Store) ValidateAllUsers(ctx context.Context, tx kv.Tx) ([]UserValidationError, error) {
+	b, err := tx.Bucket(s.userBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := b.ForwardCursor(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	es := []UserValidationError{}
+	for k, v := cursor.Next(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if s.legacyLayout && s.isIndexEntry(v) {
+			continue
+		}
+
+		id, err := s.decodeID(k)
+		if err != nil {
+			return nil, ErrCorruptID(err)
+		}
+
+		u, err := s.unmarshalUser(v)
+		if err != nil {
+			es = append(es, UserValidationError{ID: id, Err: err})
+			continue
+		}
+
+		if !s.inScope(u.Name) {
+			continue
+		}
+
+		if err := s.validateUser(u); err != nil {
+			es = append(es, UserValidationError{ID: id, Err: err})
+		}
+	}
+
+	return es, cursor.Err()
+}
+
+// validateUser makes the checks createUser makes before writing u that
+// don't depend on the other users, along with the status check strict stores
+// make on read.
+func (s *Store) validateUser(u *influxdb.User) error {
+	if err := u.Status.Valid(); err != nil {
+		return err
+	}
+
+	if _, err := s.marshalUser(u); err != nil {
+		return err
+	}
+
+	if err := s.validateUserName(u.Name); err != nil {
+		return err
+	}
+
+	return s.validateUserFields(u)
+}
@@ -0,0 +1,79 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/inmem"
+	"github.com/influxdata/influxdb/kv"
+	"github.com/influxdata/influxdb/tenant"
+)
+
+func TestValidateAllUsers(t *testing.T) {
+	ctx := context.Background()
+	kvStore := inmem.NewKVStore()
+	lax, err := tenant.NewStore(kvStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = lax.Update(ctx, func(tx kv.Tx) error {
+		for i := 1; i <= 3; i++ {
+			if err := lax.CreateUser(ctx, tx, &influxdb.User{ID: influxdb.ID(i), Name: fmt.Sprintf("user%d", i), Status: "active"}); err != nil {
+				return err
+			}
+		}
+		// written before names had to be lower case
+		if err := lax.CreateUser(ctx, tx, &influxdb.User{ID: 4, Name: "User4", Status: "active"}); err != nil {
+			return err
+		}
+		// written by a client that didn't check the status
+		return lax.CreateUser(ctx, tx, &influxdb.User{ID: 5, Name: "user5", Status: "suspended"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strict, err := tenant.NewStore(kvStore, tenant.WithNameValidator(func(name string) error {
+		if strings.ToLower(name) != name {
+			return errors.New("name must be lower case")
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var es []tenant.UserValidationError
+	err = strict.View(ctx, func(tx kv.Tx) error {
+		var err error
+		es, err = strict.ValidateAllUsers(ctx, tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(es) != 2 || es[0].ID != 4 || es[1].ID != 5 {
+		t.Fatalf("expected only the invalid users to be reported, got: %+v", es)
+	}
+	if influxdb.ErrorCode(es[0].Err) != influxdb.EInvalid {
+		t.Fatalf("expected the name validator's error, got: %v", es[0].Err)
+	}
+	if influxdb.ErrorCode(es[1].Err) != influxdb.EInvalid || !strings.Contains(es[1].Err.Error(), "invalid status") {
+		t.Fatalf("expected the status to be checked without strict status, got: %v", es[1].Err)
+	}
+
+	// nothing was changed
+	err = strict.View(ctx, func(tx kv.Tx) error {
+		_, err := strict.GetUser(ctx, tx, 4)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected the invalid user to be left in place: %v", err)
+	}
+}
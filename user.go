@@ -2,6 +2,7 @@ package influxdb
 
 import (
 	"context"
+	"time"
 )
 
 // UserStatus indicates whether a user is active or inactive
@@ -22,6 +23,23 @@ type User struct {
 	Name    string `json:"name"`
 	OAuthID string `json:"oauthID,omitempty"`
 	Status  Status `json:"status"`
+	// Email is the user's contact address, it is optional.
+	Email string `json:"email,omitempty"`
+	// CreatedAt is when the user was created, it is nil for stores that
+	// don't track it.
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	// UpdatedAt is when the user was last updated, it is nil for stores
+	// that don't track it.
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	// Labels are arbitrary key value pairs users can be found by.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Flags are the features enabled or disabled for the user.
+	Flags map[string]bool `json:"flags,omitempty"`
+	// DeletedAt is when the user was soft deleted, it is nil for live users.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// ExpiresAt is when an ephemeral user may be reaped, it is nil for users
+	// that don't expire.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 }
 
 // Valid validates user
@@ -68,6 +86,12 @@ type UserService interface {
 type UserUpdate struct {
 	Name   *string `json:"name"`
 	Status *Status `json:"status"`
+	// Labels replaces the user's labels when it is not nil, an empty map
+	// removes them all.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Flags replaces the user's feature flags when it is not nil, an empty
+	// map removes them all.
+	Flags map[string]bool `json:"flags,omitempty"`
 }
 
 // Valid validates UserUpdate